@@ -62,19 +62,16 @@ az sql db create \
 # Wait a moment for database to be ready
 sleep 10
 
-# Apply SQL script using sqlcmd
-echo "Applying vector.sql script..."
-if command -v sqlcmd &> /dev/null; then
-    sqlcmd -S "$server_name.database.windows.net" \
-        -d "$database_name" \
-        -U "$admin_user" \
-        -P "$admin_password" \
-        -i vector.sql
+# Create the database schema and sample data with the sample's migrate command
+echo "Applying database migrations..."
+connection_string="sqlserver://$admin_user:$admin_password@$server_name.database.windows.net?database=$database_name"
+if command -v go &> /dev/null; then
+    go run . -dbconn "$connection_string" migrate
     echo "Database schema applied successfully!"
 else
-    echo "Warning: sqlcmd not found. Please install SQL Server command-line tools."
-    echo "You can manually apply vector.sql using:"
-    echo "sqlcmd -S $server_name.database.windows.net -d $database_name -U $admin_user -P $admin_password -i vector.sql"
+    echo "Warning: go not found. Please install Go."
+    echo "You can manually apply the migrations using:"
+    echo "go run . -dbconn \"$connection_string\" migrate"
 fi
 
 echo ""
@@ -85,5 +82,5 @@ echo "Admin User: $admin_user"
 echo "Admin Password: $admin_password"
 echo ""
 echo "Go connection string:"
-echo "sqlserver://$admin_user:$admin_password@$server_name.database.windows.net?database=$database_name"
+echo "$connection_string"
 # echo "Server=tcp:$server_name.database.windows.net,1433;Database=$database_name;User ID=$admin_user;Password=$admin_password;Encrypt=true;Connection Timeout=30;"
@@ -10,40 +10,72 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/core/api"
 	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/azure/azopenai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/chunking"
+	"github.com/joergjo/genkit-go-samples/vectorstore/indexing"
+	"github.com/joergjo/genkit-go-samples/vectorstore/shows"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+	mssql "github.com/microsoft/go-mssqldb"
 	"github.com/microsoft/go-mssqldb/azuread"
 )
 
 const (
 	provider     = "azsql"
 	embedderName = "text-embedding-3-small"
+	// modelName is the chat model that answers questions. With the Azure OpenAI
+	// v1 API, it must match the name of your deployment.
+	modelName = "gpt-5-mini"
+	// embedderDimensions is the number of dimensions of the embedder's vectors.
+	// Run the migrate command after changing it.
+	embedderDimensions = 1536
 )
 
 var (
-	connString = flag.String("dbconn", "", "database connection string")
-	index      = flag.Bool("index", false, "index the existing data")
+	connString  = flag.String("dbconn", "", "database connection string")
+	index       = flag.Bool("index", false, "index the existing data")
+	batch       = flag.Bool("batch", false, "use an Azure OpenAI batch job to index the existing data")
+	batchState  = flag.String("batchstate", "index-batch.json", "file to save the state of the indexing batch job to")
+	embedBatch  = flag.Int("embedbatch", indexing.DefaultBatchSize, "number of rows embedded and written per batch")
+	concurrency = flag.Int("concurrency", indexing.DefaultConcurrency, "number of batches embedded and written concurrently")
 )
 
 func main() {
 	baseURL := os.Getenv("AZ_OPENAI_BASE_URL")
 	apiKey := os.Getenv("AZ_OPENAI_API_KEY")
+	flag.Parse()
+	ctx := context.Background()
+	if flag.Arg(0) == "migrate" {
+		// Migrations don't need Azure OpenAI
+		if err := migrateSchema(ctx, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if baseURL == "" || apiKey == "" {
 		log.Fatal("export AZ_OPENAI_BASE_URL and AZ_OPENAI_API_KEY to run this sample")
 	}
 
-	flag.Parse()
-	ctx := context.Background()
-	aoai := &AzureOpenAI{
-		BaseURL: baseURL,
-		APIKey:  apiKey,
+	aoai, err := azopenai.New(baseURL,
+		azopenai.WithAPIKey(apiKey),
+		// Pace indexing requests to stay within the deployment's quota. Adjust
+		// the limits to match your deployment's RPM and TPM.
+		azopenai.WithRateLimit(azopenai.RateLimit{RequestsPerMinute: 120, TokensPerMinute: 120000}),
+		// Request vectors with the dimensions of the embedding column
+		azopenai.WithEmbedder(embedderName, azopenai.Deployment{Dimensions: embedderDimensions}),
 		// The sample assumes the use of the Azure OpenAI v1 API version.
 		// If you want to use 2024-10-21 instead, make sure to deploy the
 		// text-embedding-3-small model with exactly that deployment name and
 		// uncomment the following line.
-		// Deployment: embedderName,
+		// azopenai.WithDeployment(embedderName),
+	)
+	if err != nil {
+		log.Fatal(err)
 	}
 	g := genkit.Init(ctx, genkit.WithPlugins(aoai))
 	if err := run(g, aoai); err != nil {
@@ -51,7 +83,7 @@ func main() {
 	}
 }
 
-func run(g *genkit.Genkit, aoai *AzureOpenAI) error {
+func run(g *genkit.Genkit, aoai *azopenai.AzureOpenAI) error {
 	if *connString == "" {
 		return errors.New("need -dbconn")
 	}
@@ -67,39 +99,36 @@ func run(g *genkit.Genkit, aoai *AzureOpenAI) error {
 	}
 	defer db.Close()
 
+	if flag.Arg(0) == "ingest" {
+		return ingest(ctx, g, aoai, db, embedder, flag.Args()[1:])
+	}
 	if *index {
-		if err := indexExistingRows(ctx, g, db, embedder); err != nil {
+		if err := indexExistingRows(ctx, g, aoai, db, embedder); err != nil {
 			return err
 		}
 	}
 
 	retOpts := &ai.RetrieverOptions{
-		ConfigSchema: nil,
+		ConfigSchema: core.InferSchemaMap(RetrieverConfig{}),
 		Label:        "azureSQL",
 		Supports: &ai.RetrieverSupports{
 			Media: false,
 		},
 	}
-	retriever := defineRetriever(g, db, embedder, retOpts)
+	store := newStore(g, db, embedder)
+	retriever := defineRetriever(g, store, retOpts)
+	// Index documents from the Developer UI or other Genkit clients
+	sqlstore.DefineIndexer(g, api.NewName(provider, "shows"), store, &sqlstore.IndexerOptions{
+		Label:   "azureSQL",
+		Batches: indexing.Options{BatchSize: *embedBatch, Concurrency: *concurrency},
+	})
 
-	type input struct {
-		Question string
-		Show     string
+	model := aoai.Model(g, modelName)
+	if model == nil {
+		return fmt.Errorf("failed to create model %s", modelName)
 	}
-
-	genkit.DefineFlow(g, "askQuestion", func(ctx context.Context, in input) (string, error) {
-		res, err := genkit.Retrieve(ctx, g,
-			ai.WithRetriever(retriever),
-			ai.WithConfig(in.Show),
-			ai.WithTextDocs(in.Question))
-		if err != nil {
-			return "", err
-		}
-		for _, doc := range res.Documents {
-			fmt.Printf("%+v %q\n", doc.Metadata, doc.Content[0].Text)
-		}
-		// Use documents in RAG prompts.
-		return "", nil
+	shows.DefineFlow(g, retriever, model, func(cfg sqlstore.Config) any {
+		return &RetrieverConfig{Config: cfg}
 	})
 
 	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
@@ -109,99 +138,195 @@ func run(g *genkit.Genkit, aoai *AzureOpenAI) error {
 	return nil
 }
 
-func defineRetriever(g *genkit.Genkit, db *sql.DB, embedder ai.Embedder, retOpts *ai.RetrieverOptions) ai.Retriever {
+// RetrieverConfig configures the shows retriever. Pass it with ai.WithConfig.
+// Filters may refer to the key columns of showsTable and to keys of its metadata
+// column, e.g. "metadata.genre".
+type RetrieverConfig struct {
+	// TextSearch selects the full-text search function used with Hybrid:
+	// "freetext" (FREETEXTTABLE, the default) matches the meaning of the
+	// query, "contains" (CONTAINSTABLE) expects a CONTAINS search condition,
+	// e.g. `"Natasha" AND "Pierre"`.
+	TextSearch string `json:"textSearch,omitempty"`
+	// Approximate searches the vector index created by the migrate command with
+	// VECTOR_SEARCH instead of comparing the query with every row. The index must
+	// have been created for Metric. Ignored with Hybrid.
+	Approximate bool `json:"approximate,omitempty"`
+	sqlstore.Config
+}
+
+// textSearchFunctions are the full-text search functions for each TextSearch.
+var textSearchFunctions = map[string]string{
+	"freetext": "FREETEXTTABLE",
+	"contains": "CONTAINSTABLE",
+}
+
+// keyFields are the metadata keys of a document that hold the primary key of
+// its row.
+var keyFields = []string{"show_id", "season_number", "episode_id", "chunk_index"}
+
+// showsTable maps the embeddings table to documents. Full-text search requires
+// the full-text index created by migrations/005_full_text_search.sql.
+var showsTable = sqlstore.Table{
+	Name:       "embeddings",
+	Key:        keyFields,
+	Content:    "chunk",
+	Embedding:  "embedding",
+	Metadata:   "metadata",
+	ID:         "id",
+	TextSearch: "chunk",
+}
+
+// vectorType is the type of the embedding column.
+var vectorType = fmt.Sprintf("VECTOR(%d)", embedderDimensions)
+
+// newStore returns the store of the shows retriever and indexer. Rows are written
+// with writeEmbeddings, which sends them as a table-valued parameter.
+func newStore(g *genkit.Genkit, db *sql.DB, embedder ai.Embedder) *sqlstore.Store {
+	return &sqlstore.Store{
+		DB:      db,
+		Dialect: sqlstore.SQLServer{Dimensions: embedderDimensions},
+		Table:   showsTable,
+		Embed:   indexing.GenkitEmbedder(g, embedder),
+		Writer: func(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+			return writeEmbeddings(ctx, db, docs, embeddings)
+		},
+	}
+}
+
+// defineRetriever defines the shows retriever, which searches store with the
+// TextSearch and Approximate options of each request.
+func defineRetriever(g *genkit.Genkit, store *sqlstore.Store, retOpts *ai.RetrieverOptions) ai.Retriever {
 	f := func(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
-		eres, err := genkit.Embed(ctx, g,
-			ai.WithEmbedder(embedder),
-			ai.WithDocs(req.Query))
+		cfg, err := sqlstore.DecodeOptions[RetrieverConfig](req.Options)
 		if err != nil {
 			return nil, err
 		}
-		vector, err := json.Marshal(eres.Embeddings[0].Embedding)
-		if err != nil {
-			return nil, err
-		}
-
-		rows, err := db.QueryContext(ctx, `
-			SELECT TOP(2) episode_id, season_number, chunk as content
-			FROM embeddings
-			WHERE show_id = @show_id
-		  	ORDER BY VECTOR_DISTANCE('dot', embedding, CAST(@embedding AS VECTOR(1536)))
-			`,
-			sql.Named("show_id", req.Options),
-			sql.Named("embedding", string(vector)))
-		if err != nil {
-			return nil, err
+		if cfg.TextSearch == "" {
+			cfg.TextSearch = "freetext"
 		}
-		defer rows.Close()
-
-		res := &ai.RetrieverResponse{}
-		for rows.Next() {
-			var eid, sn int
-			var content string
-			if err := rows.Scan(&eid, &sn, &content); err != nil {
-				return nil, err
-			}
-			meta := map[string]any{
-				"episode_id":    eid,
-				"season_number": sn,
-			}
-			doc := &ai.Document{
-				Content:  []*ai.Part{ai.NewTextPart(content)},
-				Metadata: meta,
-			}
-			res.Documents = append(res.Documents, doc)
+		fn, ok := textSearchFunctions[cfg.TextSearch]
+		if !ok {
+			return nil, fmt.Errorf("unknown text search %q, must be freetext or contains", cfg.TextSearch)
 		}
-		if err := rows.Err(); err != nil {
+		s := *store
+		s.Dialect = sqlstore.SQLServer{Dimensions: embedderDimensions, TextSearch: fn, Approximate: cfg.Approximate}
+		docs, err := s.Search(ctx, req.Query, cfg.Config)
+		if err != nil {
 			return nil, err
 		}
-		return res, nil
+		return &ai.RetrieverResponse{Documents: docs}, nil
 	}
 	return genkit.DefineRetriever(g, api.NewName(provider, "shows"), retOpts, f)
 }
 
-// Helper function to get started with indexing
-func Index(ctx context.Context, g *genkit.Genkit, db *sql.DB, embedder ai.Embedder, docs []*ai.Document) error {
-	// The indexer assumes that each Document has a single part, to be embedded, and metadata fields
-	// for the table primary key: show_id, season_number, episode_id.
-	const query = `
-			UPDATE embeddings
-			SET embedding = @embedding
-			WHERE show_id = @show_id AND season_number = @season_number AND episode_id = @episode_id
-		`
-	res, err := genkit.Embed(ctx, g,
-		ai.WithEmbedder(embedder),
-		ai.WithDocs(docs...))
+// indexDocs embeds docs and writes them to the database in batches of -embedbatch
+// documents, of which up to -concurrency are processed at the same time. With -batch,
+// docs are embedded by an Azure OpenAI batch job, which may take up to 24 hours. The
+// job's state is saved to -batchstate, so that an interrupted run resumes waiting for
+// the same job. It returns an error if any document failed, after all other documents
+// have been written.
+func indexDocs(ctx context.Context, aoai *azopenai.AzureOpenAI, store *sqlstore.Store, docs []*ai.Document) error {
+	// The documents have a single part, to be embedded, and metadata fields for the
+	// table primary key: show_id, season_number, episode_id, chunk_index.
+	opts := indexing.Options{
+		BatchSize:   *embedBatch,
+		Concurrency: *concurrency,
+		Progress: func(p indexing.Progress) {
+			log.Printf("Indexed %d of %d rows (%d failed)", p.Done, p.Total, p.Failed)
+		},
+	}
+	var stats indexing.Stats
+	var err error
+	if *batch {
+		stats, err = shows.IndexBatch(ctx, aoai, store, docs, shows.BatchOptions{Embedder: embedderName, StateFile: *batchState, Writes: opts})
+	} else {
+		stats, err = shows.Index(ctx, store, docs, opts)
+	}
 	if err != nil {
 		return err
 	}
-	// You may want to use your database's batch functionality to insert the embeddings
-	// more efficiently.
-	for i, emb := range res.Embeddings {
-		doc := docs[i]
-		args := make([]any, 4)
-		for j, k := range []string{"show_id", "season_number", "episode_id"} {
-			if a, ok := doc.Metadata[k]; ok {
-				args[j] = sql.Named(k, a)
-			} else {
-				return fmt.Errorf("doc[%d]: missing metadata key %q", i, k)
-			}
+	if stats.Failed > 0 {
+		return fmt.Errorf("failed to index %d of %d rows", stats.Failed, len(docs))
+	}
+	return nil
+}
+
+// embeddingUpdate is a row of the EmbeddingUpdate table type, see
+// migrations/007_embedding_update_metadata.sql.
+type embeddingUpdate struct {
+	ShowID       string
+	SeasonNumber int32
+	EpisodeID    int32
+	ChunkIndex   int32
+	Chunk        string
+	// Embedding is the JSON array of the embedding, which is cast to vectorType.
+	Embedding string
+	// Metadata is null if the row's metadata is kept, see sqlstore.Table.RowMetadata.
+	Metadata sql.NullString
+}
+
+func newEmbeddingUpdate(doc *ai.Document, embedding []float32) (embeddingUpdate, error) {
+	key, err := showsTable.KeyArgs(doc)
+	if err != nil {
+		return embeddingUpdate{}, err
+	}
+	vector, err := json.Marshal(embedding)
+	if err != nil {
+		return embeddingUpdate{}, err
+	}
+	metadata, err := showsTable.RowMetadata(doc)
+	if err != nil {
+		return embeddingUpdate{}, err
+	}
+	var ints [3]int32
+	for i, k := range []string{"season_number", "episode_id", "chunk_index"} {
+		n, ok := key[i+1].(int)
+		if !ok {
+			return embeddingUpdate{}, fmt.Errorf("metadata key %q must be an int, got %T", k, key[i+1])
 		}
-		vector, err := json.Marshal(emb.Embedding)
+		ints[i] = int32(n)
+	}
+	return embeddingUpdate{
+		ShowID:       fmt.Sprint(key[0]),
+		SeasonNumber: ints[0],
+		EpisodeID:    ints[1],
+		ChunkIndex:   ints[2],
+		Chunk:        doc.Content[0].Text,
+		Embedding:    string(vector),
+		Metadata:     metadata,
+	}, nil
+}
+
+// writeEmbeddings upserts the chunks, embeddings, and metadata of docs with a single MERGE statement.
+// The rows are sent as a table-valued parameter, which is much faster than sending a
+// statement per row.
+func writeEmbeddings(ctx context.Context, db *sql.DB, docs []*ai.Document, embeddings [][]float32) error {
+	rows := make([]embeddingUpdate, len(docs))
+	for i, doc := range docs {
+		u, err := newEmbeddingUpdate(doc, embeddings[i])
 		if err != nil {
-			return err
-		}
-		args[3] = sql.Named("embedding", string(vector))
-		if _, err := db.ExecContext(ctx, query, args...); err != nil {
-			return err
+			return fmt.Errorf("doc[%d]: %w", i, err)
 		}
+		rows[i] = u
 	}
-	return nil
-
+	_, err := db.ExecContext(ctx, `
+			MERGE embeddings AS e
+			USING @rows AS u
+			ON e.show_id = u.show_id AND e.season_number = u.season_number AND e.episode_id = u.episode_id
+				AND e.chunk_index = u.chunk_index
+			WHEN MATCHED THEN
+				UPDATE SET chunk = u.chunk, embedding = CAST(u.embedding AS `+vectorType+`),
+					metadata = COALESCE(u.metadata, e.metadata)
+			WHEN NOT MATCHED THEN
+				INSERT (show_id, season_number, episode_id, chunk_index, chunk, embedding, metadata)
+				VALUES (u.show_id, u.season_number, u.episode_id, u.chunk_index, u.chunk,
+					CAST(u.embedding AS `+vectorType+`), u.metadata);`,
+		sql.Named("rows", mssql.TVP{TypeName: "EmbeddingUpdate", Value: rows}))
+	return err
 }
 
-func indexExistingRows(ctx context.Context, g *genkit.Genkit, db *sql.DB, embedder ai.Embedder) error {
-	rows, err := db.QueryContext(ctx, `SELECT show_id, season_number, episode_id, chunk FROM embeddings`)
+func indexExistingRows(ctx context.Context, g *genkit.Genkit, aoai *azopenai.AzureOpenAI, db *sql.DB, embedder ai.Embedder) error {
+	rows, err := db.QueryContext(ctx, `SELECT show_id, season_number, episode_id, chunk_index, chunk FROM embeddings`)
 	if err != nil {
 		return err
 	}
@@ -210,8 +335,8 @@ func indexExistingRows(ctx context.Context, g *genkit.Genkit, db *sql.DB, embedd
 	var docs []*ai.Document
 	for rows.Next() {
 		var sid, chunk string
-		var sn, eid int
-		if err := rows.Scan(&sid, &sn, &eid, &chunk); err != nil {
+		var sn, eid, ci int
+		if err := rows.Scan(&sid, &sn, &eid, &ci, &chunk); err != nil {
 			return err
 		}
 		docs = append(docs, &ai.Document{
@@ -220,11 +345,39 @@ func indexExistingRows(ctx context.Context, g *genkit.Genkit, db *sql.DB, embedd
 				"show_id":       sid,
 				"season_number": sn,
 				"episode_id":    eid,
+				"chunk_index":   ci,
 			},
 		})
 	}
 	if err := rows.Err(); err != nil {
 		return err
 	}
-	return Index(ctx, g, db, embedder, docs)
+	return indexDocs(ctx, aoai, newStore(g, db, embedder), docs)
+}
+
+// ingest splits the episode scripts in a directory into chunks, replaces the
+// chunks of each episode in the database, and embeds all chunks.
+func ingest(ctx context.Context, g *genkit.Genkit, aoai *azopenai.AzureOpenAI, db *sql.DB, embedder ai.Embedder, args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	strategy := flags.String("strategy", chunking.StrategyRecursive, "chunking strategy: "+strings.Join(chunking.Strategies, ", "))
+	size := flags.Int("size", 1000, "maximum chunk size in characters, or in tokens for the token strategy")
+	overlap := flags.Int("overlap", 100, "number of characters (or tokens) shared by consecutive chunks")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: ingest [-strategy name] [-size n] [-overlap n] <dir>")
+	}
+	store := newStore(g, db, embedder)
+	docs, err := shows.Ingest(ctx, store, flags.Arg(0), shows.IngestOptions{
+		Chunking: chunking.Options{Strategy: *strategy, Size: *size, Overlap: *overlap},
+		Progress: func(ep shows.Episode, chunks int) {
+			log.Printf("%s: %d chunks", ep, chunks)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	return indexDocs(ctx, aoai, store, docs)
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/migrate"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+	"github.com/microsoft/go-mssqldb/azuread"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// vectorIndexName is the name of the vector index on embeddings.embedding.
+const vectorIndexName = "embeddings_embedding_idx"
+
+// distanceMetrics are the metrics of vector indexes for each metric, which are
+// the metrics of VECTOR_DISTANCE.
+var distanceMetrics = map[retrieval.Metric]string{
+	retrieval.MetricCosine: "cosine",
+	retrieval.MetricL2:     "euclidean",
+	retrieval.MetricDot:    "dot",
+}
+
+// vectorIndex configures the vector index created by migrateSchema.
+type vectorIndex struct {
+	// Type is "diskann" or "none".
+	Type   string
+	Metric retrieval.Metric
+	// MaxDOP is the maximum degree of parallelism used to build the index, 0 for
+	// the database's default.
+	MaxDOP int
+}
+
+// definition returns the statement that creates the index.
+func (v vectorIndex) definition() string {
+	with := fmt.Sprintf("METRIC = '%s', TYPE = 'DiskANN'", distanceMetrics[v.Metric])
+	if v.MaxDOP > 0 {
+		with += fmt.Sprintf(", MAXDOP = %d", v.MaxDOP)
+	}
+	return fmt.Sprintf("CREATE VECTOR INDEX %s ON embeddings (embedding) WITH (%s)", vectorIndexName, with)
+}
+
+// migrateSchema applies the embedded migrations, changes the dimensions of the
+// embedding column if embedderDimensions has changed, and creates, replaces, or
+// drops the vector index.
+func migrateSchema(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	indexType := flags.String("vectorindex", "none", "vector index type: diskann or none")
+	metric := flags.String("metric", string(retrieval.DefaultMetric), "distance metric of the vector index: cosine, l2, or dot")
+	maxDOP := flags.Int("maxdop", 0, "maximum degree of parallelism used to build the vector index")
+	flags.Parse(args)
+
+	idx := vectorIndex{Type: *indexType, Metric: retrieval.Metric(*metric), MaxDOP: *maxDOP}
+	if _, ok := distanceMetrics[idx.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q", *metric)
+	}
+	if idx.Type != "diskann" && idx.Type != "none" {
+		return fmt.Errorf("unknown vector index type %q", idx.Type)
+	}
+
+	if *connString == "" {
+		return errors.New("need -dbconn")
+	}
+	db, err := sql.Open(azuread.DriverName, *connString)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	all, err := migrate.Load(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	applied, err := migrate.Up(ctx, db, migrate.SQLServer, all, struct{ Dimensions int }{embedderDimensions})
+	for _, mig := range applied {
+		log.Printf("Applied migration %03d_%s", mig.Version, mig.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := changeDimensions(ctx, db, embedderDimensions); err != nil {
+		return err
+	}
+	return updateVectorIndex(ctx, db, idx)
+}
+
+// changeDimensions changes the dimensions of the embedding column. All embeddings
+// are removed, so that the next -index run embeds all rows with the new dimensions.
+func changeDimensions(ctx context.Context, db *sql.DB, dimensions int) error {
+	var current int
+	if err := db.QueryRowContext(ctx, `
+			SELECT vector_dimensions FROM sys.columns
+			WHERE object_id = OBJECT_ID('embeddings') AND name = 'embedding'`).Scan(&current); err != nil {
+		return err
+	}
+	if current == dimensions {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// The index depends on the column's type and is recreated by updateVectorIndex
+	for _, stmt := range []string{
+		`DROP INDEX IF EXISTS ` + vectorIndexName + ` ON embeddings`,
+		`UPDATE embeddings SET embedding = NULL`,
+		fmt.Sprintf(`ALTER TABLE embeddings ALTER COLUMN embedding VECTOR(%d)`, dimensions),
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Changed embedding dimensions from %d to %d, run -index to embed all rows", current, dimensions)
+	return nil
+}
+
+// updateVectorIndex creates the vector index if it doesn't exist, replaces it if it
+// was created for a different metric, or drops it if idx.Type is "none".
+func updateVectorIndex(ctx context.Context, db *sql.DB, idx vectorIndex) error {
+	var metric sql.NullString
+	err := db.QueryRowContext(ctx, `
+			SELECT MAX(vi.distance_metric) FROM sys.vector_indexes vi
+			JOIN sys.indexes i ON i.object_id = vi.object_id AND i.index_id = vi.index_id
+			WHERE i.object_id = OBJECT_ID('embeddings') AND i.name = @p1`, vectorIndexName).Scan(&metric)
+	if err != nil {
+		return err
+	}
+	if idx.Type == "none" {
+		if !metric.Valid {
+			return nil
+		}
+		if _, err := db.ExecContext(ctx, `DROP INDEX `+vectorIndexName+` ON embeddings`); err != nil {
+			return err
+		}
+		log.Printf("Dropped vector index %s", vectorIndexName)
+		return nil
+	}
+	if metric.Valid && strings.EqualFold(metric.String, distanceMetrics[idx.Metric]) {
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, `DROP INDEX IF EXISTS `+vectorIndexName+` ON embeddings`); err != nil {
+		return err
+	}
+	want := idx.definition()
+	log.Printf("Creating vector index: %s", want)
+	_, err = db.ExecContext(ctx, want)
+	return err
+}
@@ -0,0 +1,22 @@
+-- Databases initialized by an earlier version of the sample already have this
+-- table, so all migrations are idempotent.
+IF OBJECT_ID('embeddings', 'U') IS NULL
+    CREATE TABLE embeddings (
+        show_id NVARCHAR(255) NOT NULL,
+        season_number INT NOT NULL,
+        episode_id INT NOT NULL,
+        chunk NVARCHAR(MAX),
+        embedding VECTOR({{.Dimensions}}),
+        CONSTRAINT PK_embeddings PRIMARY KEY (show_id, season_number, episode_id)
+    );
+GO
+
+IF NOT EXISTS (SELECT * FROM embeddings)
+    INSERT INTO embeddings (show_id, season_number, episode_id, chunk) VALUES
+        ('La Vie', 1,  1,  'Natasha confesses her love for Pierre.'),
+        ('La Vie', 1,  2,  'Pierre and Natasha become engaged.'),
+        ('La Vie', 1,  3,  'Margot and Henri divorce.'),
+        ('Best Friends', 1,  1,  'Alice confesses her love for Oscar.'),
+        ('Best Friends', 1,  2,  'Oscar and Alice become engaged.'),
+        ('Best Friends', 1,  3,  'Bob and Pat divorce.');
+GO
@@ -0,0 +1,24 @@
+-- Each episode is split into one or more chunks. start_offset and end_offset are
+-- the byte offsets of a chunk in the episode's script, and NULL for hand-written
+-- chunks.
+IF COL_LENGTH('embeddings', 'chunk_index') IS NULL
+    ALTER TABLE embeddings ADD
+        chunk_index INT NOT NULL CONSTRAINT DF_embeddings_chunk_index DEFAULT 0,
+        start_offset INT,
+        end_offset INT;
+GO
+
+-- The primary key created by earlier versions of the sample has a generated name
+IF NOT EXISTS (
+    SELECT * FROM sys.index_columns ic
+    JOIN sys.indexes i ON i.object_id = ic.object_id AND i.index_id = ic.index_id
+    WHERE i.object_id = OBJECT_ID('embeddings') AND i.is_primary_key = 1
+        AND COL_NAME(ic.object_id, ic.column_id) = 'chunk_index')
+BEGIN
+    DECLARE @pk SYSNAME = (
+        SELECT name FROM sys.key_constraints
+        WHERE parent_object_id = OBJECT_ID('embeddings') AND type = 'PK');
+    EXEC ('ALTER TABLE embeddings DROP CONSTRAINT ' + QUOTENAME(@pk));
+    ALTER TABLE embeddings ADD CONSTRAINT PK_embeddings PRIMARY KEY (show_id, season_number, episode_id, chunk_index);
+END
+GO
@@ -0,0 +1,13 @@
+-- Batches of embeddings are written with a table-valued parameter of this type.
+-- embedding is the JSON array of the embedding.
+IF TYPE_ID('EmbeddingUpdate') IS NULL
+    CREATE TYPE EmbeddingUpdate AS TABLE (
+        show_id NVARCHAR(255) NOT NULL,
+        season_number INT NOT NULL,
+        episode_id INT NOT NULL,
+        chunk_index INT NOT NULL,
+        chunk NVARCHAR(MAX),
+        embedding NVARCHAR(MAX) NOT NULL,
+        PRIMARY KEY (show_id, season_number, episode_id, chunk_index)
+    );
+GO
@@ -0,0 +1,9 @@
+-- metadata holds arbitrary JSON attributes of a chunk, which retrievers can
+-- filter on, e.g. {"genre": "drama"}.
+IF COL_LENGTH('embeddings', 'metadata') IS NULL
+    ALTER TABLE embeddings ADD metadata NVARCHAR(MAX) CONSTRAINT CK_embeddings_metadata CHECK (ISJSON(metadata) = 1);
+GO
+
+UPDATE embeddings SET metadata = '{"genre": "drama"}' WHERE show_id = 'La Vie' AND metadata IS NULL;
+UPDATE embeddings SET metadata = '{"genre": "comedy"}' WHERE show_id = 'Best Friends' AND metadata IS NULL;
+GO
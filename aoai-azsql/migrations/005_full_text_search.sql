@@ -0,0 +1,18 @@
+-- migrate:no-transaction
+-- Creates the full-text index on chunk used by hybrid search. Full-text
+-- statements can't run inside a transaction.
+
+-- A full-text index requires a unique, single-column key
+IF COL_LENGTH('embeddings', 'id') IS NULL
+    ALTER TABLE embeddings ADD id INT IDENTITY(1, 1) NOT NULL CONSTRAINT UQ_embeddings_id UNIQUE;
+GO
+
+IF NOT EXISTS (SELECT * FROM sys.fulltext_catalogs WHERE name = 'embeddings_catalog')
+    CREATE FULLTEXT CATALOG embeddings_catalog;
+GO
+
+IF NOT EXISTS (SELECT * FROM sys.fulltext_indexes WHERE object_id = OBJECT_ID('embeddings'))
+    CREATE FULLTEXT INDEX ON embeddings (chunk LANGUAGE 1033)
+        KEY INDEX UQ_embeddings_id ON embeddings_catalog
+        WITH CHANGE_TRACKING AUTO;
+GO
@@ -0,0 +1,14 @@
+-- Vector indexes require a clustered primary key on a single integer column, so
+-- id becomes the primary key. The chunk's key stays unique, which the MERGE
+-- statement that writes embeddings relies on.
+IF NOT EXISTS (
+    SELECT * FROM sys.index_columns ic
+    JOIN sys.indexes i ON i.object_id = ic.object_id AND i.index_id = ic.index_id
+    WHERE i.object_id = OBJECT_ID('embeddings') AND i.is_primary_key = 1
+        AND COL_NAME(ic.object_id, ic.column_id) = 'id')
+BEGIN
+    ALTER TABLE embeddings DROP CONSTRAINT PK_embeddings;
+    ALTER TABLE embeddings ADD CONSTRAINT PK_embeddings PRIMARY KEY CLUSTERED (id);
+    ALTER TABLE embeddings ADD CONSTRAINT UQ_embeddings_chunk UNIQUE (show_id, season_number, episode_id, chunk_index);
+END
+GO
@@ -0,0 +1,21 @@
+-- The shows indexer writes the metadata of documents with their embeddings.
+-- Table types can't be altered, so EmbeddingUpdate is replaced if it has no
+-- metadata column. metadata is NULL for rows whose metadata is kept.
+IF NOT EXISTS (
+    SELECT * FROM sys.table_types t
+    JOIN sys.columns c ON c.object_id = t.type_table_object_id
+    WHERE t.name = 'EmbeddingUpdate' AND c.name = 'metadata')
+BEGIN
+    DROP TYPE IF EXISTS EmbeddingUpdate;
+    CREATE TYPE EmbeddingUpdate AS TABLE (
+        show_id NVARCHAR(255) NOT NULL,
+        season_number INT NOT NULL,
+        episode_id INT NOT NULL,
+        chunk_index INT NOT NULL,
+        chunk NVARCHAR(MAX),
+        embedding NVARCHAR(MAX) NOT NULL,
+        metadata NVARCHAR(MAX),
+        PRIMARY KEY (show_id, season_number, episode_id, chunk_index)
+    );
+END
+GO
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/migrate"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// vectorIndexName is the name of the approximate nearest neighbor index on
+// embeddings.embedding.
+const vectorIndexName = "embeddings_embedding_idx"
+
+// operatorClasses are pgvector's index operator classes for each metric. An
+// index is only used by queries with the operator of the same metric, see
+// sqlstore.Postgres.
+var operatorClasses = map[retrieval.Metric]string{
+	retrieval.MetricCosine: "vector_cosine_ops",
+	retrieval.MetricL2:     "vector_l2_ops",
+	retrieval.MetricDot:    "vector_ip_ops",
+}
+
+// vectorIndex configures the vector index created by migrateSchema.
+type vectorIndex struct {
+	// Type is "hnsw", "ivfflat", or "none".
+	Type   string
+	Metric retrieval.Metric
+	// M and EfConstruction are the parameters of an HNSW index.
+	M              int
+	EfConstruction int
+	// Lists is the number of lists of an IVFFlat index.
+	Lists int
+}
+
+// definition returns the statement that creates the index.
+func (v vectorIndex) definition() string {
+	var with string
+	switch v.Type {
+	case "hnsw":
+		with = fmt.Sprintf("m = %d, ef_construction = %d", v.M, v.EfConstruction)
+	case "ivfflat":
+		with = fmt.Sprintf("lists = %d", v.Lists)
+	}
+	return fmt.Sprintf("CREATE INDEX %s ON embeddings USING %s (embedding %s) WITH (%s)",
+		vectorIndexName, v.Type, operatorClasses[v.Metric], with)
+}
+
+// migrateSchema applies the embedded migrations, changes the dimensions of the
+// embedding column if embedderDimensions has changed, and creates, replaces, or
+// drops the vector index.
+func migrateSchema(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	indexType := flags.String("vectorindex", "hnsw", "vector index type: hnsw, ivfflat, or none")
+	metric := flags.String("metric", string(retrieval.DefaultMetric), "distance metric of the vector index: cosine, l2, or dot")
+	m := flags.Int("m", 16, "maximum number of connections per layer of an HNSW index")
+	efConstruction := flags.Int("efconstruction", 64, "size of the candidate list used to build an HNSW index")
+	lists := flags.Int("lists", 100, "number of lists of an IVFFlat index, e.g. rows / 1000")
+	flags.Parse(args)
+
+	idx := vectorIndex{
+		Type:           *indexType,
+		Metric:         retrieval.Metric(*metric),
+		M:              *m,
+		EfConstruction: *efConstruction,
+		Lists:          *lists,
+	}
+	if _, ok := operatorClasses[idx.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q", *metric)
+	}
+	switch idx.Type {
+	case "hnsw", "ivfflat", "none":
+	default:
+		return fmt.Errorf("unknown vector index type %q", idx.Type)
+	}
+
+	db, err := sql.Open("postgres", *connString)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	all, err := migrate.Load(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	applied, err := migrate.Up(ctx, db, migrate.Postgres, all, struct{ Dimensions int }{embedderDimensions})
+	for _, mig := range applied {
+		log.Printf("Applied migration %03d_%s", mig.Version, mig.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := changeDimensions(ctx, db, embedderDimensions); err != nil {
+		return err
+	}
+	return updateVectorIndex(ctx, db, idx)
+}
+
+// changeDimensions changes the dimensions of the embedding column. All embeddings
+// are removed, so that the next -index run embeds all rows with the new dimensions.
+func changeDimensions(ctx context.Context, db *sql.DB, dimensions int) error {
+	var current int
+	if err := db.QueryRowContext(ctx, `
+			SELECT atttypmod FROM pg_attribute
+			WHERE attrelid = 'embeddings'::regclass AND attname = 'embedding'`).Scan(&current); err != nil {
+		return err
+	}
+	if current == dimensions {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// The index depends on the column's type and is recreated by updateVectorIndex
+	if _, err := tx.ExecContext(ctx, `DROP INDEX IF EXISTS `+vectorIndexName); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			ALTER TABLE embeddings ALTER COLUMN embedding TYPE vector(%d) USING NULL`, dimensions)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+			UPDATE embeddings SET content_hash = NULL, embedding_model = NULL, embedding_version = NULL`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Changed embedding dimensions from %d to %d, run -index to embed all rows", current, dimensions)
+	return nil
+}
+
+// updateVectorIndex creates the vector index if it doesn't exist, replaces it if it
+// was created with a different definition, or drops it if idx.Type is "none". The
+// definition is stored as the index's comment.
+func updateVectorIndex(ctx context.Context, db *sql.DB, idx vectorIndex) error {
+	var exists bool
+	var current sql.NullString
+	if err := db.QueryRowContext(ctx, `
+			SELECT to_regclass($1) IS NOT NULL, obj_description(to_regclass($1), 'pg_class')`,
+		vectorIndexName).Scan(&exists, &current); err != nil {
+		return err
+	}
+	if idx.Type == "none" {
+		if !exists {
+			return nil
+		}
+		if _, err := db.ExecContext(ctx, `DROP INDEX `+vectorIndexName); err != nil {
+			return err
+		}
+		log.Printf("Dropped vector index %s", vectorIndexName)
+		return nil
+	}
+	want := idx.definition()
+	if exists && current.String == want {
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, `DROP INDEX IF EXISTS `+vectorIndexName); err != nil {
+		return err
+	}
+	log.Printf("Creating vector index: %s", want)
+	if _, err := db.ExecContext(ctx, want); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`COMMENT ON INDEX %s IS '%s'`, vectorIndexName, strings.ReplaceAll(want, "'", "''")))
+	return err
+}
@@ -1,20 +1,22 @@
 -- Enable the pgvector extension
 CREATE EXTENSION IF NOT EXISTS vector;
 
-CREATE TABLE embeddings (
+-- Databases initialized by an earlier version of the sample already have this
+-- table, so all migrations are idempotent.
+CREATE TABLE IF NOT EXISTS embeddings (
     show_id TEXT NOT NULL,
     season_number INTEGER NOT NULL,
     episode_id INTEGER NOT NULL,
     chunk TEXT,
-    embedding vector(1536),
+    embedding vector({{.Dimensions}}),
     PRIMARY KEY (show_id, season_number, episode_id)
 );
 
-INSERT INTO embeddings (show_id, season_number, episode_id, chunk) VALUES 
+INSERT INTO embeddings (show_id, season_number, episode_id, chunk) VALUES
 	('La Vie', 1,  1,  'Natasha confesses her love for Pierre.'),
 	('La Vie', 1,  2,  'Pierre and Natasha become engaged.'),
 	('La Vie', 1,  3,  'Margot and Henri divorce.'),
 	('Best Friends', 1,  1,  'Alice confesses her love for Oscar.'),
 	('Best Friends', 1,  2,  'Oscar and Alice become engaged.'),
 	('Best Friends', 1,  3,  'Bob and Pat divorce.')
-;
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,11 @@
+-- Each episode is split into one or more chunks. start_offset and end_offset are
+-- the byte offsets of a chunk in the episode's script, and NULL for hand-written
+-- chunks.
+ALTER TABLE embeddings
+    ADD COLUMN IF NOT EXISTS chunk_index INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS start_offset INTEGER,
+    ADD COLUMN IF NOT EXISTS end_offset INTEGER;
+
+ALTER TABLE embeddings
+    DROP CONSTRAINT embeddings_pkey,
+    ADD CONSTRAINT embeddings_pkey PRIMARY KEY (show_id, season_number, episode_id, chunk_index);
@@ -0,0 +1,7 @@
+-- content_hash is the SHA-256 hash of the chunk that embedding was created from,
+-- embedding_model and embedding_version identify the model that created it.
+-- Rows are only embedded again if one of them has changed.
+ALTER TABLE embeddings
+    ADD COLUMN IF NOT EXISTS content_hash TEXT,
+    ADD COLUMN IF NOT EXISTS embedding_model TEXT,
+    ADD COLUMN IF NOT EXISTS embedding_version TEXT;
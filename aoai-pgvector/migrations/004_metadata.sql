@@ -0,0 +1,6 @@
+-- metadata holds arbitrary JSON attributes of a chunk, which retrievers can
+-- filter on, e.g. {"genre": "drama"}.
+ALTER TABLE embeddings ADD COLUMN IF NOT EXISTS metadata JSONB;
+
+UPDATE embeddings SET metadata = '{"genre": "drama"}' WHERE show_id = 'La Vie' AND metadata IS NULL;
+UPDATE embeddings SET metadata = '{"genre": "comedy"}' WHERE show_id = 'Best Friends' AND metadata IS NULL;
@@ -0,0 +1,6 @@
+-- chunk_tsv is the chunk's text search vector, used by hybrid search.
+ALTER TABLE embeddings
+    ADD COLUMN IF NOT EXISTS chunk_tsv tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', coalesce(chunk, ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS embeddings_chunk_tsv_idx ON embeddings USING GIN (chunk_tsv);
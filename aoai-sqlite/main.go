@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	sqlitevec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/azure/azopenai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/chunking"
+	"github.com/joergjo/genkit-go-samples/vectorstore/indexing"
+	"github.com/joergjo/genkit-go-samples/vectorstore/shows"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	provider     = "sqlite"
+	embedderName = "text-embedding-3-small"
+	// modelName is the chat model that answers questions. With the Azure OpenAI
+	// v1 API, it must match the name of your deployment.
+	modelName = "gpt-5-mini"
+	// embedderDimensions is the number of dimensions of the embedder's vectors.
+	// Rows with embeddings of other dimensions are embedded again by -index.
+	embedderDimensions = 1536
+	// embedderVersion is stored with each embedding. Change it to re-embed all
+	// rows, e.g. after upgrading the embedding model's deployment.
+	embedderVersion = "1"
+)
+
+var (
+	connString  = flag.String("dbconn", "file:shows.db?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate", "database connection string")
+	index       = flag.Bool("index", false, "embed rows that are new or have changed")
+	embedBatch  = flag.Int("embedbatch", indexing.DefaultBatchSize, "number of rows embedded and written per batch")
+	concurrency = flag.Int("concurrency", indexing.DefaultConcurrency, "number of batches embedded and written concurrently")
+)
+
+func main() {
+	baseURL := os.Getenv("AZ_OPENAI_BASE_URL")
+	apiKey := os.Getenv("AZ_OPENAI_API_KEY")
+	flag.Parse()
+	ctx := context.Background()
+	if flag.Arg(0) == "migrate" {
+		// Migrations don't need Azure OpenAI
+		if err := migrateSchema(ctx); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if baseURL == "" || apiKey == "" {
+		log.Fatal("export AZ_OPENAI_BASE_URL and AZ_OPENAI_API_KEY to run this sample")
+	}
+
+	aoai, err := azopenai.New(baseURL,
+		azopenai.WithAPIKey(apiKey),
+		// Pace indexing requests to stay within the deployment's quota. Adjust
+		// the limits to match your deployment's RPM and TPM.
+		azopenai.WithRateLimit(azopenai.RateLimit{RequestsPerMinute: 120, TokensPerMinute: 120000}),
+		// Request vectors with embedderDimensions
+		azopenai.WithEmbedder(embedderName, azopenai.Deployment{Dimensions: embedderDimensions}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := genkit.Init(ctx, genkit.WithPlugins(aoai))
+	if err := run(g, aoai); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openDB opens the database. Every connection loads the sqlite-vec extension,
+// which provides the vector functions used by sqlstore.SQLite.
+func openDB() (*sql.DB, error) {
+	if *connString == "" {
+		return nil, errors.New("need -dbconn")
+	}
+	sqlitevec.Auto()
+	return sql.Open("sqlite3", *connString)
+}
+
+func run(g *genkit.Genkit, aoai *azopenai.AzureOpenAI) error {
+	ctx := context.Background()
+	embedder := aoai.Embedder(g, embedderName)
+	if embedder == nil {
+		return fmt.Errorf("failed to create embedder %s", embedderName)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if flag.Arg(0) == "ingest" {
+		return ingest(ctx, g, db, embedder, flag.Args()[1:])
+	}
+	if *index {
+		if err := indexExistingRows(ctx, g, db, embedder); err != nil {
+			return err
+		}
+	}
+
+	retriever := defineShows(g, db, embedder)
+
+	model := aoai.Model(g, modelName)
+	if model == nil {
+		return fmt.Errorf("failed to create model %s", modelName)
+	}
+	shows.DefineFlow(g, retriever, model, nil)
+
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	fmt.Println("Press Ctrl-C to stop")
+	<-sigCtx.Done()
+	return nil
+}
+
+// RetrieverConfig configures the shows retriever. Pass it with ai.WithConfig.
+// Filters may refer to the key columns of showsTable and to keys of its metadata
+// column, e.g. "metadata.genre".
+type RetrieverConfig = sqlstore.Config
+
+// keyFields are the metadata keys of a document that hold the primary key of
+// its row.
+var keyFields = []string{"show_id", "season_number", "episode_id", "chunk_index"}
+
+// showsTable maps the embeddings table to documents.
+var showsTable = sqlstore.Table{
+	Name:      "embeddings",
+	Key:       keyFields,
+	Content:   "chunk",
+	Embedding: "embedding",
+	Metadata:  "metadata",
+}
+
+// newStore returns the store of the shows retriever and indexer. Rows are written
+// with writeEmbeddings, which records the provenance of embeddings.
+func newStore(g *genkit.Genkit, db *sql.DB, embedder ai.Embedder) *sqlstore.Store {
+	return &sqlstore.Store{
+		DB:      db,
+		Dialect: sqlstore.SQLite{},
+		Table:   showsTable,
+		Embed:   indexing.GenkitEmbedder(g, embedder),
+		Writer: func(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+			return writeEmbeddings(ctx, db, docs, embeddings)
+		},
+	}
+}
+
+// defineShows defines the shows retriever and indexer of the embeddings table,
+// and returns the retriever.
+func defineShows(g *genkit.Genkit, db *sql.DB, embedder ai.Embedder) ai.Retriever {
+	retOpts := &ai.RetrieverOptions{
+		ConfigSchema: core.InferSchemaMap(RetrieverConfig{}),
+		Label:        "SQLite",
+		Supports: &ai.RetrieverSupports{
+			Media: false,
+		},
+	}
+	store := newStore(g, db, embedder)
+	retriever := sqlstore.DefineRetriever(g, api.NewName(provider, "shows"), store, retOpts)
+	// Index documents from the Developer UI or other Genkit clients
+	sqlstore.DefineIndexer(g, api.NewName(provider, "shows"), store, &sqlstore.IndexerOptions{
+		Label:   "SQLite",
+		Batches: indexing.Options{BatchSize: *embedBatch, Concurrency: *concurrency},
+	})
+	return retriever
+}
+
+// indexDocs embeds docs and writes them to the database in batches of -embedbatch
+// documents, of which up to -concurrency are processed at the same time.
+func indexDocs(ctx context.Context, store *sqlstore.Store, docs []*ai.Document) (indexing.Stats, error) {
+	// The documents have a single part, to be embedded, and metadata fields for the
+	// table primary key: show_id, season_number, episode_id, chunk_index.
+	return shows.Index(ctx, store, docs, indexing.Options{
+		BatchSize:   *embedBatch,
+		Concurrency: *concurrency,
+		Progress: func(p indexing.Progress) {
+			log.Printf("Indexed %d of %d rows (%d failed)", p.Done, p.Total, p.Failed)
+		},
+	})
+}
+
+// contentHash returns the hash of a chunk stored with its embedding.
+func contentHash(chunk string) string {
+	h := sha256.Sum256([]byte(chunk))
+	return hex.EncodeToString(h[:])
+}
+
+// writeEmbeddings upserts the chunks, embeddings, and metadata of docs in a single transaction.
+// SQLite runs in-process, so a statement per row is fast. Rows keep their metadata if a
+// document has none, see sqlstore.Table.RowMetadata.
+func writeEmbeddings(ctx context.Context, db *sql.DB, docs []*ai.Document, embeddings [][]float32) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO embeddings (show_id, season_number, episode_id, chunk_index, chunk, embedding,
+				content_hash, embedding_model, embedding_version, metadata)
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10)
+			ON CONFLICT (show_id, season_number, episode_id, chunk_index) DO UPDATE
+			SET chunk = excluded.chunk, embedding = excluded.embedding, content_hash = excluded.content_hash,
+				embedding_model = excluded.embedding_model, embedding_version = excluded.embedding_version,
+				metadata = COALESCE(excluded.metadata, embeddings.metadata)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, doc := range docs {
+		args, err := showsTable.KeyArgs(doc)
+		if err != nil {
+			return fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		metadata, err := showsTable.RowMetadata(doc)
+		if err != nil {
+			return fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		vector, err := sqlstore.SQLite{}.Vector(embeddings[i])
+		if err != nil {
+			return fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		chunk := doc.Content[0].Text
+		args = append(args, chunk, vector, contentHash(chunk), embedderName, embedderVersion, metadata)
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// indexExistingRows embeds all rows whose chunk has changed since it was embedded, that have
+// no embedding or an embedding of other dimensions, or whose embedding was created by a
+// different model or version.
+func indexExistingRows(ctx context.Context, g *genkit.Genkit, db *sql.DB, embedder ai.Embedder) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT show_id, season_number, episode_id, chunk_index, chunk,
+			embedding IS NULL OR vec_length(embedding) <> ?1, content_hash, embedding_model, embedding_version
+		FROM embeddings`, embedderDimensions)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var skipped int
+	var docs []*ai.Document
+	for rows.Next() {
+		var sid, chunk string
+		var sn, eid, ci int
+		var missing bool
+		var hash, model, version sql.NullString
+		if err := rows.Scan(&sid, &sn, &eid, &ci, &chunk, &missing, &hash, &model, &version); err != nil {
+			return err
+		}
+		if !missing && hash.String == contentHash(chunk) && model.String == embedderName && version.String == embedderVersion {
+			skipped++
+			continue
+		}
+		docs = append(docs, &ai.Document{
+			Content: []*ai.Part{ai.NewTextPart(chunk)},
+			Metadata: map[string]any{
+				"show_id":       sid,
+				"season_number": sn,
+				"episode_id":    eid,
+				"chunk_index":   ci,
+			},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var stats indexing.Stats
+	if len(docs) > 0 {
+		if stats, err = indexDocs(ctx, newStore(g, db, embedder), docs); err != nil {
+			return err
+		}
+	}
+	log.Printf("Indexed embeddings: %d updated, %d skipped, %d failed", stats.Updated, skipped, stats.Failed)
+	if stats.Failed > 0 {
+		return fmt.Errorf("failed to index %d rows", stats.Failed)
+	}
+	return nil
+}
+
+// ingest splits the episode scripts in a directory into chunks, replaces the
+// chunks of each episode in the database, and embeds the changed chunks.
+func ingest(ctx context.Context, g *genkit.Genkit, db *sql.DB, embedder ai.Embedder, args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	strategy := flags.String("strategy", chunking.StrategyRecursive, "chunking strategy: "+strings.Join(chunking.Strategies, ", "))
+	size := flags.Int("size", 1000, "maximum chunk size in characters, or in tokens for the token strategy")
+	overlap := flags.Int("overlap", 100, "number of characters (or tokens) shared by consecutive chunks")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: ingest [-strategy name] [-size n] [-overlap n] <dir>")
+	}
+	_, err := shows.Ingest(ctx, newStore(g, db, embedder), flags.Arg(0), shows.IngestOptions{
+		Chunking: chunking.Options{Strategy: *strategy, Size: *size, Overlap: *overlap},
+		Progress: func(ep shows.Episode, chunks int) {
+			log.Printf("%s: %d chunks", ep, chunks)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	// Chunks keep their embedding, so only chunks that have changed are embedded again
+	return indexExistingRows(ctx, g, db, embedder)
+}
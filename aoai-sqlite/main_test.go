@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+// query is the text of the test searches.
+const query = "Who is in love?"
+
+// vectors are the fake embeddings of query and of the chunks seeded by the
+// migrations. They aren't normalized, so the metrics rank chunks differently.
+var vectors = map[string][]float32{
+	query:                                    {2, 1, 0},
+	"Natasha confesses her love for Pierre.": {1, 0, 0},
+	"Pierre and Natasha become engaged.":     {0, 3, 0},
+	"Margot and Henri divorce.":              {0, 0, 2},
+	"Alice confesses her love for Oscar.":    {3, 0, 0},
+	"Oscar and Alice become engaged.":        {0, 1, 0},
+	"Bob and Pat divorce.":                   {0, 0, 1},
+}
+
+// fakeEmbedder defines an embedder that returns the vectors of known texts.
+func fakeEmbedder(g *genkit.Genkit) ai.Embedder {
+	return genkit.DefineEmbedder(g, "test/embedder", nil, func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		resp := &ai.EmbedResponse{}
+		for _, doc := range req.Input {
+			v, ok := vectors[doc.Content[0].Text]
+			if !ok {
+				return nil, fmt.Errorf("no embedding for %q", doc.Content[0].Text)
+			}
+			resp.Embeddings = append(resp.Embeddings, &ai.Embedding{Embedding: v})
+		}
+		return resp, nil
+	})
+}
+
+// result is a retrieved chunk.
+type result struct {
+	Show    string
+	Episode int
+	Genre   string
+	Score   float64
+}
+
+func TestShows(t *testing.T) {
+	ctx := context.Background()
+	*connString = "file:" + filepath.Join(t.TempDir(), "shows.db") + "?_busy_timeout=5000&_txlock=immediate"
+	if err := migrateSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	g := genkit.Init(ctx)
+	retriever := defineShows(g, db, fakeEmbedder(g))
+
+	// Embed the seeded chunks with the indexer, as the Developer UI does.
+	// The documents only have the key columns in their metadata, so the rows
+	// keep their genre.
+	var docs []*ai.Document
+	rows, err := db.QueryContext(ctx, "SELECT show_id, season_number, episode_id, chunk_index, chunk FROM embeddings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var show, chunk string
+		var season, episode, index int
+		if err := rows.Scan(&show, &season, &episode, &index, &chunk); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, ai.DocumentFromText(chunk, map[string]any{
+			"show_id": show, "season_number": season, "episode_id": episode, "chunk_index": index,
+		}))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	in, err := json.Marshal(sqlstore.IndexerRequest{Documents: docs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer := genkit.LookupAction(g, api.NewKey(api.ActionTypeIndexer, provider, "shows"))
+	if indexer == nil {
+		t.Fatal("indexer isn't registered")
+	}
+	out, err := indexer.RunJSON(ctx, in, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexed sqlstore.IndexerResponse
+	if err := json.Unmarshal(out, &indexed); err != nil {
+		t.Fatal(err)
+	}
+	if indexed.Indexed != len(vectors)-1 || indexed.Failed != 0 {
+		t.Fatalf("got %d indexed and %d failed documents, want %d indexed", indexed.Indexed, indexed.Failed, len(vectors)-1)
+	}
+
+	tests := []struct {
+		name   string
+		metric retrieval.Metric
+		filter filter.Filter
+		want   []result
+	}{
+		{
+			name: "default",
+			want: []result{
+				{"Best Friends", 1, "comedy", 6},
+				{"La Vie", 2, "drama", 3},
+				{"La Vie", 1, "drama", 2},
+			},
+		},
+		{
+			name:   "dot",
+			metric: retrieval.MetricDot,
+			filter: filter.Filter{filter.Eq("show_id", "La Vie")},
+			want: []result{
+				{"La Vie", 2, "drama", 3},
+				{"La Vie", 1, "drama", 2},
+				{"La Vie", 3, "drama", 0},
+			},
+		},
+		{
+			name:   "cosine",
+			metric: retrieval.MetricCosine,
+			filter: filter.Filter{filter.Eq("show_id", "La Vie")},
+			want: []result{
+				{"La Vie", 1, "drama", 2 / math.Sqrt(5)},
+				{"La Vie", 2, "drama", 1 / math.Sqrt(5)},
+				{"La Vie", 3, "drama", 0},
+			},
+		},
+		{
+			name:   "l2",
+			metric: retrieval.MetricL2,
+			filter: filter.Filter{filter.Eq("show_id", "La Vie")},
+			want: []result{
+				{"La Vie", 1, "drama", 1 / (1 + math.Sqrt(2))},
+				{"La Vie", 2, "drama", 1 / (1 + math.Sqrt(8))},
+				{"La Vie", 3, "drama", 1.0 / 4},
+			},
+		},
+		{
+			name:   "metadata filter",
+			metric: retrieval.MetricDot,
+			filter: filter.Filter{filter.Eq("metadata.genre", "comedy"), filter.In("episode_id", 2, 3)},
+			want: []result{
+				{"Best Friends", 2, "comedy", 1},
+				{"Best Friends", 3, "comedy", 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := retriever.Retrieve(ctx, &ai.RetrieverRequest{
+				Query:   ai.DocumentFromText(query, nil),
+				Options: &RetrieverConfig{Filter: tt.filter, Options: retrieval.Options{K: 3, Metric: tt.metric}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []result
+			for _, doc := range resp.Documents {
+				m := doc.Metadata
+				got = append(got, result{m["show_id"].(string), m["episode_id"].(int), m["genre"].(string), m["score"].(float64)})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				// Scores are computed from float32 distances
+				if math.Abs(got[i].Score-tt.want[i].Score) > 1e-5 {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+					break
+				}
+				got[i].Score = tt.want[i].Score
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
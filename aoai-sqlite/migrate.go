@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"log"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrateSchema applies the embedded migrations. SQLite has no vector type, so
+// changing embedderDimensions doesn't need a migration.
+func migrateSchema(ctx context.Context) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	all, err := migrate.Load(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	applied, err := migrate.Up(ctx, db, migrate.SQLite, all, nil)
+	for _, mig := range applied {
+		log.Printf("Applied migration %03d_%s", mig.Version, mig.Name)
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+-- The embeddings table has the same columns as in the aoai-pgvector sample.
+-- Each episode is split into one or more chunks. start_offset and end_offset are
+-- the byte offsets of a chunk in the episode's script, and NULL for hand-written
+-- chunks. embedding is a BLOB of float32 values, see sqlstore.SQLite.
+-- content_hash is the SHA-256 hash of the chunk that embedding was created from,
+-- embedding_model and embedding_version identify the model that created it.
+-- metadata holds arbitrary JSON attributes of a chunk, which retrievers can
+-- filter on, e.g. {"genre": "drama"}.
+CREATE TABLE IF NOT EXISTS embeddings (
+    show_id TEXT NOT NULL,
+    season_number INTEGER NOT NULL,
+    episode_id INTEGER NOT NULL,
+    chunk_index INTEGER NOT NULL DEFAULT 0,
+    start_offset INTEGER,
+    end_offset INTEGER,
+    chunk TEXT,
+    embedding BLOB,
+    content_hash TEXT,
+    embedding_model TEXT,
+    embedding_version TEXT,
+    metadata TEXT CHECK (metadata IS NULL OR json_valid(metadata)),
+    PRIMARY KEY (show_id, season_number, episode_id, chunk_index)
+);
+
+INSERT INTO embeddings (show_id, season_number, episode_id, chunk, metadata) VALUES
+	('La Vie', 1,  1,  'Natasha confesses her love for Pierre.', '{"genre": "drama"}'),
+	('La Vie', 1,  2,  'Pierre and Natasha become engaged.', '{"genre": "drama"}'),
+	('La Vie', 1,  3,  'Margot and Henri divorce.', '{"genre": "drama"}'),
+	('Best Friends', 1,  1,  'Alice confesses her love for Oscar.', '{"genre": "comedy"}'),
+	('Best Friends', 1,  2,  'Oscar and Alice become engaged.', '{"genre": "comedy"}'),
+	('Best Friends', 1,  3,  'Bob and Pat divorce.', '{"genre": "comedy"}')
+ON CONFLICT DO NOTHING;
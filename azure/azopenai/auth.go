@@ -0,0 +1,60 @@
+package azopenai
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/openai/openai-go/option"
+)
+
+// cognitiveServicesScope is the Entra ID scope required by Azure OpenAI.
+const cognitiveServicesScope = "https://cognitiveservices.azure.com/.default"
+
+// authenticator adds an endpoint's credentials to outgoing requests.
+type authenticator struct {
+	apiKey string
+	// apiKeyHeader selects the "api-key" header instead of "Authorization"
+	apiKeyHeader bool
+	cred         *cachingCredential
+}
+
+func newAuthenticator(apiKey string, cred azcore.TokenCredential, mode APIMode, refreshBefore time.Duration) *authenticator {
+	if cred == nil {
+		return &authenticator{apiKey: apiKey, apiKeyHeader: mode == APIModeDeployment}
+	}
+	return &authenticator{cred: newCachingCredential(cred, refreshBefore)}
+}
+
+func (au *authenticator) do(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if au.cred == nil {
+		if au.apiKeyHeader {
+			// Use the "api-key" header instead of "Authorization"
+			req.Header.Set("api-key", au.apiKey)
+			req.Header.Del("Authorization")
+		} else {
+			req.Header.Set("Authorization", "Bearer "+au.apiKey)
+		}
+		return next(req)
+	}
+
+	token, err := au.cred.get(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := next(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		// The token may have been revoked, acquire a new one for the next attempt
+		au.cred.invalidate()
+	}
+	return res, err
+}
+
+// authenticate returns middleware that adds the credentials of the endpoint
+// selected for the request.
+func (a *AzureOpenAI) authenticate() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		return a.endpointFor(req).auth.do(req, next)
+	})
+}
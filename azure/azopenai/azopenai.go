@@ -0,0 +1,219 @@
+// Package azopenai provides a Genkit plugin for Azure OpenAI. It builds on
+// Genkit's OpenAI plugin and configures the underlying OpenAI SDK client for
+// either the Azure OpenAI v1 API or the deployment-based API.
+package azopenai
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/firebase/genkit/go/core/api"
+	oai "github.com/firebase/genkit/go/plugins/compat_oai/openai"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// AzureOpenAI is a Genkit plugin for Azure OpenAI. Exactly one of APIKey and
+// TokenCredential must be set.
+//
+// Mode selects between the v1 API and the deployment-based API, see APIMode.
+// With the deployment-based API, requests for models and embedders listed in
+// Models and Embedders are sent to their respective deployments, all other
+// requests to Deployment. With the v1 API, the deployment name replaces the
+// model name sent to the endpoint.
+type AzureOpenAI struct {
+	*oai.OpenAI
+	APIKey          string
+	TokenCredential azcore.TokenCredential
+	BaseURL         string
+	Deployment      string
+	Models          map[string]Deployment
+	Embedders       map[string]Deployment
+	// APIVersion is the api-version used for all deployments. If empty, the
+	// AZ_OPENAI_API_VERSION environment variable is consulted. The
+	// deployment-based API falls back to 2024-10-21, the v1 API omits
+	// api-version unless set.
+	APIVersion string
+	Mode       APIMode
+	// Endpoints are additional resources that requests are distributed
+	// across according to Balancing. All endpoints must use the same API mode
+	// as BaseURL.
+	Endpoints []Endpoint
+	Balancing Balancing
+	// Logging enables structured logging of all HTTP requests. If nil,
+	// requests are logged with bodies at slog.LevelInfo if the
+	// AZ_OPENAI_DEBUG_HTTP environment variable is set to "1" or "true".
+	Logging *LogOptions
+	// RateLimit applies to each deployment without its own RateLimit.
+	RateLimit RateLimit
+	// TokenRefreshBefore is how long before their expiry access tokens
+	// acquired from TokenCredential are refreshed. Defaults to 5 minutes.
+	TokenRefreshBefore time.Duration
+
+	// credErr records a failure to create a credential in an Option
+	credErr error
+
+	// endpoints holds the primary endpoint followed by all Endpoints
+	endpoints []*endpoint
+	next      atomic.Uint64
+
+	limitersMu sync.Mutex
+	limiters   map[string]*limiter
+
+	// client is an OpenAI SDK client for APIs not covered by the OpenAI plugin
+	client *openai.Client
+
+	batchOnce sync.Once
+	batch     *openai.Client
+}
+
+// Option configures an AzureOpenAI plugin created by New.
+type Option func(*AzureOpenAI)
+
+// WithAPIKey authenticates requests with the "api-key" header.
+func WithAPIKey(key string) Option {
+	return func(a *AzureOpenAI) {
+		a.APIKey = key
+	}
+}
+
+// WithTokenCredential authenticates requests with Entra ID bearer tokens
+// obtained from cred.
+func WithTokenCredential(cred azcore.TokenCredential) Option {
+	return func(a *AzureOpenAI) {
+		a.TokenCredential = cred
+	}
+}
+
+// WithDeployment selects the deployment-based API instead of the v1 API.
+// All requests are sent to the given deployment.
+func WithDeployment(name string) Option {
+	return func(a *AzureOpenAI) {
+		a.Deployment = name
+	}
+}
+
+// New returns an AzureOpenAI plugin for the endpoint at baseURL. For the v1
+// API, baseURL must include the path "openai/v1". New reports an error if the
+// resulting configuration is invalid; see Validate.
+func New(baseURL string, opts ...Option) (*AzureOpenAI, error) {
+	a := &AzureOpenAI{BaseURL: baseURL}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Validate checks the plugin configuration without contacting the endpoint.
+// It returns all problems found, each as a *ConfigError.
+func (a *AzureOpenAI) Validate() error {
+	var errs []error
+	switch {
+	case a.credErr != nil:
+		errs = append(errs, &ConfigError{Field: "TokenCredential", Err: a.credErr})
+	case a.APIKey == "" && a.TokenCredential == nil:
+		errs = append(errs, &ConfigError{Field: "APIKey", Err: ErrMissingCredential})
+	case a.APIKey != "" && a.TokenCredential != nil:
+		errs = append(errs, &ConfigError{Field: "APIKey", Err: ErrAmbiguousCredential})
+	}
+	if err := validateBaseURL(a.BaseURL); err != nil {
+		errs = append(errs, &ConfigError{Field: "BaseURL", Err: err})
+	} else if _, _, err := a.resolveMode(); err != nil {
+		errs = append(errs, &ConfigError{Field: "Mode", Err: err})
+	}
+	errs = append(errs, a.validateDeployments()...)
+	errs = append(errs, a.validateEndpoints()...)
+	return errors.Join(errs...)
+}
+
+// Init implements genkit.Plugin. Since the genkit.Plugin interface does not
+// allow returning an error, Init panics if the configuration is invalid. Use
+// New or call Validate beforehand to detect configuration errors gracefully.
+func (a *AzureOpenAI) Init(ctx context.Context) []api.Action {
+	if err := a.Validate(); err != nil {
+		panic(fmt.Sprintf("Azure OpenAI plugin initialization failed: %v", err))
+	}
+
+	if a.OpenAI == nil {
+		// Note: This should never fail since Validate has already checked all endpoints
+		if err := a.initEndpoints(); err != nil {
+			panic(fmt.Sprintf("Azure OpenAI plugin initialization failed: %v", err))
+		}
+		a.init()
+	}
+
+	return a.defineDeployments(a.wrapModels(a.OpenAI.Init(ctx)))
+}
+
+func (a *AzureOpenAI) init() {
+	a.OpenAI = &oai.OpenAI{
+		// Satisfy the OpenAI plugin's requirement for a non-empty string, the
+		// authenticate middleware sets the actual credentials
+		APIKey: "notused",
+		Opts:   a.clientOptions(len(a.endpoints) > 1),
+	}
+	c := openai.NewClient(append([]option.RequestOption{option.WithAPIKey("notused")}, a.OpenAI.Opts...)...)
+	a.client = &c
+}
+
+// clientOptions returns the OpenAI SDK client options for the plugin's
+// endpoints. If failover is not set, all requests are sent to the primary
+// endpoint.
+func (a *AzureOpenAI) clientOptions(failover bool) []option.RequestOption {
+	primary := a.endpoints[0]
+
+	// Overwrite base URL. With the deployment-based API, the deployment path is
+	// added per request.
+	opts := []option.RequestOption{
+		option.WithBaseURL(primary.base.String()),
+	}
+
+	// The v1 API does not require an api-version, but accepts e.g. "preview"
+	if v := a.apiVersion(); v != "" && primary.mode == APIModeV1 {
+		opts = append(opts, option.WithQuery("api-version", v))
+	}
+
+	// Send requests to other endpoints if the primary endpoint is unavailable
+	if failover {
+		opts = append(opts, a.failover())
+	}
+
+	// Add the data sources of ChatConfig to chat completions
+	opts = append(opts, groundOnData())
+
+	// Map model names to deployments. With the deployment-based API, this also
+	// sets the "api-version" query parameter and removes JSON attribute "model"
+	opts = append(opts, a.routeToDeployment())
+
+	// Pace requests according to each deployment's rate limit
+	opts = append(opts, a.limitRate())
+
+	// Pass content filter annotations to the model call
+	opts = append(opts, captureContentFilter())
+
+	// Use either the API key or TokenCredential (Entra) for authorization
+	opts = append(opts, a.authenticate())
+
+	// Enable HTTP request/response logging if AZ_OPENAI_DEBUG_HTTP environment variable is set to "1" or "true"
+	logging := a.Logging
+	debug := os.Getenv("AZ_OPENAI_DEBUG_HTTP")
+	if logging == nil && cmp.Or(debug == "1", strings.EqualFold(debug, "true")) {
+		logging = &LogOptions{Level: slog.LevelInfo, Bodies: true}
+	}
+	if logging != nil {
+		opts = append(opts, logRequests(*logging))
+	}
+	return opts
+}
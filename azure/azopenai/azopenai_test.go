@@ -0,0 +1,348 @@
+package azopenai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// recordedRequest is a request received by a fakeServer.
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   map[string]any
+}
+
+// fakeServer is an Azure OpenAI endpoint that records all requests. Unless
+// handle is set, it answers chat completions, embeddings and responses
+// requests with canned results.
+type fakeServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	reqs   []recordedRequest
+	handle func(w http.ResponseWriter, r *http.Request, body map[string]any)
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	s := &fakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	var body map[string]any
+	_ = json.Unmarshal(b, &body)
+	s.mu.Lock()
+	s.reqs = append(s.reqs, recordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	handle := s.handle
+	s.mu.Unlock()
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	if handle != nil {
+		handle(w, r, body)
+		return
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		writeJSON(w, chatCompletion("Hello"))
+	case strings.HasSuffix(r.URL.Path, "/embeddings"):
+		writeJSON(w, embeddings(body))
+	case strings.HasSuffix(r.URL.Path, "/responses"):
+		writeJSON(w, responseObject("Hello"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setHandler replaces the handler of subsequent requests.
+func (s *fakeServer) setHandler(handle func(w http.ResponseWriter, r *http.Request, body map[string]any)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+}
+
+// requests returns the requests received so far.
+func (s *fakeServer) requests() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedRequest(nil), s.reqs...)
+}
+
+// last returns the most recent request, failing the test if there is none.
+func (s *fakeServer) last(t *testing.T) recordedRequest {
+	t.Helper()
+	reqs := s.requests()
+	if len(reqs) == 0 {
+		t.Fatal("no request received")
+	}
+	return reqs[len(reqs)-1]
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func chatCompletion(text string) map[string]any {
+	return map[string]any{
+		"id":      "chatcmpl-1",
+		"object":  "chat.completion",
+		"created": 1,
+		"model":   "gpt",
+		"choices": []any{map[string]any{
+			"index":         0,
+			"finish_reason": "stop",
+			"message":       map[string]any{"role": "assistant", "content": text},
+		}},
+		"usage": map[string]any{"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7},
+	}
+}
+
+// embeddings returns a vector [i, 1] for the i-th input of body.
+func embeddings(body map[string]any) map[string]any {
+	inputs, _ := body["input"].([]any)
+	var data []any
+	for i := range inputs {
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": []float64{float64(i), 1}})
+	}
+	return map[string]any{
+		"object": "list",
+		"data":   data,
+		"model":  "emb",
+		"usage":  map[string]any{"prompt_tokens": 3, "total_tokens": 3},
+	}
+}
+
+func responseObject(text string) map[string]any {
+	return map[string]any{
+		"id":         "resp-1",
+		"object":     "response",
+		"created_at": 1,
+		"model":      "gpt",
+		"status":     "completed",
+		"output": []any{map[string]any{
+			"type":    "message",
+			"id":      "msg-1",
+			"role":    "assistant",
+			"status":  "completed",
+			"content": []any{map[string]any{"type": "output_text", "text": text, "annotations": []any{}}},
+		}},
+		"usage": map[string]any{"input_tokens": 5, "output_tokens": 2, "total_tokens": 7},
+	}
+}
+
+// newTestPlugin returns an initialized plugin that has not been registered
+// with Genkit. The OpenAI SDK's retries are left to the caller, see
+// testClient.
+func newTestPlugin(t *testing.T, baseURL string, opts ...Option) *AzureOpenAI {
+	t.Helper()
+	t.Setenv("AZ_OPENAI_API_VERSION", "")
+	t.Setenv("AZ_OPENAI_DEBUG_HTTP", "")
+	a, err := New(baseURL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.initEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	a.init()
+	return a
+}
+
+// testClient returns an OpenAI SDK client configured like the plugin's own
+// clients, but without retries.
+func testClient(a *AzureOpenAI) openai.Client {
+	opts := append([]option.RequestOption{option.WithAPIKey("notused")}, a.OpenAI.Opts...)
+	return openai.NewClient(append(opts, option.WithMaxRetries(0))...)
+}
+
+func TestNewValidate(t *testing.T) {
+	t.Setenv("AZ_OPENAI_API_VERSION", "")
+	tests := []struct {
+		name    string
+		baseURL string
+		opts    []Option
+		field   string
+		err     error
+	}{
+		{name: "v1", baseURL: "https://res.openai.azure.com/openai/v1", opts: []Option{WithAPIKey("k")}},
+		{name: "deployment", baseURL: "https://res.openai.azure.com", opts: []Option{WithAPIKey("k"), WithDeployment("gpt")}},
+		{name: "no credential", baseURL: "https://res.openai.azure.com", field: "APIKey", err: ErrMissingCredential},
+		{name: "two credentials", baseURL: "https://res.openai.azure.com", opts: []Option{WithAPIKey("k"), WithTokenCredential(&fakeCredential{})}, field: "APIKey", err: ErrAmbiguousCredential},
+		{name: "no base URL", opts: []Option{WithAPIKey("k")}, field: "BaseURL", err: ErrMissingBaseURL},
+		{name: "relative base URL", baseURL: "res.openai.azure.com", opts: []Option{WithAPIKey("k")}, field: "BaseURL"},
+		{name: "v1 URL in deployment mode", baseURL: "https://res.openai.azure.com/openai/v1", opts: []Option{WithAPIKey("k"), WithAPIMode(APIModeDeployment)}, field: "Mode"},
+		{name: "unknown mode", baseURL: "https://res.openai.azure.com", opts: []Option{WithAPIKey("k"), WithAPIMode(APIMode(7))}, field: "Mode"},
+		{name: "unknown API", baseURL: "https://res.openai.azure.com", opts: []Option{WithAPIKey("k"), WithModel("m", Deployment{API: ModelAPI(7)})}, field: "Models"},
+		{
+			name:    "endpoint with other mode",
+			baseURL: "https://res.openai.azure.com/openai/v1",
+			opts:    []Option{WithAPIKey("k"), WithAPIMode(APIModeAuto), WithEndpoints(Endpoint{BaseURL: "https://other.openai.azure.com", APIKey: "k"}), WithDeployment("gpt")},
+			field:   "Endpoints[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(tt.baseURL, tt.opts...)
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if a == nil {
+					t.Fatal("New returned nil plugin")
+				}
+				return
+			}
+			var ce *ConfigError
+			if !errors.As(err, &ce) {
+				t.Fatalf("got error %v, want a *ConfigError", err)
+			}
+			if ce.Field != tt.field {
+				t.Errorf("got field %q, want %q (%v)", ce.Field, tt.field, err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	a := &AzureOpenAI{Models: map[string]Deployment{"": {}}}
+	err := a.Validate()
+	for _, want := range []error{ErrMissingCredential, ErrMissingBaseURL} {
+		if !errors.Is(err, want) {
+			t.Errorf("error %v does not include %v", err, want)
+		}
+	}
+	if !strings.Contains(fmt.Sprint(err), "model name must not be empty") {
+		t.Errorf("error %v does not report the empty model name", err)
+	}
+}
+
+func TestInitPanicsOnInvalidConfig(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Init did not panic")
+		}
+	}()
+	(&AzureOpenAI{}).Init(context.Background())
+}
+
+// initGenkit registers a plugin for srv with Genkit.
+func initGenkit(t *testing.T, srv *fakeServer, opts ...Option) *genkit.Genkit {
+	t.Helper()
+	t.Setenv("AZ_OPENAI_API_VERSION", "")
+	t.Setenv("AZ_OPENAI_DEBUG_HTTP", "")
+	a, err := New(srv.URL, append([]Option{WithAPIKey("secret")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return genkit.Init(context.Background(), genkit.WithPlugins(a))
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		path    string
+		model   string
+		version string
+	}{
+		{
+			name:    "deployment",
+			opts:    []Option{WithModel("chat", Deployment{Name: "gpt-dep"})},
+			path:    "/openai/deployments/gpt-dep/chat/completions",
+			version: defaultAPIVersion,
+		},
+		{
+			name:  "v1",
+			opts:  []Option{WithAPIMode(APIModeV1), WithModel("chat", Deployment{Name: "gpt-dep"})},
+			path:  "/chat/completions",
+			model: "gpt-dep",
+		},
+		{
+			name:    "responses",
+			opts:    []Option{WithModel("chat", Deployment{Name: "gpt-dep", API: APIResponses})},
+			path:    "/openai/responses",
+			model:   "gpt-dep",
+			version: responsesAPIVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			g := initGenkit(t, srv, tt.opts...)
+			resp, err := genkit.Generate(context.Background(), g, ai.WithModelName("openai/chat"), ai.WithPrompt("Hi"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Text(); got != "Hello" {
+				t.Errorf("got text %q, want %q", got, "Hello")
+			}
+			if resp.Usage == nil || resp.Usage.TotalTokens != 7 {
+				t.Errorf("got usage %+v, want 7 total tokens", resp.Usage)
+			}
+			req := srv.last(t)
+			if req.Path != tt.path {
+				t.Errorf("got path %q, want %q", req.Path, tt.path)
+			}
+			if got := req.Query.Get("api-version"); got != tt.version {
+				t.Errorf("got api-version %q, want %q", got, tt.version)
+			}
+			if got, _ := req.Body["model"].(string); got != tt.model {
+				t.Errorf("got model %q, want %q", got, tt.model)
+			}
+		})
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	srv := newFakeServer(t)
+	g := initGenkit(t, srv, WithEmbedder("embed", Deployment{Name: "emb-dep", Dimensions: 2}))
+	resp, err := genkit.Embed(context.Background(), g, ai.WithEmbedderName("openai/embed"), ai.WithTextDocs("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Embeddings) != 2 || resp.Embeddings[1].Embedding[0] != 1 {
+		t.Errorf("got embeddings %+v, want two vectors in input order", resp.Embeddings)
+	}
+	req := srv.last(t)
+	if want := "/openai/deployments/emb-dep/embeddings"; req.Path != want {
+		t.Errorf("got path %q, want %q", req.Path, want)
+	}
+	if got := req.Header.Get("api-key"); got != "secret" {
+		t.Errorf("got api-key %q, want %q", got, "secret")
+	}
+	if got := req.Body["dimensions"]; got != float64(2) {
+		t.Errorf("got dimensions %v, want 2", got)
+	}
+	if _, ok := req.Body["model"]; ok {
+		t.Error("model was not removed from the deployment request")
+	}
+}
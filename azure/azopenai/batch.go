@@ -0,0 +1,312 @@
+package azopenai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// maxBatchRequests is the maximum number of requests of a batch job.
+const maxBatchRequests = 100000
+
+// ErrNotInitialized is returned by batch jobs if the plugin has not been
+// initialized by genkit.Init.
+var ErrNotInitialized = errors.New("azopenai: plugin not initialized")
+
+// BatchRequest is a document to embed in a batch job.
+type BatchRequest struct {
+	// ID identifies the request's result. It must be unique within the job.
+	ID  string
+	Doc *ai.Document
+}
+
+// BatchJob is the state of an Azure OpenAI batch job. It can be saved and
+// loaded to resume waiting for a job after a restart.
+type BatchJob struct {
+	ID           string    `json:"id"`
+	Deployment   string    `json:"deployment"`
+	InputFileID  string    `json:"inputFileId"`
+	OutputFileID string    `json:"outputFileId,omitempty"`
+	ErrorFileID  string    `json:"errorFileId,omitempty"`
+	Status       string    `json:"status"`
+	Total        int64     `json:"total"`
+	Completed    int64     `json:"completed"`
+	Failed       int64     `json:"failed"`
+	CreatedAt    time.Time `json:"createdAt"`
+	// Errors describes why a job failed validation.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Done reports whether the job has reached a final status.
+func (j *BatchJob) Done() bool {
+	switch openai.BatchStatus(j.Status) {
+	case openai.BatchStatusCompleted, openai.BatchStatusFailed, openai.BatchStatusExpired, openai.BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Save writes the job state to path.
+func (j *BatchJob) Save(path string) error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// LoadBatchJob reads a job state written by BatchJob.Save.
+func LoadBatchJob(path string) (*BatchJob, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var j BatchJob
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("azopenai: invalid batch job state in %s: %w", path, err)
+	}
+	return &j, nil
+}
+
+// BatchJobError is returned if a batch job ended without results.
+type BatchJobError struct {
+	ID     string
+	Status string
+	Errors []string
+}
+
+func (e *BatchJobError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("azopenai: batch job %s %s", e.ID, e.Status)
+	}
+	return fmt.Sprintf("azopenai: batch job %s %s: %v", e.ID, e.Status, e.Errors)
+}
+
+// EmbeddingResults are the results of an embedding batch job, keyed by
+// BatchRequest.ID.
+type EmbeddingResults struct {
+	Embeddings map[string][]float32
+	Errors     map[string]error
+}
+
+// batchClient returns an OpenAI SDK client for batch jobs. Batch jobs and
+// their files belong to a single resource, so requests are always sent to the
+// primary endpoint.
+func (a *AzureOpenAI) batchClient() (*openai.Client, error) {
+	if a.endpoints == nil {
+		return nil, ErrNotInitialized
+	}
+	a.batchOnce.Do(func() {
+		c := openai.NewClient(append([]option.RequestOption{option.WithAPIKey("notused")}, a.clientOptions(false)...)...)
+		a.batch = &c
+	})
+	return a.batch, nil
+}
+
+// SubmitEmbeddingBatch uploads reqs and starts a batch job that embeds them
+// with the deployment of embedder. The deployment must be a batch deployment
+// (e.g. "Global Batch"). A job takes up to 24 hours; save the returned job to
+// resume waiting for it after a restart.
+func (a *AzureOpenAI) SubmitEmbeddingBatch(ctx context.Context, embedder string, reqs []BatchRequest) (*BatchJob, error) {
+	client, err := a.batchClient()
+	if err != nil {
+		return nil, err
+	}
+	if len(reqs) == 0 || len(reqs) > maxBatchRequests {
+		return nil, fmt.Errorf("azopenai: a batch job requires 1 to %d requests, got %d", maxBatchRequests, len(reqs))
+	}
+
+	d := a.lookupDeployment(embedder)
+	url := "/embeddings"
+	endpoint := openai.BatchNewParamsEndpoint(url)
+	if a.endpoints[0].mode == APIModeV1 {
+		endpoint = openai.BatchNewParamsEndpointV1Embeddings
+		url = string(endpoint)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	ids := make(map[string]bool, len(reqs))
+	for _, r := range reqs {
+		if ids[r.ID] {
+			return nil, fmt.Errorf("azopenai: duplicate batch request ID %q", r.ID)
+		}
+		ids[r.ID] = true
+		var text string
+		for _, p := range r.Doc.Content {
+			text += p.Text
+		}
+		body := map[string]any{"model": d.Name, "input": text}
+		if d.Dimensions > 0 {
+			body["dimensions"] = d.Dimensions
+		}
+		line := map[string]any{
+			"custom_id": r.ID,
+			"method":    "POST",
+			"url":       url,
+			"body":      body,
+		}
+		if err := enc.Encode(line); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := client.Files.New(ctx, openai.FileNewParams{
+		File:    openai.File(&buf, "embeddings.jsonl", "application/jsonl"),
+		Purpose: openai.FilePurposeBatch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("azopenai: failed to upload batch input: %w", err)
+	}
+	b, err := client.Batches.New(ctx, openai.BatchNewParams{
+		InputFileID:      f.ID,
+		Endpoint:         endpoint,
+		CompletionWindow: openai.BatchNewParamsCompletionWindow24h,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("azopenai: failed to create batch job: %w", err)
+	}
+	job := &BatchJob{Deployment: d.Name}
+	job.update(b)
+	return job, nil
+}
+
+func (j *BatchJob) update(b *openai.Batch) {
+	j.ID = b.ID
+	j.InputFileID = b.InputFileID
+	j.OutputFileID = b.OutputFileID
+	j.ErrorFileID = b.ErrorFileID
+	j.Status = string(b.Status)
+	j.Total = b.RequestCounts.Total
+	j.Completed = b.RequestCounts.Completed
+	j.Failed = b.RequestCounts.Failed
+	j.CreatedAt = time.Unix(b.CreatedAt, 0)
+	j.Errors = nil
+	for _, e := range b.Errors.Data {
+		j.Errors = append(j.Errors, fmt.Sprintf("line %d: %s: %s", e.Line, e.Code, e.Message))
+	}
+}
+
+// RefreshBatch updates job with its current status.
+func (a *AzureOpenAI) RefreshBatch(ctx context.Context, job *BatchJob) error {
+	client, err := a.batchClient()
+	if err != nil {
+		return err
+	}
+	b, err := client.Batches.Get(ctx, job.ID)
+	if err != nil {
+		return fmt.Errorf("azopenai: failed to get batch job %s: %w", job.ID, err)
+	}
+	job.update(b)
+	return nil
+}
+
+// WaitBatch polls job every interval until it is done. It returns a
+// *BatchJobError if the job did not complete.
+func (a *AzureOpenAI) WaitBatch(ctx context.Context, job *BatchJob, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := a.RefreshBatch(ctx, job); err != nil {
+			return err
+		}
+		if job.Done() {
+			break
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// Expired and cancelled jobs may have partial results
+	if job.OutputFileID == "" && job.ErrorFileID == "" {
+		return &BatchJobError{ID: job.ID, Status: job.Status, Errors: job.Errors}
+	}
+	return nil
+}
+
+// EmbeddingBatchResults downloads the results of a done job.
+func (a *AzureOpenAI) EmbeddingBatchResults(ctx context.Context, job *BatchJob) (*EmbeddingResults, error) {
+	client, err := a.batchClient()
+	if err != nil {
+		return nil, err
+	}
+	if !job.Done() {
+		return nil, fmt.Errorf("azopenai: batch job %s is %s", job.ID, job.Status)
+	}
+	results := &EmbeddingResults{
+		Embeddings: make(map[string][]float32),
+		Errors:     make(map[string]error),
+	}
+	for _, id := range []string{job.OutputFileID, job.ErrorFileID} {
+		if id == "" {
+			continue
+		}
+		if err := a.readBatchResults(ctx, client, id, results); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (a *AzureOpenAI) readBatchResults(ctx context.Context, client *openai.Client, fileID string, results *EmbeddingResults) error {
+	res, err := client.Files.Content(ctx, fileID)
+	if err != nil {
+		return fmt.Errorf("azopenai: failed to download batch results %s: %w", fileID, err)
+	}
+	defer res.Body.Close()
+
+	s := bufio.NewScanner(res.Body)
+	// Each line holds an embedding with thousands of dimensions
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var line struct {
+			CustomID string `json:"custom_id"`
+			Response *struct {
+				StatusCode int `json:"status_code"`
+				Body       struct {
+					Data []struct {
+						Embedding []float32 `json:"embedding"`
+					} `json:"data"`
+					Error *struct {
+						Code    string `json:"code"`
+						Message string `json:"message"`
+					} `json:"error"`
+				} `json:"body"`
+			} `json:"response"`
+			Error *struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(s.Bytes(), &line); err != nil {
+			return fmt.Errorf("azopenai: invalid batch result: %w", err)
+		}
+		switch r := line.Response; {
+		case line.Error != nil:
+			results.Errors[line.CustomID] = fmt.Errorf("%s: %s", line.Error.Code, line.Error.Message)
+		case r == nil:
+			results.Errors[line.CustomID] = errors.New("missing response")
+		case r.Body.Error != nil:
+			results.Errors[line.CustomID] = fmt.Errorf("status %d: %s: %s", r.StatusCode, r.Body.Error.Code, r.Body.Error.Message)
+		case r.StatusCode != 200 || len(r.Body.Data) == 0:
+			results.Errors[line.CustomID] = fmt.Errorf("status %d: no embedding", r.StatusCode)
+		default:
+			results.Embeddings[line.CustomID] = r.Body.Data[0].Embedding
+		}
+	}
+	return s.Err()
+}
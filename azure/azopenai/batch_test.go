@@ -0,0 +1,374 @@
+package azopenai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// fakeBatches serves the Files and Batches APIs. Batch jobs report the
+// statuses in order, one per poll, and then stay in the last status.
+type fakeBatches struct {
+	statuses []string
+	// files holds the content of files by ID
+	files map[string]string
+
+	mu      sync.Mutex
+	input   []map[string]any
+	created map[string]any
+	polls   int
+}
+
+func (f *fakeBatches) serve(w http.ResponseWriter, r *http.Request, body map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/files"):
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s := bufio.NewScanner(file)
+		for s.Scan() {
+			var line map[string]any
+			if err := json.Unmarshal(s.Bytes(), &line); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.input = append(f.input, line)
+		}
+		writeJSON(w, map[string]any{"id": "file-in", "object": "file", "bytes": 1, "created_at": 1, "filename": "embeddings.jsonl", "purpose": r.FormValue("purpose"), "status": "processed"})
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/batches"):
+		f.created = body
+		writeJSON(w, f.batch("validating"))
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/batches/batch-1"):
+		status := f.statuses[min(f.polls, len(f.statuses)-1)]
+		f.polls++
+		writeJSON(w, f.batch(status))
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/content"):
+		id := strings.TrimSuffix(path[strings.LastIndex(path, "/files/")+len("/files/"):], "/content")
+		content, ok := f.files[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, content)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeBatches) batch(status string) map[string]any {
+	b := map[string]any{
+		"id":                "batch-1",
+		"object":            "batch",
+		"endpoint":          "/embeddings",
+		"input_file_id":     "file-in",
+		"completion_window": "24h",
+		"status":            status,
+		"created_at":        1700000000,
+		"request_counts":    map[string]any{"total": 3, "completed": 0, "failed": 0},
+	}
+	switch status {
+	case "completed":
+		b["output_file_id"] = "file-out"
+		b["error_file_id"] = "file-err"
+		b["request_counts"] = map[string]any{"total": 3, "completed": 2, "failed": 1}
+	case "failed":
+		b["errors"] = map[string]any{"object": "list", "data": []any{map[string]any{"code": "invalid_request", "message": "bad input", "line": 1}}}
+	}
+	return b
+}
+
+func (f *fakeBatches) pollCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.polls
+}
+
+func newBatchPlugin(t *testing.T, baseURL string, opts ...Option) *AzureOpenAI {
+	t.Helper()
+	return newTestPlugin(t, baseURL, append([]Option{WithAPIKey("secret")}, opts...)...)
+}
+
+func TestSubmitEmbeddingBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		opts []Option
+
+		wantPath       string
+		wantURL        string
+		wantDimensions any
+	}{
+		{
+			name:           "deployment",
+			opts:           []Option{WithEmbedder("embed", Deployment{Name: "emb-batch", Dimensions: 256})},
+			wantPath:       "/openai/files",
+			wantURL:        "/embeddings",
+			wantDimensions: float64(256),
+		},
+		{
+			name:     "v1",
+			path:     "/openai/v1",
+			opts:     []Option{WithEmbedder("embed", Deployment{Name: "emb-batch"})},
+			wantPath: "/openai/v1/files",
+			wantURL:  "/v1/embeddings",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			batches := &fakeBatches{}
+			srv.setHandler(batches.serve)
+			a := newBatchPlugin(t, srv.URL+tt.path, tt.opts...)
+
+			job, err := a.SubmitEmbeddingBatch(context.Background(), "embed", []BatchRequest{
+				{ID: "doc-1", Doc: ai.DocumentFromText("first", nil)},
+				{ID: "doc-2", Doc: &ai.Document{Content: []*ai.Part{ai.NewTextPart("sec"), ai.NewTextPart("ond")}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := &BatchJob{
+				ID:          "batch-1",
+				Deployment:  "emb-batch",
+				InputFileID: "file-in",
+				Status:      "validating",
+				Total:       3,
+				CreatedAt:   time.Unix(1700000000, 0),
+			}
+			if !reflect.DeepEqual(job, want) {
+				t.Errorf("got job %+v, want %+v", job, want)
+			}
+
+			reqs := srv.requests()
+			if len(reqs) != 2 || reqs[0].Path != tt.wantPath {
+				t.Fatalf("got requests %v, want an upload to %s", reqs, tt.wantPath)
+			}
+			if got := batches.created["endpoint"]; got != tt.wantURL {
+				t.Errorf("got batch endpoint %v, want %s", got, tt.wantURL)
+			}
+			if got := batches.created["input_file_id"]; got != "file-in" {
+				t.Errorf("got input file %v", got)
+			}
+			if len(batches.input) != 2 {
+				t.Fatalf("got %d input lines, want 2", len(batches.input))
+			}
+			for i, text := range []string{"first", "second"} {
+				line := batches.input[i]
+				if line["custom_id"] != fmt.Sprintf("doc-%d", i+1) || line["method"] != "POST" || line["url"] != tt.wantURL {
+					t.Errorf("got input line %v", line)
+				}
+				body := line["body"].(map[string]any)
+				if body["model"] != "emb-batch" || body["input"] != text || body["dimensions"] != tt.wantDimensions {
+					t.Errorf("got input body %v", body)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitEmbeddingBatchErrors(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler((&fakeBatches{}).serve)
+	a := newBatchPlugin(t, srv.URL+"/openai/v1")
+	doc := ai.DocumentFromText("text", nil)
+
+	if _, err := a.SubmitEmbeddingBatch(context.Background(), "embed", nil); err == nil {
+		t.Error("no error for an empty batch")
+	}
+	if _, err := a.SubmitEmbeddingBatch(context.Background(), "embed", []BatchRequest{{ID: "x", Doc: doc}, {ID: "x", Doc: doc}}); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("got error %v, want duplicate ID", err)
+	}
+	if n := len(srv.requests()); n != 0 {
+		t.Errorf("invalid batches sent %d requests", n)
+	}
+
+	uninitialized, err := New(srv.URL, WithAPIKey("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uninitialized.SubmitEmbeddingBatch(context.Background(), "embed", []BatchRequest{{ID: "x", Doc: doc}}); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("got error %v, want ErrNotInitialized", err)
+	}
+}
+
+func TestWaitBatch(t *testing.T) {
+	srv := newFakeServer(t)
+	batches := &fakeBatches{statuses: []string{"in_progress", "finalizing", "completed"}}
+	srv.setHandler(batches.serve)
+	a := newBatchPlugin(t, srv.URL, WithDeployment("emb-batch"))
+
+	job := &BatchJob{ID: "batch-1", Status: "validating"}
+	if err := a.WaitBatch(context.Background(), job, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if n := batches.pollCount(); n != 3 {
+		t.Errorf("polled %d times, want 3", n)
+	}
+	if !job.Done() || job.OutputFileID != "file-out" || job.ErrorFileID != "file-err" || job.Completed != 2 || job.Failed != 1 {
+		t.Errorf("got job %+v", job)
+	}
+	if req := srv.last(t); req.Path != "/openai/batches/batch-1" || req.Query.Get("api-version") != defaultAPIVersion {
+		t.Errorf("got %s?%s", req.Path, req.Query.Encode())
+	}
+}
+
+func TestWaitBatchFailed(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler((&fakeBatches{statuses: []string{"failed"}}).serve)
+	a := newBatchPlugin(t, srv.URL+"/openai/v1")
+
+	job := &BatchJob{ID: "batch-1"}
+	err := a.WaitBatch(context.Background(), job, time.Millisecond)
+	var bje *BatchJobError
+	if !errors.As(err, &bje) {
+		t.Fatalf("got error %v, want a BatchJobError", err)
+	}
+	want := &BatchJobError{ID: "batch-1", Status: "failed", Errors: []string{"line 1: invalid_request: bad input"}}
+	if !reflect.DeepEqual(bje, want) {
+		t.Errorf("got %+v, want %+v", bje, want)
+	}
+}
+
+func TestWaitBatchCanceled(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler((&fakeBatches{statuses: []string{"in_progress"}}).serve)
+	a := newBatchPlugin(t, srv.URL+"/openai/v1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := a.WaitBatch(ctx, &BatchJob{ID: "batch-1"}, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// resultLine returns a line of a batch output file with embedding.
+func resultLine(id string, embedding []float32) string {
+	b, _ := json.Marshal(map[string]any{
+		"id":        "req-" + id,
+		"custom_id": id,
+		"response": map[string]any{
+			"status_code": 200,
+			"body": map[string]any{
+				"object": "list",
+				"data":   []any{map[string]any{"object": "embedding", "index": 0, "embedding": embedding}},
+			},
+		},
+	})
+	return string(b)
+}
+
+func TestEmbeddingBatchResults(t *testing.T) {
+	// A line longer than bufio.Scanner's default limit of 64 KB
+	large := make([]float32, 8192)
+	for i := range large {
+		large[i] = 0.123456789 + float32(i)
+	}
+	output := strings.Join([]string{
+		resultLine("doc-1", []float32{0.5, 1}),
+		"",
+		resultLine("doc-2", large),
+		`{"custom_id":"doc-3","response":{"status_code":400,"body":{"error":{"code":"invalid_input","message":"too long"}}}}`,
+		`{"custom_id":"doc-4","response":{"status_code":500,"body":{}}}`,
+	}, "\n")
+	errs := strings.Join([]string{
+		`{"custom_id":"doc-5","response":null,"error":{"code":"timeout","message":"expired"}}`,
+		`{"custom_id":"doc-6"}`,
+	}, "\n")
+	if len(resultLine("doc-2", large)) <= 64*1024 {
+		t.Fatal("large result line fits into the default buffer")
+	}
+
+	srv := newFakeServer(t)
+	srv.setHandler((&fakeBatches{files: map[string]string{"file-out": output, "file-err": errs}}).serve)
+	a := newBatchPlugin(t, srv.URL+"/openai/v1")
+
+	job := &BatchJob{ID: "batch-1", Status: "completed", OutputFileID: "file-out", ErrorFileID: "file-err"}
+	res, err := a.EmbeddingBatchResults(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Embeddings) != 2 || !reflect.DeepEqual(res.Embeddings["doc-1"], []float32{0.5, 1}) || !reflect.DeepEqual(res.Embeddings["doc-2"], large) {
+		t.Errorf("got %d embeddings", len(res.Embeddings))
+	}
+	wantErrs := map[string]string{
+		"doc-3": "status 400: invalid_input: too long",
+		"doc-4": "status 500: no embedding",
+		"doc-5": "timeout: expired",
+		"doc-6": "missing response",
+	}
+	if len(res.Errors) != len(wantErrs) {
+		t.Errorf("got errors %v, want %v", res.Errors, wantErrs)
+	}
+	for id, want := range wantErrs {
+		if err := res.Errors[id]; err == nil || err.Error() != want {
+			t.Errorf("%s: got error %v, want %q", id, err, want)
+		}
+	}
+}
+
+func TestEmbeddingBatchResultsErrors(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler((&fakeBatches{files: map[string]string{"file-out": "{not json"}}).serve)
+	a := newBatchPlugin(t, srv.URL+"/openai/v1")
+
+	if _, err := a.EmbeddingBatchResults(context.Background(), &BatchJob{ID: "batch-1", Status: "in_progress"}); err == nil {
+		t.Error("no error for a job that is not done")
+	}
+	if _, err := a.EmbeddingBatchResults(context.Background(), &BatchJob{ID: "batch-1", Status: "completed", OutputFileID: "file-out"}); err == nil || !strings.Contains(err.Error(), "invalid batch result") {
+		t.Errorf("got error %v, want an invalid result", err)
+	}
+}
+
+func TestBatchJobSaveLoad(t *testing.T) {
+	job := &BatchJob{
+		ID:           "batch-1",
+		Deployment:   "emb-batch",
+		InputFileID:  "file-in",
+		OutputFileID: "file-out",
+		Status:       "in_progress",
+		Total:        3,
+		Completed:    1,
+		CreatedAt:    time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC),
+		Errors:       []string{"line 2: invalid"},
+	}
+	path := filepath.Join(t.TempDir(), "batch.json")
+	if err := job.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBatchJob(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, job) {
+		t.Errorf("got %+v, want %+v", loaded, job)
+	}
+
+	if _, err := LoadBatchJob(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want os.ErrNotExist", err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBatchJob(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("got error %v, want an error naming %s", err, path)
+	}
+}
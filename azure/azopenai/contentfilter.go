@@ -0,0 +1,271 @@
+package azopenai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/openai/openai-go/option"
+)
+
+// Sources of content filter results.
+const (
+	FilterSourcePrompt     = "prompt"
+	FilterSourceCompletion = "completion"
+)
+
+// contentFilterKey is the key of ai.ModelResponse.Custom that holds the
+// content filter results of a response.
+const contentFilterKey = "contentFilterResults"
+
+// ContentFilterResult is the result of a content filter category, e.g.
+// "hate" or "jailbreak".
+type ContentFilterResult struct {
+	Filtered bool `json:"filtered"`
+	// Severity is set for harm categories, e.g. "safe", "low", "medium" or
+	// "high".
+	Severity string `json:"severity,omitempty"`
+	// Detected is set for detection categories, e.g. "jailbreak" or
+	// "protected_material_text".
+	Detected *bool `json:"detected,omitempty"`
+}
+
+// ContentFilterResults are the content filter annotations of a request,
+// keyed by category. If a model response has annotations, they are also
+// available as ai.ModelResponse.Custom["contentFilterResults"].
+type ContentFilterResults struct {
+	Prompt     map[string]ContentFilterResult `json:"prompt,omitempty"`
+	Completion map[string]ContentFilterResult `json:"completion,omitempty"`
+}
+
+// ContentFilteredError is returned by models if Azure's content filter blocked
+// the prompt or the completion.
+type ContentFilteredError struct {
+	// Source is FilterSourcePrompt or FilterSourceCompletion.
+	Source string
+	// Category and Severity describe the most severe filtered category.
+	// Severity is empty for detection categories such as "jailbreak".
+	Category string
+	Severity string
+	Results  ContentFilterResults
+	// Err is the error returned by the API if it rejected the request.
+	Err error
+}
+
+func (e *ContentFilteredError) Error() string {
+	if e.Severity == "" {
+		return fmt.Sprintf("azopenai: %s blocked by content filter (category %q)", e.Source, e.Category)
+	}
+	return fmt.Sprintf("azopenai: %s blocked by content filter (category %q, severity %q)", e.Source, e.Category, e.Severity)
+}
+
+func (e *ContentFilteredError) Unwrap() error {
+	return e.Err
+}
+
+type filterCollectorKey struct{}
+
+// filterCollector receives the content filter results of the requests sent
+// for a single model call.
+type filterCollector struct {
+	mu      sync.Mutex
+	results ContentFilterResults
+	// rejected is set if the API rejected the prompt
+	rejected bool
+}
+
+func withFilterCollector(ctx context.Context) (context.Context, *filterCollector) {
+	c := &filterCollector{}
+	return context.WithValue(ctx, filterCollectorKey{}, c), c
+}
+
+func (c *filterCollector) set(prompt, completion map[string]ContentFilterResult, rejected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = ContentFilterResults{Prompt: prompt, Completion: completion}
+	c.rejected = rejected
+}
+
+// merge adds the results of a streamed chunk to the collected results.
+func (c *filterCollector) merge(prompt, completion map[string]ContentFilterResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results.Prompt = mergeCategories(c.results.Prompt, prompt)
+	c.results.Completion = mergeCategories(c.results.Completion, completion)
+}
+
+// apply attaches the collected results to resp, and turns blocked responses
+// and rejected requests into a *ContentFilteredError.
+func (c *filterCollector) apply(resp *ai.ModelResponse, err error) (*ai.ModelResponse, error) {
+	c.mu.Lock()
+	results, rejected := c.results, c.rejected
+	c.mu.Unlock()
+
+	if rejected && err != nil {
+		e := newContentFilteredError(FilterSourcePrompt, results)
+		e.Err = err
+		return nil, e
+	}
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	if results.Prompt != nil || results.Completion != nil {
+		setCustom(resp, contentFilterKey, results)
+	}
+	if resp.FinishReason == ai.FinishReasonBlocked {
+		return nil, newContentFilteredError(FilterSourceCompletion, results)
+	}
+	return resp, nil
+}
+
+var severities = []string{"safe", "low", "medium", "high"}
+
+func newContentFilteredError(source string, results ContentFilterResults) *ContentFilteredError {
+	e := &ContentFilteredError{Source: source, Results: results}
+	categories := results.Completion
+	if source == FilterSourcePrompt {
+		categories = results.Prompt
+	}
+	rank := -2
+	for _, name := range sortedKeys(categories) {
+		r := categories[name]
+		if !r.Filtered {
+			continue
+		}
+		if s := slices.Index(severities, r.Severity); s > rank {
+			e.Category, e.Severity, rank = name, r.Severity, s
+		}
+	}
+	return e
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// parseCategories decodes a content_filter_results object. Attributes that
+// are not categories, such as "error", are skipped.
+func parseCategories(raw json.RawMessage) map[string]ContentFilterResult {
+	var m map[string]json.RawMessage
+	if json.Unmarshal(raw, &m) != nil || len(m) == 0 {
+		return nil
+	}
+	results := make(map[string]ContentFilterResult, len(m))
+	for k, v := range m {
+		var r ContentFilterResult
+		if k == "error" || json.Unmarshal(v, &r) != nil {
+			continue
+		}
+		results[k] = r
+	}
+	return results
+}
+
+// mergeCategories merges the results of multiple prompts, keeping filtered
+// and more severe results.
+func mergeCategories(dst, src map[string]ContentFilterResult) map[string]ContentFilterResult {
+	if dst == nil {
+		return src
+	}
+	for k, r := range src {
+		d, ok := dst[k]
+		if !ok || r.Filtered && !d.Filtered || r.Filtered == d.Filtered && slices.Index(severities, r.Severity) > slices.Index(severities, d.Severity) {
+			dst[k] = r
+		}
+	}
+	return dst
+}
+
+// filterAnnotations are the content filter annotations of a chat completion
+// or of a streamed chunk.
+type filterAnnotations struct {
+	PromptFilterResults []struct {
+		ContentFilterResults json.RawMessage `json:"content_filter_results"`
+	} `json:"prompt_filter_results"`
+	Choices []struct {
+		ContentFilterResults json.RawMessage `json:"content_filter_results"`
+	} `json:"choices"`
+}
+
+func (f filterAnnotations) results() (prompt, completion map[string]ContentFilterResult) {
+	for _, p := range f.PromptFilterResults {
+		prompt = mergeCategories(prompt, parseCategories(p.ContentFilterResults))
+	}
+	// Genkit only uses the first choice
+	if len(f.Choices) > 0 {
+		completion = parseCategories(f.Choices[0].ContentFilterResults)
+	}
+	return prompt, completion
+}
+
+// captureContentFilter returns middleware that passes the content filter
+// annotations of JSON responses and streamed chunks to the model call that
+// sent the request.
+func captureContentFilter() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		c, ok := req.Context().Value(filterCollectorKey{}).(*filterCollector)
+		if !ok {
+			return next(req)
+		}
+		res, err := next(req)
+		if err != nil {
+			return res, err
+		}
+		if res.StatusCode == http.StatusOK && isEventStream(res.Header.Get("Content-Type")) {
+			res.Body = observeEvents(res.Body, func(data []byte) {
+				var chunk filterAnnotations
+				if json.Unmarshal(data, &chunk) != nil {
+					return
+				}
+				if prompt, completion := chunk.results(); prompt != nil || completion != nil {
+					c.merge(prompt, completion)
+				}
+			})
+			return res, nil
+		}
+		if !isJSON(res.Header.Get("Content-Type")) {
+			return res, nil
+		}
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == http.StatusBadRequest {
+			var body struct {
+				Error struct {
+					Code       string `json:"code"`
+					InnerError struct {
+						ContentFilterResult json.RawMessage `json:"content_filter_result"`
+					} `json:"innererror"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(b, &body) == nil && body.Error.Code == "content_filter" {
+				c.set(parseCategories(body.Error.InnerError.ContentFilterResult), nil, true)
+			}
+			return res, nil
+		}
+
+		var body filterAnnotations
+		if res.StatusCode != http.StatusOK || json.Unmarshal(b, &body) != nil {
+			return res, nil
+		}
+		if prompt, completion := body.results(); prompt != nil || completion != nil {
+			c.set(prompt, completion, false)
+		}
+		return res, nil
+	})
+}
@@ -0,0 +1,281 @@
+package azopenai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/openai/openai-go"
+)
+
+func filterResult(filtered bool, severity string) map[string]any {
+	return map[string]any{"filtered": filtered, "severity": severity}
+}
+
+// annotatedCompletion returns a chat completion with content filter
+// annotations of the prompt and the completion.
+func annotatedCompletion(text, finishReason string, prompt, completion map[string]any) map[string]any {
+	c := chatCompletion(text)
+	choice := c["choices"].([]any)[0].(map[string]any)
+	choice["finish_reason"] = finishReason
+	choice["content_filter_results"] = completion
+	c["prompt_filter_results"] = []any{map[string]any{"prompt_index": 0, "content_filter_results": prompt}}
+	return c
+}
+
+// generate sends a prompt to the chat model of srv.
+func generate(t *testing.T, srv *fakeServer, opts ...ai.GenerateOption) (*ai.ModelResponse, error) {
+	t.Helper()
+	g := initGenkit(t, srv, WithModel("chat", Deployment{Name: "gpt-dep"}))
+	return genkit.Generate(context.Background(), g, append([]ai.GenerateOption{ai.WithModelName("openai/chat"), ai.WithPrompt("Hi")}, opts...)...)
+}
+
+func TestContentFilterRejectedPrompt(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]any{"error": map[string]any{
+			"code":    "content_filter",
+			"message": "The prompt was filtered.",
+			"status":  400,
+			"innererror": map[string]any{
+				"code": "ResponsibleAIPolicyViolation",
+				"content_filter_result": map[string]any{
+					"hate":      filterResult(true, "high"),
+					"violence":  filterResult(false, "safe"),
+					"jailbreak": map[string]any{"filtered": true, "detected": true},
+				},
+			},
+		}})
+	})
+	_, err := generate(t, srv)
+
+	var cfe *ContentFilteredError
+	if !errors.As(err, &cfe) {
+		t.Fatalf("got error %v, want a *ContentFilteredError", err)
+	}
+	if cfe.Source != FilterSourcePrompt || cfe.Category != "hate" || cfe.Severity != "high" {
+		t.Errorf("got %s, category %q and severity %q, want the prompt's hate category", cfe.Source, cfe.Category, cfe.Severity)
+	}
+	detected := true
+	want := ContentFilterResults{Prompt: map[string]ContentFilterResult{
+		"hate":      {Filtered: true, Severity: "high"},
+		"violence":  {Severity: "safe"},
+		"jailbreak": {Filtered: true, Detected: &detected},
+	}}
+	if !reflect.DeepEqual(cfe.Results, want) {
+		t.Errorf("got results %+v, want %+v", cfe.Results, want)
+	}
+	// The API's error is still available
+	var apiErr *openai.Error
+	if !errors.As(cfe.Unwrap(), &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("got wrapped error %v, want the API error", cfe.Unwrap())
+	}
+	if !errors.As(err, &apiErr) {
+		t.Errorf("error %v doesn't wrap the API error", err)
+	}
+}
+
+func TestContentFilterBadRequest(t *testing.T) {
+	// Other bad requests are returned as is
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]any{"error": map[string]any{"code": "invalid_request", "message": "bad"}})
+	})
+	_, err := generate(t, srv)
+	var cfe *ContentFilteredError
+	if err == nil || errors.As(err, &cfe) {
+		t.Errorf("got error %v, want the API error", err)
+	}
+}
+
+func TestContentFilterBlockedCompletion(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeJSON(w, annotatedCompletion("", "content_filter",
+			map[string]any{"hate": filterResult(false, "safe")},
+			map[string]any{"sexual": filterResult(true, "medium"), "violence": filterResult(false, "low")},
+		))
+	})
+	_, err := generate(t, srv)
+
+	var cfe *ContentFilteredError
+	if !errors.As(err, &cfe) {
+		t.Fatalf("got error %v, want a *ContentFilteredError", err)
+	}
+	if cfe.Source != FilterSourceCompletion || cfe.Category != "sexual" || cfe.Severity != "medium" || cfe.Err != nil {
+		t.Errorf("got %+v, want the completion's sexual category", cfe)
+	}
+	want := ContentFilterResults{
+		Prompt:     map[string]ContentFilterResult{"hate": {Severity: "safe"}},
+		Completion: map[string]ContentFilterResult{"sexual": {Filtered: true, Severity: "medium"}, "violence": {Severity: "low"}},
+	}
+	if !reflect.DeepEqual(cfe.Results, want) {
+		t.Errorf("got results %+v, want %+v", cfe.Results, want)
+	}
+}
+
+func TestContentFilterAnnotations(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeJSON(w, annotatedCompletion("Hello", "stop",
+			map[string]any{"hate": filterResult(false, "safe"), "jailbreak": map[string]any{"filtered": false, "detected": false}},
+			map[string]any{"violence": filterResult(false, "low"), "error": map[string]any{"code": "x"}},
+		))
+	})
+	resp, err := generate(t, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detected := false
+	want := ContentFilterResults{
+		Prompt:     map[string]ContentFilterResult{"hate": {Severity: "safe"}, "jailbreak": {Detected: &detected}},
+		Completion: map[string]ContentFilterResult{"violence": {Severity: "low"}},
+	}
+	custom, _ := resp.Custom.(map[string]any)
+	if got := custom[contentFilterKey]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %+v, want %+v", got, want)
+	}
+}
+
+func TestContentFilterWithoutAnnotations(t *testing.T) {
+	resp, err := generate(t, newFakeServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if custom, _ := resp.Custom.(map[string]any); custom[contentFilterKey] != nil {
+		t.Errorf("got results %+v", custom[contentFilterKey])
+	}
+}
+
+func TestContentFilterStream(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		// Azure sends the prompt's annotations in a chunk without choices,
+		// and the completion's annotations with each chunk
+		promptChunk := chatChunk(nil, nil)
+		promptChunk["choices"] = []any{}
+		promptChunk["prompt_filter_results"] = []any{map[string]any{
+			"prompt_index":           0,
+			"content_filter_results": map[string]any{"hate": filterResult(false, "safe")},
+		}}
+		hel := chatChunk(map[string]any{"content": "Hel"}, nil)
+		hel["choices"].([]any)[0].(map[string]any)["content_filter_results"] = map[string]any{"hate": filterResult(false, "low")}
+		lo := chatChunk(map[string]any{"content": "lo"}, nil)
+		lo["choices"].([]any)[0].(map[string]any)["content_filter_results"] = map[string]any{
+			"hate":     filterResult(false, "safe"),
+			"violence": filterResult(false, "safe"),
+		}
+		writeEvents(w, promptChunk, hel, lo, chatChunk(map[string]any{}, "stop"), "[DONE]")
+	})
+	var chunks []*ai.ModelResponseChunk
+	resp, err := generate(t, srv, ai.WithStreaming(collect(&chunks)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "Hello" {
+		t.Errorf("got text %q, want Hello", resp.Text())
+	}
+	// The most severe result of each category is kept
+	want := ContentFilterResults{
+		Prompt:     map[string]ContentFilterResult{"hate": {Severity: "safe"}},
+		Completion: map[string]ContentFilterResult{"hate": {Severity: "low"}, "violence": {Severity: "safe"}},
+	}
+	custom, _ := resp.Custom.(map[string]any)
+	if got := custom[contentFilterKey]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got results %+v, want %+v", got, want)
+	}
+}
+
+func TestNewContentFilteredError(t *testing.T) {
+	detected := true
+	tests := []struct {
+		name     string
+		results  map[string]ContentFilterResult
+		category string
+		severity string
+		msg      string
+	}{
+		{
+			name: "most severe",
+			results: map[string]ContentFilterResult{
+				"hate":      {Filtered: true, Severity: "medium"},
+				"violence":  {Filtered: true, Severity: "high"},
+				"sexual":    {Severity: "high"},
+				"jailbreak": {Filtered: true, Detected: &detected},
+			},
+			category: "violence",
+			severity: "high",
+			msg:      `azopenai: completion blocked by content filter (category "violence", severity "high")`,
+		},
+		{
+			name:     "detection",
+			results:  map[string]ContentFilterResult{"hate": {Severity: "safe"}, "protected_material_text": {Filtered: true, Detected: &detected}},
+			category: "protected_material_text",
+			msg:      `azopenai: completion blocked by content filter (category "protected_material_text")`,
+		},
+		{
+			name: "nothing filtered",
+			msg:  `azopenai: completion blocked by content filter (category "")`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newContentFilteredError(FilterSourceCompletion, ContentFilterResults{Completion: tt.results})
+			if e.Category != tt.category || e.Severity != tt.severity {
+				t.Errorf("got category %q and severity %q, want %q and %q", e.Category, e.Severity, tt.category, tt.severity)
+			}
+			if e.Error() != tt.msg {
+				t.Errorf("got message %q, want %q", e.Error(), tt.msg)
+			}
+		})
+	}
+}
+
+func TestFilterCollectorApply(t *testing.T) {
+	results := ContentFilterResults{Prompt: map[string]ContentFilterResult{"hate": {Filtered: true, Severity: "low"}}}
+	apiErr := errors.New("bad request")
+	tests := []struct {
+		name     string
+		rejected bool
+		resp     *ai.ModelResponse
+		err      error
+		wantErr  error
+		source   string
+	}{
+		{name: "rejected", rejected: true, err: apiErr, source: FilterSourcePrompt},
+		{name: "other error", err: apiErr, wantErr: apiErr},
+		{name: "blocked", resp: &ai.ModelResponse{FinishReason: ai.FinishReasonBlocked}, source: FilterSourceCompletion},
+		{name: "stopped", resp: &ai.ModelResponse{FinishReason: ai.FinishReasonStop}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, c := withFilterCollector(context.Background())
+			c.set(results.Prompt, nil, tt.rejected)
+			resp, err := c.apply(tt.resp, tt.err)
+			if tt.source == "" {
+				if err != tt.wantErr {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if resp != nil && !reflect.DeepEqual(resp.Custom, map[string]any{contentFilterKey: results}) {
+					t.Errorf("got custom %+v", resp.Custom)
+				}
+				return
+			}
+			var cfe *ContentFilteredError
+			if !errors.As(err, &cfe) || cfe.Source != tt.source || resp != nil {
+				t.Fatalf("got %v and error %v, want a %s *ContentFilteredError", resp, err, tt.source)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("error %v doesn't wrap %v", err, tt.err)
+			}
+		})
+	}
+}
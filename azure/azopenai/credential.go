@@ -0,0 +1,231 @@
+package azopenai
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// defaultRefreshBefore is how long before expiry a cached token is refreshed
+// unless AzureOpenAI.TokenRefreshBefore is set.
+const defaultRefreshBefore = 5 * time.Minute
+
+// tokenRequestTimeout bounds each request for a new access token, since
+// it is not canceled with the outgoing request that triggered it.
+const tokenRequestTimeout = 30 * time.Second
+
+// WithManagedIdentity authenticates with the system-assigned managed
+// identity of the host, or with the user-assigned managed identity with the
+// given client ID if clientID is not empty.
+func WithManagedIdentity(clientID string) Option {
+	return func(a *AzureOpenAI) {
+		var opts azidentity.ManagedIdentityCredentialOptions
+		if clientID != "" {
+			opts.ID = azidentity.ClientID(clientID)
+		}
+		a.setCredential(azidentity.NewManagedIdentityCredential(&opts))
+	}
+}
+
+// WithWorkloadIdentity authenticates with a federated service account token,
+// e.g. from Azure Kubernetes Service workload identity. Empty arguments
+// default to the environment variables AZURE_TENANT_ID, AZURE_CLIENT_ID and
+// AZURE_FEDERATED_TOKEN_FILE.
+func WithWorkloadIdentity(tenantID, clientID, tokenFilePath string) Option {
+	return func(a *AzureOpenAI) {
+		a.setCredential(azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			TenantID:      tenantID,
+			ClientID:      clientID,
+			TokenFilePath: tokenFilePath,
+		}))
+	}
+}
+
+// WithClientSecret authenticates as a service principal with a client secret.
+func WithClientSecret(tenantID, clientID, secret string) Option {
+	return func(a *AzureOpenAI) {
+		a.setCredential(azidentity.NewClientSecretCredential(tenantID, clientID, secret, nil))
+	}
+}
+
+// WithClientCertificate authenticates as a service principal with a
+// certificate and private key in PEM or PKCS#12 format read from certPath.
+// Pass nil for password if the private key isn't encrypted.
+func WithClientCertificate(tenantID, clientID, certPath string, password []byte) Option {
+	return func(a *AzureOpenAI) {
+		data, err := os.ReadFile(certPath)
+		if err != nil {
+			a.credErr = err
+			return
+		}
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			a.credErr = err
+			return
+		}
+		a.setCredential(azidentity.NewClientCertificateCredential(tenantID, clientID, certs, key, nil))
+	}
+}
+
+// WithTokenRefreshBefore sets how long before their expiry cached access
+// tokens are refreshed in the background.
+func WithTokenRefreshBefore(d time.Duration) Option {
+	return func(a *AzureOpenAI) {
+		a.TokenRefreshBefore = d
+	}
+}
+
+func (a *AzureOpenAI) setCredential(cred azcore.TokenCredential, err error) {
+	if err != nil {
+		a.credErr = err
+		return
+	}
+	a.TokenCredential = cred
+}
+
+// TokenStats are the counters of an endpoint's access token cache.
+type TokenStats struct {
+	// Requests is the number of tokens requested for outgoing requests.
+	Requests int64
+	// Hits is the number of requests served from the cache.
+	Hits int64
+	// Refreshes is the number of tokens acquired from the credential, of
+	// which BackgroundRefreshes were acquired before the cached token expired.
+	Refreshes           int64
+	BackgroundRefreshes int64
+	// Failures is the number of failed attempts to acquire a token.
+	Failures int64
+	// ExpiresOn is the expiry of the cached token.
+	ExpiresOn time.Time
+}
+
+// TokenStats returns the token cache counters of all endpoints that use a
+// TokenCredential, keyed by endpoint host.
+func (a *AzureOpenAI) TokenStats() map[string]TokenStats {
+	stats := make(map[string]TokenStats)
+	for _, ep := range a.endpoints {
+		if c := ep.auth.cred; c != nil {
+			stats[ep.base.Host] = c.stats()
+		}
+	}
+	return stats
+}
+
+// cachingCredential caches the access token for the Azure OpenAI scope and
+// refreshes it in the background before it expires, so that requests do not
+// wait for Entra ID. Concurrent requests share a single token request.
+type cachingCredential struct {
+	cred          azcore.TokenCredential
+	refreshBefore time.Duration
+
+	mu       sync.Mutex
+	token    azcore.AccessToken
+	inflight *tokenCall
+	counters TokenStats
+}
+
+// tokenCall is a token request that callers wait for until done is closed.
+type tokenCall struct {
+	done chan struct{}
+	tok  azcore.AccessToken
+	err  error
+}
+
+func newCachingCredential(cred azcore.TokenCredential, refreshBefore time.Duration) *cachingCredential {
+	if refreshBefore <= 0 {
+		refreshBefore = defaultRefreshBefore
+	}
+	return &cachingCredential{cred: cred, refreshBefore: refreshBefore}
+}
+
+// refreshAt returns when tok should be refreshed.
+func (c *cachingCredential) refreshAt(tok azcore.AccessToken) time.Time {
+	if !tok.RefreshOn.IsZero() {
+		return tok.RefreshOn
+	}
+	return tok.ExpiresOn.Add(-c.refreshBefore)
+}
+
+// get returns a valid access token. If the cached token is due for refresh
+// but has not expired, it is returned while a new token is acquired in the
+// background. Otherwise, get waits for a new token, or for ctx to be done.
+func (c *cachingCredential) get(ctx context.Context) (string, error) {
+	now := time.Now()
+	c.mu.Lock()
+	c.counters.Requests++
+	tok := c.token
+	if tok.Token != "" && now.Before(tok.ExpiresOn.Add(-30*time.Second)) {
+		c.counters.Hits++
+		if now.After(c.refreshAt(tok)) && c.inflight == nil {
+			c.refresh(ctx, true)
+		}
+		c.mu.Unlock()
+		return tok.Token, nil
+	}
+	call := c.inflight
+	if call == nil {
+		call = c.refresh(ctx, false)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return "", call.err
+		}
+		return call.tok.Token, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// refresh starts acquiring a new token, which all callers of get wait for
+// until it completes. The request outlives ctx's cancelation, since other
+// callers may be waiting for it, but not tokenRequestTimeout. The caller
+// must hold c.mu.
+func (c *cachingCredential) refresh(ctx context.Context, background bool) *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	c.inflight = call
+	go func() {
+		defer close(call.done)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenRequestTimeout)
+		defer cancel()
+		tok, err := c.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{cognitiveServicesScope}})
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.inflight = nil
+		call.tok, call.err = tok, err
+		if err != nil {
+			c.counters.Failures++
+			return
+		}
+		c.counters.Refreshes++
+		if background {
+			c.counters.BackgroundRefreshes++
+		}
+		if tok.ExpiresOn.After(c.token.ExpiresOn) {
+			c.token = tok
+			c.counters.ExpiresOn = tok.ExpiresOn
+		}
+	}()
+	return call
+}
+
+// invalidate drops the cached token, e.g. after it has been rejected.
+func (c *cachingCredential) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = azcore.AccessToken{}
+}
+
+func (c *cachingCredential) stats() TokenStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counters
+}
@@ -0,0 +1,204 @@
+package azopenai
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// fakeCredential issues numbered tokens that expire after ttl.
+type fakeCredential struct {
+	ttl time.Duration
+	// release, if set, blocks GetToken until it is closed
+	release chan struct{}
+
+	mu          sync.Mutex
+	err         error
+	calls       int
+	hasDeadline bool
+}
+
+func (c *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if c.release != nil {
+		select {
+		case <-c.release:
+		case <-ctx.Done():
+			return azcore.AccessToken{}, ctx.Err()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	_, c.hasDeadline = ctx.Deadline()
+	if c.err != nil {
+		return azcore.AccessToken{}, c.err
+	}
+	return azcore.AccessToken{
+		Token:     fmt.Sprintf("token-%d", c.calls),
+		ExpiresOn: time.Now().Add(cmp.Or(c.ttl, time.Hour)),
+	}, nil
+}
+
+func (c *fakeCredential) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// callCount returns the number of calls, and whether the last one had a
+// deadline.
+func (c *fakeCredential) callCount() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls, c.hasDeadline
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func getToken(t *testing.T, c *cachingCredential) string {
+	t.Helper()
+	tok, err := c.get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestCachingCredentialHit(t *testing.T) {
+	cred := &fakeCredential{}
+	c := newCachingCredential(cred, 0)
+	for range 3 {
+		if tok := getToken(t, c); tok != "token-1" {
+			t.Errorf("got %q, want token-1", tok)
+		}
+	}
+	s := c.stats()
+	if s.Requests != 3 || s.Hits != 2 || s.Refreshes != 1 || s.BackgroundRefreshes != 0 || s.Failures != 0 {
+		t.Errorf("got stats %+v", s)
+	}
+	if calls, _ := cred.callCount(); calls != 1 {
+		t.Errorf("credential called %d times, want once", calls)
+	}
+}
+
+func TestCachingCredentialSharesRequest(t *testing.T) {
+	cred := &fakeCredential{release: make(chan struct{})}
+	c := newCachingCredential(cred, 0)
+
+	const n = 10
+	var wg sync.WaitGroup
+	tokens := make([]string, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], errs[i] = c.get(context.Background())
+		}()
+	}
+	waitFor(t, func() bool { return c.stats().Requests == n })
+	close(cred.release)
+	wg.Wait()
+
+	for i := range n {
+		if errs[i] != nil || tokens[i] != "token-1" {
+			t.Errorf("caller %d got %q, %v, want token-1", i, tokens[i], errs[i])
+		}
+	}
+	if calls, _ := cred.callCount(); calls != 1 {
+		t.Errorf("credential called %d times, want once", calls)
+	}
+	if s := c.stats(); s.Refreshes != 1 || s.Hits != 0 {
+		t.Errorf("got stats %+v", s)
+	}
+}
+
+func TestCachingCredentialCanceledWaiter(t *testing.T) {
+	cred := &fakeCredential{release: make(chan struct{})}
+	c := newCachingCredential(cred, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := c.get(ctx)
+		done <- err
+	}()
+	waitFor(t, func() bool { return c.stats().Requests == 1 })
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	// The canceled caller's token request completes for the next caller
+	close(cred.release)
+	if tok := getToken(t, c); tok != "token-1" {
+		t.Errorf("got %q, want token-1", tok)
+	}
+	if calls, deadline := cred.callCount(); calls != 1 || !deadline {
+		t.Errorf("credential called %d times, with deadline %v, want once with deadline", calls, deadline)
+	}
+}
+
+func TestCachingCredentialBackgroundRefresh(t *testing.T) {
+	// Tokens are due for refresh as soon as they are issued
+	cred := &fakeCredential{ttl: 30 * time.Minute}
+	c := newCachingCredential(cred, time.Hour)
+
+	if tok := getToken(t, c); tok != "token-1" {
+		t.Fatalf("got %q, want token-1", tok)
+	}
+	// The cached token is returned while a new one is requested
+	if tok := getToken(t, c); tok != "token-1" {
+		t.Fatalf("got %q, want token-1", tok)
+	}
+	waitFor(t, func() bool { return c.stats().BackgroundRefreshes == 1 })
+	if _, deadline := cred.callCount(); !deadline {
+		t.Error("background refresh has no deadline")
+	}
+	if tok := getToken(t, c); tok != "token-2" {
+		t.Errorf("got %q, want token-2", tok)
+	}
+	s := c.stats()
+	if s.Requests != 3 || s.Hits != 2 || s.Refreshes < 2 {
+		t.Errorf("got stats %+v", s)
+	}
+}
+
+func TestCachingCredentialFailure(t *testing.T) {
+	cred := &fakeCredential{}
+	cred.setErr(errors.New("unavailable"))
+	c := newCachingCredential(cred, 0)
+	if _, err := c.get(context.Background()); err == nil || err.Error() != "unavailable" {
+		t.Fatalf("got error %v, want unavailable", err)
+	}
+
+	// Failures are not cached
+	cred.setErr(nil)
+	if tok := getToken(t, c); tok != "token-2" {
+		t.Errorf("got %q, want token-2", tok)
+	}
+	c.invalidate()
+	if tok := getToken(t, c); tok != "token-3" {
+		t.Errorf("got %q after invalidate, want token-3", tok)
+	}
+	s := c.stats()
+	if s.Requests != 3 || s.Hits != 0 || s.Refreshes != 2 || s.Failures != 1 {
+		t.Errorf("got stats %+v", s)
+	}
+}
@@ -0,0 +1,249 @@
+package azopenai
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/plugins/compat_oai"
+	"github.com/openai/openai-go/option"
+)
+
+// Deployment describes an Azure OpenAI deployment that the plugin registers
+// under a Genkit model or embedder name.
+type Deployment struct {
+	// Name is the Azure deployment name. Defaults to the Genkit name.
+	Name string
+	// APIVersion overrides the plugin's api-version for this deployment.
+	// It is only used with the deployment-based API.
+	APIVersion string
+	// Supports describes a model's capabilities. Defaults to
+	// compat_oai.Multimodal. Ignored for embedders.
+	Supports *ai.ModelSupports
+	// Dimensions is the number of dimensions of an embedder's vectors. If
+	// set, it is sent with each embeddings request, so that models that
+	// support it (e.g. text-embedding-3) return vectors of that size.
+	// Ignored for models.
+	Dimensions int
+	// RateLimit overrides the plugin's RateLimit for this deployment.
+	RateLimit RateLimit
+	// API selects the API a model is sent to. Defaults to
+	// APIChatCompletions. Ignored for embedders.
+	API ModelAPI
+}
+
+type routeKey struct{}
+
+// route is the result of routing a request to a deployment.
+type route struct {
+	deployment Deployment
+	// tokens is the estimated number of tokens of the request
+	tokens int
+}
+
+// WithModel registers the Azure deployment d as the Genkit model name.
+func WithModel(name string, d Deployment) Option {
+	return func(a *AzureOpenAI) {
+		if a.Models == nil {
+			a.Models = make(map[string]Deployment)
+		}
+		a.Models[name] = d
+	}
+}
+
+// WithEmbedder registers the Azure deployment d as the Genkit embedder name.
+func WithEmbedder(name string, d Deployment) Option {
+	return func(a *AzureOpenAI) {
+		if a.Embedders == nil {
+			a.Embedders = make(map[string]Deployment)
+		}
+		a.Embedders[name] = d
+	}
+}
+
+func (a *AzureOpenAI) hasDeployments() bool {
+	return len(a.Models) > 0 || len(a.Embedders) > 0
+}
+
+// lookupDeployment returns the deployment for the Genkit model or embedder
+// name. It falls back to the plugin's default Deployment, and finally to
+// name itself.
+func (a *AzureOpenAI) lookupDeployment(name string) Deployment {
+	if d, ok := a.Models[name]; ok {
+		return d.withDefaults(name)
+	}
+	if d, ok := a.Embedders[name]; ok {
+		return d.withDefaults(name)
+	}
+	if a.Deployment != "" {
+		return Deployment{Name: a.Deployment}
+	}
+	return Deployment{Name: name}
+}
+
+func (d Deployment) withDefaults(name string) Deployment {
+	if d.Name == "" {
+		d.Name = name
+	}
+	return d
+}
+
+func (a *AzureOpenAI) validateDeployments() []error {
+	var errs []error
+	for name := range a.Models {
+		if name == "" {
+			errs = append(errs, &ConfigError{Field: "Models", Err: fmt.Errorf("model name must not be empty")})
+		}
+		if _, ok := a.Embedders[name]; ok {
+			errs = append(errs, &ConfigError{Field: "Models", Err: fmt.Errorf("%q is registered as both model and embedder", name)})
+		}
+		if api := a.Models[name].API; api != APIChatCompletions && api != APIResponses {
+			errs = append(errs, &ConfigError{Field: "Models", Err: fmt.Errorf("%q: unknown API %v", name, api)})
+		}
+	}
+	for name := range a.Embedders {
+		if name == "" {
+			errs = append(errs, &ConfigError{Field: "Embedders", Err: fmt.Errorf("embedder name must not be empty")})
+		}
+	}
+	return errs
+}
+
+// defineDeployments adds model and embedder actions for all configured
+// deployments. Actions already returned by the OpenAI plugin for the same
+// name are replaced.
+func (a *AzureOpenAI) defineDeployments(actions []api.Action) []api.Action {
+	index := make(map[string]int, len(actions))
+	for i, action := range actions {
+		index[action.Name()] = i
+	}
+	add := func(action api.Action) {
+		if i, ok := index[action.Name()]; ok {
+			actions[i] = action
+			return
+		}
+		actions = append(actions, action)
+	}
+
+	for name, d := range a.Models {
+		d = d.withDefaults(name)
+		supports := d.Supports
+		if supports == nil {
+			supports = &compat_oai.Multimodal
+		}
+		opts := ai.ModelOptions{
+			Label:    fmt.Sprintf("Azure OpenAI - %s (%s)", name, d.Name),
+			Supports: supports,
+			Stage:    ai.ModelStageStable,
+		}
+		if d.API == APIResponses {
+			add(a.defineResponsesModel(name, opts).(api.Action))
+			continue
+		}
+		add(a.DefineModel(name, opts).(api.Action))
+	}
+	for name, d := range a.Embedders {
+		d = d.withDefaults(name)
+		add(a.OpenAI.DefineEmbedder(name, &ai.EmbedderOptions{
+			Label:      fmt.Sprintf("Azure OpenAI - %s (%s)", name, d.Name),
+			Dimensions: d.Dimensions,
+			Supports: &ai.EmbedderSupports{
+				Input: []string{"text"},
+			},
+		}).(api.Action))
+	}
+	return actions
+}
+
+// routeToDeployment returns middleware that sends each request to the
+// deployment registered for the request's "model" attribute. With the
+// deployment-based API, the deployment is added to the URL path and "model"
+// is removed from the request body. With the v1 API and the Responses API,
+// "model" is replaced by the deployment name. Embeddings requests ask for the
+// deployment's Dimensions, and streamed chat completions request usage stats,
+// unless the request sets dimensions or stream_options.
+func (a *AzureOpenAI) routeToDeployment() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		ep := a.endpointFor(req)
+		deploymentPath := ep.mode == APIModeDeployment
+		if req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+			if deploymentPath {
+				setAPIVersion(req, cmp.Or(a.apiVersion(), defaultAPIVersion))
+			}
+			return next(req)
+		}
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(b, &body); err != nil {
+			return nil, err
+		}
+		raw, ok := body["model"]
+		if !ok {
+			// Not a model request, e.g. creating a batch job
+			if deploymentPath {
+				setAPIVersion(req, cmp.Or(a.apiVersion(), defaultAPIVersion))
+			}
+			req.Body = io.NopCloser(bytes.NewReader(b))
+			return next(req)
+		}
+		var model string
+		if err := json.Unmarshal(raw, &model); err != nil {
+			return nil, err
+		}
+		d := a.lookupDeployment(model)
+		if name, ok := ep.Deployments[model]; ok {
+			d.Name = name
+		}
+
+		if strings.HasSuffix(req.URL.Path, "/responses") {
+			// The Responses API is not deployment-based, it always expects the
+			// deployment name as "model"
+			body["model"], _ = json.Marshal(d.Name)
+			if deploymentPath {
+				setAPIVersion(req, cmp.Or(d.APIVersion, responsesAPIVersion))
+			}
+		} else if deploymentPath {
+			delete(body, "model")
+			// Path is unescaped, the deployment name is escaped when sending
+			req.URL.Path = strings.Replace(req.URL.Path, "/openai/", "/openai/deployments/"+d.Name+"/", 1)
+			req.URL.RawPath = ""
+			setAPIVersion(req, cmp.Or(d.APIVersion, a.apiVersion(), defaultAPIVersion))
+		} else {
+			body["model"], _ = json.Marshal(d.Name)
+		}
+		if _, ok := body["dimensions"]; !ok && d.Dimensions > 0 && strings.HasSuffix(req.URL.Path, "/embeddings") {
+			body["dimensions"], _ = json.Marshal(d.Dimensions)
+		}
+		if _, ok := body["stream_options"]; !ok && string(body["stream"]) == "true" && strings.HasSuffix(req.URL.Path, "/chat/completions") {
+			// Report usage in the final chunk of streamed chat completions
+			body["stream_options"] = json.RawMessage(`{"include_usage":true}`)
+		}
+
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		rr := route{deployment: d, tokens: estimateTokens(body, len(b))}
+		return next(req.WithContext(context.WithValue(req.Context(), routeKey{}, rr)))
+	})
+}
+
+func setAPIVersion(req *http.Request, version string) {
+	q := req.URL.Query()
+	q.Set("api-version", version)
+	req.URL.RawQuery = q.Encode()
+}
@@ -0,0 +1,48 @@
+package azopenai
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var (
+	// ErrMissingCredential indicates that neither an API key nor a token
+	// credential has been configured.
+	ErrMissingCredential = errors.New("either APIKey or TokenCredential is required")
+	// ErrAmbiguousCredential indicates that both an API key and a token
+	// credential have been configured.
+	ErrAmbiguousCredential = errors.New("APIKey and TokenCredential are mutually exclusive")
+	// ErrMissingBaseURL indicates that no endpoint has been configured.
+	ErrMissingBaseURL = errors.New("BaseURL is required")
+)
+
+// ConfigError reports an invalid AzureOpenAI configuration setting.
+type ConfigError struct {
+	// Field is the name of the offending AzureOpenAI field.
+	Field string
+	// Err describes the problem.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("azopenai: invalid %s: %v", e.Field, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func validateBaseURL(baseURL string) error {
+	if baseURL == "" {
+		return ErrMissingBaseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http(s) URL", baseURL)
+	}
+	return nil
+}
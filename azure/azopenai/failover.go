@@ -0,0 +1,287 @@
+package azopenai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/openai/openai-go/option"
+)
+
+// defaultCooldown is how long an endpoint is skipped after it failed without
+// sending a Retry-After header.
+const defaultCooldown = 10 * time.Second
+
+// Endpoint is an additional Azure OpenAI resource, e.g. in another region,
+// that serves the same models as the plugin's primary endpoint. Exactly one of
+// APIKey and TokenCredential must be set.
+type Endpoint struct {
+	BaseURL         string
+	APIKey          string
+	TokenCredential azcore.TokenCredential
+	// Deployments maps model and embedder names to the names of their
+	// deployments on this endpoint, if they differ from the primary
+	// endpoint's. Names without a mapping use the primary endpoint's
+	// deployment.
+	Deployments map[string]string
+	// Priority orders endpoints if Balancing is BalancePriority. Endpoints
+	// with lower values are tried first. The primary endpoint has priority 0.
+	Priority int
+}
+
+// Balancing selects how requests are distributed across endpoints.
+type Balancing int
+
+const (
+	// BalancePriority sends requests to the available endpoint with the
+	// lowest Priority, and fails over to the next one.
+	BalancePriority Balancing = iota
+	// BalanceRoundRobin distributes requests evenly across all available
+	// endpoints.
+	BalanceRoundRobin
+)
+
+// WithEndpoints adds endpoints that requests fail over to if the primary
+// endpoint is throttled or unavailable.
+func WithEndpoints(endpoints ...Endpoint) Option {
+	return func(a *AzureOpenAI) {
+		a.Endpoints = append(a.Endpoints, endpoints...)
+	}
+}
+
+// WithBalancing selects how requests are distributed across endpoints.
+func WithBalancing(b Balancing) Option {
+	return func(a *AzureOpenAI) {
+		a.Balancing = b
+	}
+}
+
+// endpoint is an Endpoint resolved for use by the plugin.
+type endpoint struct {
+	Endpoint
+	mode APIMode
+	// base is the base URL used by the OpenAI SDK client
+	base *url.URL
+	auth *authenticator
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+type endpointKey struct{}
+
+// endpointFor returns the endpoint selected for req.
+func (a *AzureOpenAI) endpointFor(req *http.Request) *endpoint {
+	if ep, ok := req.Context().Value(endpointKey{}).(*endpoint); ok {
+		return ep
+	}
+	return a.endpoints[0]
+}
+
+func (a *AzureOpenAI) newEndpoint(e Endpoint) (*endpoint, error) {
+	mode, baseURL, err := a.resolveBaseURL(e.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if mode == APIModeDeployment {
+		if baseURL, err = url.JoinPath(baseURL, "openai"); err != nil {
+			return nil, err
+		}
+	}
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint{
+		Endpoint: e,
+		mode:     mode,
+		base:     base,
+		auth:     newAuthenticator(e.APIKey, e.TokenCredential, mode, a.TokenRefreshBefore),
+	}, nil
+}
+
+// initEndpoints resolves the primary endpoint and all additional Endpoints.
+func (a *AzureOpenAI) initEndpoints() error {
+	primary, err := a.newEndpoint(Endpoint{
+		BaseURL:         a.BaseURL,
+		APIKey:          a.APIKey,
+		TokenCredential: a.TokenCredential,
+	})
+	if err != nil {
+		return &ConfigError{Field: "BaseURL", Err: err}
+	}
+	a.endpoints = []*endpoint{primary}
+	for i, e := range a.Endpoints {
+		ep, err := a.newEndpoint(e)
+		if err != nil {
+			return &ConfigError{Field: fmt.Sprintf("Endpoints[%d]", i), Err: err}
+		}
+		a.endpoints = append(a.endpoints, ep)
+	}
+	return nil
+}
+
+func (a *AzureOpenAI) validateEndpoints() []error {
+	if len(a.Endpoints) == 0 {
+		return nil
+	}
+	mode, _, err := a.resolveMode()
+	if err != nil {
+		return nil
+	}
+	var errs []error
+	for i, e := range a.Endpoints {
+		field := fmt.Sprintf("Endpoints[%d]", i)
+		switch {
+		case e.APIKey == "" && e.TokenCredential == nil:
+			errs = append(errs, &ConfigError{Field: field, Err: ErrMissingCredential})
+		case e.APIKey != "" && e.TokenCredential != nil:
+			errs = append(errs, &ConfigError{Field: field, Err: ErrAmbiguousCredential})
+		}
+		if err := validateBaseURL(e.BaseURL); err != nil {
+			errs = append(errs, &ConfigError{Field: field, Err: err})
+			continue
+		}
+		if m, _, err := a.resolveBaseURL(e.BaseURL); err != nil {
+			errs = append(errs, &ConfigError{Field: field, Err: err})
+		} else if m != mode {
+			errs = append(errs, &ConfigError{Field: field, Err: fmt.Errorf("uses the %v API, but the primary endpoint uses the %v API", m, mode)})
+		}
+	}
+	if a.Balancing != BalancePriority && a.Balancing != BalanceRoundRobin {
+		errs = append(errs, &ConfigError{Field: "Balancing", Err: fmt.Errorf("unknown balancing %d", a.Balancing)})
+	}
+	return errs
+}
+
+// order returns the endpoints in the order they should be tried. Endpoints
+// that are cooling down are moved to the end.
+func (a *AzureOpenAI) order(now time.Time) []*endpoint {
+	eps := slices.Clone(a.endpoints)
+	switch a.Balancing {
+	case BalanceRoundRobin:
+		n := int(a.next.Add(1)-1) % len(eps)
+		eps = append(eps[n:], eps[:n]...)
+	default:
+		slices.SortStableFunc(eps, func(x, y *endpoint) int {
+			return x.Priority - y.Priority
+		})
+	}
+	slices.SortStableFunc(eps, func(x, y *endpoint) int {
+		xb, yb := x.blocked(now), y.blocked(now)
+		switch {
+		case xb == yb:
+			return 0
+		case xb:
+			return 1
+		default:
+			return -1
+		}
+	})
+	return eps
+}
+
+func (ep *endpoint) blocked(now time.Time) bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return now.Before(ep.blockedUntil)
+}
+
+func (ep *endpoint) block(d time.Duration) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(ep.blockedUntil) {
+		ep.blockedUntil = until
+	}
+}
+
+// failover returns middleware that sends each request to the endpoints in
+// the order given by Balancing. If an endpoint is throttled or fails with a
+// server error, the request is retried with the next endpoint, and the failed
+// endpoint is skipped until its Retry-After period has passed. If all
+// endpoints fail, the last response is returned so that the OpenAI SDK's
+// retry logic applies.
+func (a *AzureOpenAI) failover() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		eps := a.order(time.Now())
+		var res *http.Response
+		var err error
+		for i, ep := range eps {
+			r, rerr := ep.rewrite(req, a.endpoints[0].base, i > 0)
+			if rerr != nil {
+				return nil, rerr
+			}
+			res, err = next(r)
+			if ctxErr := req.Context().Err(); ctxErr != nil {
+				return res, err
+			}
+			if err == nil && !shouldFailover(res.StatusCode) {
+				return res, nil
+			}
+			if rle := (*RateLimitError)(nil); errors.As(err, &rle) {
+				ep.block(rle.Wait)
+			} else {
+				ep.block(retryAfter(res))
+			}
+			if i < len(eps)-1 && res != nil {
+				// Discard the failed response before trying the next endpoint
+				io.Copy(io.Discard, res.Body)
+				res.Body.Close()
+			}
+		}
+		return res, err
+	})
+}
+
+// rewrite returns a copy of req addressed to ep. primary is the base URL of
+// the OpenAI SDK client. If reset is set, the request body is recreated.
+func (ep *endpoint) rewrite(req *http.Request, primary *url.URL, reset bool) (*http.Request, error) {
+	r := req.Clone(context.WithValue(req.Context(), endpointKey{}, ep))
+	if reset && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	r.URL.Scheme = ep.base.Scheme
+	r.URL.Host = ep.base.Host
+	r.URL.Path = ep.base.Path + strings.TrimPrefix(req.URL.Path, primary.Path)
+	r.URL.RawPath = ""
+	r.Host = ""
+	return r, nil
+}
+
+func shouldFailover(status int) bool {
+	return status == http.StatusRequestTimeout ||
+		status == http.StatusTooManyRequests ||
+		status >= http.StatusInternalServerError
+}
+
+// retryAfter returns how long an endpoint should be skipped after res.
+func retryAfter(res *http.Response) time.Duration {
+	if res == nil {
+		return defaultCooldown
+	}
+	if ms, err := strconv.ParseFloat(res.Header.Get("Retry-After-Ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	ra := res.Header.Get("Retry-After")
+	if s, err := strconv.ParseFloat(ra, 64); err == nil && s >= 0 {
+		return time.Duration(s * float64(time.Second))
+	}
+	if t, err := http.ParseTime(ra); err == nil {
+		return time.Until(t)
+	}
+	return defaultCooldown
+}
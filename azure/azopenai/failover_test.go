@@ -0,0 +1,283 @@
+package azopenai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// respondWith returns a handler that fails with status and headers.
+func respondWith(status int, headers ...string) func(http.ResponseWriter, *http.Request, map[string]any) {
+	return func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		http.Error(w, `{"error":{"code":"failed","message":"failed"}}`, status)
+	}
+}
+
+func chat(t *testing.T, c openai.Client, model string) error {
+	t.Helper()
+	_, err := c.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    model,
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+	})
+	return err
+}
+
+// counts returns the number of requests each server received.
+func counts(srvs ...*fakeServer) []int {
+	n := make([]int, len(srvs))
+	for i, s := range srvs {
+		n[i] = len(s.requests())
+	}
+	return n
+}
+
+func TestFailoverPriority(t *testing.T) {
+	primary, second, third := newFakeServer(t), newFakeServer(t), newFakeServer(t)
+	a := newTestPlugin(t, primary.URL+"/openai/v1",
+		WithAPIKey("primary-key"),
+		WithEndpoints(
+			Endpoint{BaseURL: third.URL + "/openai/v1", APIKey: "third-key", Priority: 2},
+			Endpoint{BaseURL: second.URL + "/openai/v1", APIKey: "second-key", Priority: 1},
+		),
+	)
+	c := testClient(a)
+
+	if err := chat(t, c, "gpt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := counts(primary, second, third); !slices.Equal(got, []int{1, 0, 0}) {
+		t.Fatalf("got requests %v, want the primary endpoint only", got)
+	}
+
+	// The primary endpoint is throttled, the request fails over by priority
+	primary.setHandler(respondWith(http.StatusTooManyRequests, "Retry-After-Ms", "60000"))
+	if err := chat(t, c, "gpt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := counts(primary, second, third); !slices.Equal(got, []int{2, 1, 0}) {
+		t.Fatalf("got requests %v, want a failover to the second endpoint", got)
+	}
+	req := second.last(t)
+	if req.Path != "/openai/v1/chat/completions" || req.Header.Get("Authorization") != "Bearer second-key" {
+		t.Errorf("got %s with %q", req.Path, req.Header.Get("Authorization"))
+	}
+	// The request body has been replayed
+	if msgs, _ := req.Body["messages"].([]any); req.Body["model"] != "gpt" || len(msgs) != 1 {
+		t.Errorf("got body %v", req.Body)
+	}
+
+	// The primary endpoint is skipped while it cools down
+	if err := chat(t, c, "gpt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := counts(primary, second, third); !slices.Equal(got, []int{2, 2, 0}) {
+		t.Fatalf("got requests %v, want the second endpoint only", got)
+	}
+}
+
+func TestFailoverRoundRobin(t *testing.T) {
+	srvs := []*fakeServer{newFakeServer(t), newFakeServer(t), newFakeServer(t)}
+	a := newTestPlugin(t, srvs[0].URL+"/openai/v1",
+		WithAPIKey("key"),
+		WithEndpoints(
+			Endpoint{BaseURL: srvs[1].URL + "/openai/v1", APIKey: "key"},
+			Endpoint{BaseURL: srvs[2].URL + "/openai/v1", APIKey: "key"},
+		),
+		WithBalancing(BalanceRoundRobin),
+	)
+	c := testClient(a)
+	want := [][]int{{1, 0, 0}, {1, 1, 0}, {1, 1, 1}, {2, 1, 1}}
+	for i, w := range want {
+		if err := chat(t, c, "gpt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := counts(srvs...); !slices.Equal(got, w) {
+			t.Fatalf("%d: got requests %v, want %v", i, got, w)
+		}
+	}
+
+	// A failing endpoint is skipped until its cooldown has passed
+	srvs[1].setHandler(respondWith(http.StatusServiceUnavailable))
+	for range 3 {
+		if err := chat(t, c, "gpt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := counts(srvs...); !slices.Equal(got, []int{3, 2, 3}) {
+		t.Fatalf("got requests %v, want %v", got, []int{3, 2, 3})
+	}
+	now := time.Now()
+	ep := a.endpoints[1]
+	if !ep.blocked(now.Add(defaultCooldown-time.Second)) || ep.blocked(now.Add(defaultCooldown)) {
+		t.Error("failed endpoint is not blocked for the default cooldown")
+	}
+}
+
+func TestFailoverDeployments(t *testing.T) {
+	primary, secondary := newFakeServer(t), newFakeServer(t)
+	a := newTestPlugin(t, primary.URL,
+		WithAPIKey("primary-key"),
+		WithModel("chat", Deployment{Name: "gpt-east"}),
+		WithEmbedder("embed", Deployment{Name: "embed-east"}),
+		WithEmbedder("small", Deployment{Name: "small-east"}),
+		WithEndpoints(Endpoint{
+			BaseURL:     secondary.URL,
+			APIKey:      "secondary-key",
+			Deployments: map[string]string{"chat": "gpt-west", "embed": "embed-west"},
+		}),
+	)
+	primary.setHandler(respondWith(http.StatusInternalServerError))
+	c := testClient(a)
+	if err := chat(t, c, "chat"); err != nil {
+		t.Fatal(err)
+	}
+
+	if req := primary.last(t); req.Path != "/openai/deployments/gpt-east/chat/completions" {
+		t.Errorf("primary got path %q", req.Path)
+	}
+	req := secondary.last(t)
+	if req.Path != "/openai/deployments/gpt-west/chat/completions" {
+		t.Errorf("secondary got path %q", req.Path)
+	}
+	if req.Query.Get("api-version") != defaultAPIVersion || req.Header.Get("api-key") != "secondary-key" {
+		t.Errorf("secondary got api-version %q and api-key %q", req.Query.Get("api-version"), req.Header.Get("api-key"))
+	}
+	if msgs, _ := req.Body["messages"].([]any); len(msgs) != 1 {
+		t.Errorf("got body %v", req.Body)
+	}
+
+	// Embedders use their own deployment on the secondary endpoint, or the
+	// primary endpoint's deployment name if they have no mapping
+	for embedder, want := range map[string]string{"embed": "embed-west", "small": "small-east"} {
+		_, err := c.Embeddings.New(context.Background(), openai.EmbeddingNewParams{
+			Model: embedder,
+			Input: openai.EmbeddingNewParamsInputUnion{OfString: openai.String("Hi")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := secondary.last(t).Path; got != "/openai/deployments/"+want+"/embeddings" {
+			t.Errorf("%s: secondary got path %q, want deployment %s", embedder, got, want)
+		}
+	}
+}
+
+func TestFailoverAllEndpointsFail(t *testing.T) {
+	primary, secondary := newFakeServer(t), newFakeServer(t)
+	primary.setHandler(respondWith(http.StatusTooManyRequests, "Retry-After", "30"))
+	secondary.setHandler(respondWith(http.StatusBadGateway))
+	a := newTestPlugin(t, primary.URL+"/openai/v1",
+		WithAPIKey("key"),
+		WithEndpoints(Endpoint{BaseURL: secondary.URL + "/openai/v1", APIKey: "key"}),
+	)
+	err := chat(t, testClient(a), "gpt")
+	// The last endpoint's response is returned
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got error %v, want status 502", err)
+	}
+	if got := counts(primary, secondary); !slices.Equal(got, []int{1, 1}) {
+		t.Errorf("got requests %v, want one per endpoint", got)
+	}
+	now := time.Now()
+	if p := a.endpoints[0]; !p.blocked(now.Add(29*time.Second)) || p.blocked(now.Add(31*time.Second)) {
+		t.Error("primary endpoint is not blocked for its Retry-After period")
+	}
+}
+
+func TestFailoverOnRateLimit(t *testing.T) {
+	primary, secondary := newFakeServer(t), newFakeServer(t)
+	a := newTestPlugin(t, primary.URL+"/openai/v1",
+		WithAPIKey("key"),
+		WithRateLimit(RateLimit{RequestsPerMinute: 1}),
+		WithEndpoints(Endpoint{BaseURL: secondary.URL + "/openai/v1", APIKey: "key"}),
+	)
+	c := testClient(a)
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+			Model:    "gpt",
+			Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+		})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The primary endpoint's limit is exhausted by the first request
+	if got := counts(primary, secondary); !slices.Equal(got, []int{1, 1}) {
+		t.Errorf("got requests %v, want one per endpoint", got)
+	}
+	if !a.endpoints[0].blocked(time.Now().Add(50 * time.Second)) {
+		t.Error("rate-limited endpoint is not blocked until its limit allows a request")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{name: "none", want: defaultCooldown},
+		{name: "milliseconds", headers: map[string]string{"Retry-After-Ms": "1500"}, want: 1500 * time.Millisecond},
+		{name: "seconds", headers: map[string]string{"Retry-After": "2"}, want: 2 * time.Second},
+		{name: "milliseconds first", headers: map[string]string{"Retry-After-Ms": "250", "Retry-After": "1"}, want: 250 * time.Millisecond},
+		{name: "date", headers: map[string]string{"Retry-After": time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}, want: time.Minute},
+		{name: "invalid", headers: map[string]string{"Retry-After": "soon"}, want: defaultCooldown},
+		{name: "negative", headers: map[string]string{"Retry-After-Ms": "-1"}, want: defaultCooldown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{Header: make(http.Header)}
+			for k, v := range tt.headers {
+				res.Header.Set(k, v)
+			}
+			// Dates have a resolution of a second
+			if got := retryAfter(res); got > tt.want || got < tt.want-time.Second {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := retryAfter(nil); got != defaultCooldown {
+		t.Errorf("got %v without response, want %v", got, defaultCooldown)
+	}
+}
+
+func TestRewriteReplaysBody(t *testing.T) {
+	a := newTestPlugin(t, "https://primary.example.com/openai/v1",
+		WithAPIKey("key"),
+		WithEndpoints(Endpoint{BaseURL: "https://secondary.example.com/gateway/openai/v1", APIKey: "key"}),
+	)
+	req, err := http.NewRequest(http.MethodPost, "https://primary.example.com/openai/v1/chat/completions?x=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(`{"model":"gpt"}`)), nil
+	}
+	r, err := a.endpoints[1].rewrite(req, a.endpoints[0].base, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.URL.String(); got != "https://secondary.example.com/gateway/openai/v1/chat/completions?x=1" {
+		t.Errorf("got URL %s", got)
+	}
+	b, _ := io.ReadAll(r.Body)
+	if string(b) != `{"model":"gpt"}` {
+		t.Errorf("got body %q", b)
+	}
+	if a.endpointFor(r) != a.endpoints[1] || a.endpointFor(req) != a.endpoints[0] {
+		t.Error("rewritten request is not associated with its endpoint")
+	}
+}
@@ -0,0 +1,191 @@
+package azopenai
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+const (
+	defaultMaxBodySize = 4096
+	redacted           = "REDACTED"
+)
+
+// sensitiveHeaders are always redacted from logged requests.
+var sensitiveHeaders = []string{"Api-Key", "Authorization", "Ocp-Apim-Subscription-Key"}
+
+// LogOptions configures logging of HTTP requests sent to Azure OpenAI.
+type LogOptions struct {
+	// Logger receives the log records. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Level is the level of successful requests. Failed requests are logged
+	// at slog.LevelWarn or higher.
+	Level slog.Level
+	// Bodies enables logging of request headers and bodies, and of response
+	// bodies. Credentials are redacted from headers.
+	Bodies bool
+	// MaxBodySize limits the number of body bytes logged. Defaults to 4096.
+	MaxBodySize int
+	// RedactFields lists JSON attribute names whose values are redacted from
+	// logged bodies, e.g. "messages" or "input".
+	RedactFields []string
+}
+
+// WithLogging logs every HTTP request sent to Azure OpenAI as a structured
+// log record with method, URL, status, latency, token usage and request ID.
+func WithLogging(opts LogOptions) Option {
+	return func(a *AzureOpenAI) {
+		a.Logging = &opts
+	}
+}
+
+// usage is the token usage reported by the Chat Completions, Embeddings and
+// Responses APIs.
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// InputTokens and OutputTokens are reported by the Responses API
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// logRequests returns middleware that logs requests according to opts.
+func logRequests(opts LogOptions) option.RequestOption {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultMaxBodySize
+	}
+	redact := make(map[string]bool, len(opts.RedactFields))
+	for _, f := range opts.RedactFields {
+		redact[strings.ToLower(f)] = true
+	}
+
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		ctx := req.Context()
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("url", req.URL.Redacted()),
+		}
+		if opts.Bodies {
+			attrs = append(attrs, slog.Any("headers", redactHeaders(req.Header)))
+			if req.GetBody != nil {
+				if body, err := req.GetBody(); err == nil {
+					b, _ := io.ReadAll(body)
+					body.Close()
+					attrs = append(attrs, slog.String("request_body", redactBody(b, redact, opts.MaxBodySize)))
+				}
+			}
+		}
+
+		start := time.Now()
+		res, err := next(req)
+		attrs = append(attrs, slog.Duration("latency", time.Since(start)))
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+			logger.LogAttrs(ctx, max(opts.Level, slog.LevelError), "Azure OpenAI request failed", attrs...)
+			return res, err
+		}
+
+		attrs = append(attrs, slog.Int("status", res.StatusCode))
+		if id := firstHeader(res.Header, "x-request-id", "apim-request-id"); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if isJSON(res.Header.Get("Content-Type")) {
+			b, rerr := io.ReadAll(res.Body)
+			res.Body.Close()
+			res.Body = io.NopCloser(bytes.NewReader(b))
+			if rerr != nil {
+				return nil, rerr
+			}
+			var body struct {
+				Usage *usage `json:"usage"`
+			}
+			if json.Unmarshal(b, &body) == nil && body.Usage != nil {
+				attrs = append(attrs, slog.Group("usage",
+					slog.Int("prompt_tokens", max(body.Usage.PromptTokens, body.Usage.InputTokens)),
+					slog.Int("completion_tokens", max(body.Usage.CompletionTokens, body.Usage.OutputTokens)),
+					slog.Int("total_tokens", body.Usage.TotalTokens)))
+			}
+			if opts.Bodies {
+				attrs = append(attrs, slog.String("response_body", redactBody(b, redact, opts.MaxBodySize)))
+			}
+		}
+
+		level := opts.Level
+		if res.StatusCode >= http.StatusBadRequest {
+			level = max(level, slog.LevelWarn)
+		}
+		logger.LogAttrs(ctx, level, "Azure OpenAI request", attrs...)
+		return res, nil
+	})
+}
+
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range sensitiveHeaders {
+		if h.Get(k) != "" {
+			h.Set(k, redacted)
+		}
+	}
+	return h
+}
+
+// redactBody replaces the values of all JSON attributes in fields and
+// truncates the result to limit bytes. Bodies that are not valid JSON are
+// omitted if any fields are to be redacted.
+func redactBody(b []byte, fields map[string]bool, limit int) string {
+	if len(fields) > 0 {
+		var v any
+		if err := json.Unmarshal(b, &v); err != nil {
+			return "<omitted: not JSON>"
+		}
+		b, _ = json.Marshal(redactValue(v, fields))
+	}
+	if len(b) > limit {
+		return string(b[:limit]) + "...<truncated>"
+	}
+	return string(b)
+}
+
+func redactValue(v any, fields map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if fields[strings.ToLower(k)] {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactValue(e, fields)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redactValue(e, fields)
+		}
+	}
+	return v
+}
+
+func firstHeader(h http.Header, keys ...string) string {
+	for _, k := range keys {
+		if v := h.Get(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == "application/json"
+}
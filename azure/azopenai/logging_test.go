@@ -0,0 +1,258 @@
+package azopenai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// logBuffer collects the JSON log records of a slog.Logger.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// records returns the decoded log records.
+func (b *logBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	var recs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log record %q: %v", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// newLoggingPlugin returns a plugin for srv that logs to the returned buffer.
+// It uses the deployment-based API, which sends the API key in the "api-key"
+// header.
+func newLoggingPlugin(t *testing.T, srv *fakeServer, opts LogOptions) (*AzureOpenAI, *logBuffer) {
+	t.Helper()
+	logs := &logBuffer{}
+	opts.Logger = slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return newTestPlugin(t, srv.URL, WithAPIMode(APIModeDeployment), WithAPIKey("secret-api-key"), WithLogging(opts)), logs
+}
+
+func TestLogRequests(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		w.Header().Set("x-request-id", "req-42")
+		writeJSON(w, chatCompletion("secret answer"))
+	})
+	a, logs := newLoggingPlugin(t, srv, LogOptions{Level: slog.LevelInfo, Bodies: true, RedactFields: []string{"Content"}})
+
+	c := testClient(a)
+	_, err := c.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+		Model:    "gpt",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("secret prompt")},
+	}, option.WithHeader("Ocp-Apim-Subscription-Key", "secret-subscription-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The credentials have been sent, but aren't logged
+	if got := srv.last(t).Header.Get("Api-Key"); got != "secret-api-key" {
+		t.Fatalf("got api-key %q", got)
+	}
+	out := logs.String()
+	for _, secret := range []string{"secret-api-key", "secret-subscription-key", "secret prompt", "secret answer"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains %q:\n%s", secret, out)
+		}
+	}
+
+	recs := logs.records(t)
+	if len(recs) != 1 {
+		t.Fatalf("got %d log records, want 1", len(recs))
+	}
+	rec := recs[0]
+	if rec["level"] != "INFO" || rec["method"] != "POST" || rec["status"] != float64(200) || rec["request_id"] != "req-42" {
+		t.Errorf("got record %v", rec)
+	}
+	if _, ok := rec["latency"]; !ok {
+		t.Error("latency isn't logged")
+	}
+	wantUsage := map[string]any{"prompt_tokens": float64(5), "completion_tokens": float64(2), "total_tokens": float64(7)}
+	if usage, _ := rec["usage"].(map[string]any); !maps.Equal(usage, wantUsage) {
+		t.Errorf("got usage %v, want %v", rec["usage"], wantUsage)
+	}
+	checkRedactedHeaders(t, rec, "Api-Key", "Ocp-Apim-Subscription-Key")
+	// Nested fields are redacted, other fields are kept
+	var reqBody struct {
+		Messages []map[string]any
+	}
+	if err := json.Unmarshal([]byte(rec["request_body"].(string)), &reqBody); err != nil {
+		t.Fatal(err)
+	}
+	if len(reqBody.Messages) != 1 || reqBody.Messages[0]["content"] != redacted || reqBody.Messages[0]["role"] != "user" {
+		t.Errorf("got request body %s", rec["request_body"])
+	}
+	if body, _ := rec["response_body"].(string); !strings.Contains(body, `"content":"REDACTED"`) || !strings.Contains(body, `"total_tokens":7`) {
+		t.Errorf("got response body %s", body)
+	}
+}
+
+// checkRedactedHeaders checks that the headers of rec are redacted.
+func checkRedactedHeaders(t *testing.T, rec map[string]any, keys ...string) {
+	t.Helper()
+	headers, _ := rec["headers"].(map[string]any)
+	for _, k := range keys {
+		if got, _ := headers[k].([]any); len(got) != 1 || got[0] != redacted {
+			t.Errorf("got header %s %v, want %s", k, headers[k], redacted)
+		}
+	}
+}
+
+func TestLogRequestsRedactsHeaders(t *testing.T) {
+	srv := newFakeServer(t)
+	logs := &logBuffer{}
+	// A client that sends all sensitive headers at once, which the plugin's
+	// own authentication never does
+	c := openai.NewClient(
+		option.WithBaseURL(srv.URL),
+		option.WithHeader("Api-Key", "secret-api-key"),
+		option.WithHeader("Authorization", "Bearer secret-token"),
+		option.WithHeader("Ocp-Apim-Subscription-Key", "secret-subscription-key"),
+		option.WithMaxRetries(0),
+		logRequests(LogOptions{Logger: slog.New(slog.NewJSONHandler(logs, nil)), Bodies: true}),
+	)
+	if err := chat(t, c, "gpt"); err != nil {
+		t.Fatal(err)
+	}
+	out := logs.String()
+	for _, secret := range []string{"secret-api-key", "secret-token", "secret-subscription-key"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains %q:\n%s", secret, out)
+		}
+	}
+	checkRedactedHeaders(t, logs.records(t)[0], sensitiveHeaders...)
+}
+
+func TestLogRequestsWithoutBodies(t *testing.T) {
+	srv := newFakeServer(t)
+	a, logs := newLoggingPlugin(t, srv, LogOptions{})
+	if err := chat(t, testClient(a), "gpt"); err != nil {
+		t.Fatal(err)
+	}
+	rec := logs.records(t)[0]
+	for _, k := range []string{"headers", "request_body", "response_body"} {
+		if _, ok := rec[k]; ok {
+			t.Errorf("%s is logged without Bodies", k)
+		}
+	}
+	if _, ok := rec["usage"]; !ok {
+		t.Error("usage isn't logged without Bodies")
+	}
+}
+
+func TestLogRequestsFailed(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		w.Header().Set("apim-request-id", "apim-7")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+	})
+	a, logs := newLoggingPlugin(t, srv, LogOptions{Level: slog.LevelDebug, Bodies: true})
+	if err := chat(t, testClient(a), "gpt"); err == nil {
+		t.Fatal("request succeeded")
+	}
+	rec := logs.records(t)[0]
+	if rec["level"] != "WARN" || rec["status"] != float64(400) || rec["request_id"] != "apim-7" {
+		t.Errorf("got record %v", rec)
+	}
+	// Bodies that aren't JSON are neither parsed nor logged
+	if _, ok := rec["response_body"]; ok {
+		t.Errorf("got response body %v", rec["response_body"])
+	}
+	if _, ok := rec["usage"]; ok {
+		t.Errorf("got usage %v", rec["usage"])
+	}
+}
+
+func TestLogRequestsTruncatesBodies(t *testing.T) {
+	srv := newFakeServer(t)
+	a, logs := newLoggingPlugin(t, srv, LogOptions{Bodies: true, MaxBodySize: 16})
+	if err := chat(t, testClient(a), "gpt"); err != nil {
+		t.Fatal(err)
+	}
+	rec := logs.records(t)[0]
+	for _, k := range []string{"request_body", "response_body"} {
+		body, _ := rec[k].(string)
+		if !strings.HasSuffix(body, "...<truncated>") || len(body) != 16+len("...<truncated>") {
+			t.Errorf("got %s %q, want 16 bytes and a truncation marker", k, body)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	fields := map[string]bool{"input": true, "api_key": true}
+	tests := []struct {
+		name   string
+		body   string
+		fields map[string]bool
+		limit  int
+		want   string
+	}{
+		{
+			name:   "nested",
+			body:   `{"model":"m","input":["a"],"tools":[{"auth":{"API_KEY":"k","type":"key"}}]}`,
+			fields: fields,
+			limit:  defaultMaxBodySize,
+			want:   `{"input":"REDACTED","model":"m","tools":[{"auth":{"API_KEY":"REDACTED","type":"key"}}]}`,
+		},
+		{name: "not JSON", body: "input=a", fields: fields, limit: defaultMaxBodySize, want: "<omitted: not JSON>"},
+		{name: "not JSON without fields", body: "input=a", limit: defaultMaxBodySize, want: "input=a"},
+		{name: "limit", body: `{"input":"a"}`, fields: fields, limit: 10, want: `{"input":"...<truncated>`},
+		{name: "exactly limit", body: "0123456789", limit: 10, want: "0123456789"},
+		{name: "empty", body: "", limit: defaultMaxBodySize, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactBody([]byte(tt.body), tt.fields, tt.limit); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Api-Key", "k")
+	h.Set("Authorization", "Bearer t")
+	h.Set("Content-Type", "application/json")
+	got := redactHeaders(h)
+	if got.Get("Api-Key") != redacted || got.Get("Authorization") != redacted || got.Get("Content-Type") != "application/json" {
+		t.Errorf("got headers %v", got)
+	}
+	if _, ok := got["Ocp-Apim-Subscription-Key"]; ok {
+		t.Error("missing header has been added")
+	}
+	// The request's headers are unchanged
+	if h.Get("Api-Key") != "k" {
+		t.Error("redactHeaders modified its argument")
+	}
+}
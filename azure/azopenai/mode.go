@@ -0,0 +1,104 @@
+package azopenai
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultAPIVersion is the api-version used with the deployment-based API
+// unless overridden by AzureOpenAI.APIVersion or AZ_OPENAI_API_VERSION.
+const defaultAPIVersion = "2024-10-21"
+
+// APIMode selects how the plugin addresses Azure OpenAI.
+type APIMode int
+
+const (
+	// APIModeAuto selects the API from the shape of BaseURL. A BaseURL ending
+	// in "/openai/v1" selects the v1 API. Otherwise, the deployment-based API
+	// is used if any deployments have been configured, and the v1 API below
+	// the resource endpoint if not.
+	APIModeAuto APIMode = iota
+	// APIModeV1 uses the v1 API. BaseURL is used as is.
+	APIModeV1
+	// APIModeDeployment uses the deployment-based API. BaseURL must be the
+	// resource endpoint.
+	APIModeDeployment
+)
+
+func (m APIMode) String() string {
+	switch m {
+	case APIModeAuto:
+		return "auto"
+	case APIModeV1:
+		return "v1"
+	case APIModeDeployment:
+		return "deployment"
+	default:
+		return fmt.Sprintf("APIMode(%d)", int(m))
+	}
+}
+
+// WithAPIVersion sets the api-version query parameter sent with each request.
+func WithAPIVersion(version string) Option {
+	return func(a *AzureOpenAI) {
+		a.APIVersion = version
+	}
+}
+
+// WithAPIMode overrides the automatic detection of the API to use.
+func WithAPIMode(mode APIMode) Option {
+	return func(a *AzureOpenAI) {
+		a.Mode = mode
+	}
+}
+
+// apiVersion returns the configured api-version. An empty string means that
+// none has been configured explicitly.
+func (a *AzureOpenAI) apiVersion() string {
+	return cmp.Or(a.APIVersion, os.Getenv("AZ_OPENAI_API_VERSION"))
+}
+
+// resolveMode returns the effective API mode and base URL of the primary
+// endpoint.
+func (a *AzureOpenAI) resolveMode() (APIMode, string, error) {
+	return a.resolveBaseURL(a.BaseURL)
+}
+
+// resolveBaseURL returns the effective API mode and base URL for baseURL.
+func (a *AzureOpenAI) resolveBaseURL(baseURL string) (APIMode, string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return 0, "", err
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	isV1 := strings.HasSuffix(path, "/openai/v1")
+
+	switch a.Mode {
+	case APIModeV1:
+		return APIModeV1, baseURL, nil
+	case APIModeDeployment:
+		if isV1 {
+			return 0, "", fmt.Errorf("%q is a v1 API endpoint, use the resource endpoint instead", baseURL)
+		}
+		return APIModeDeployment, baseURL, nil
+	case APIModeAuto:
+	default:
+		return 0, "", fmt.Errorf("unknown API mode %v", a.Mode)
+	}
+
+	switch {
+	case isV1:
+		return APIModeV1, baseURL, nil
+	case a.Deployment != "" || a.hasDeployments():
+		return APIModeDeployment, baseURL, nil
+	case path == "":
+		// Resource endpoint without deployments
+		v1, err := url.JoinPath(baseURL, "openai", "v1")
+		return APIModeV1, v1, err
+	default:
+		return APIModeV1, baseURL, nil
+	}
+}
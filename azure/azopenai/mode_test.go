@@ -0,0 +1,215 @@
+package azopenai
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+func TestRouting(t *testing.T) {
+	tests := []struct {
+		name string
+		// path is appended to the fake server's URL to form BaseURL
+		path string
+		opts []Option
+		// env is the value of AZ_OPENAI_API_VERSION
+		env   string
+		model string
+
+		wantPath    string
+		wantVersion string
+		// wantModel is the model sent in the body, empty if none
+		wantModel  string
+		wantAPIKey string
+		wantAuth   string
+	}{
+		{
+			name:      "v1",
+			path:      "/openai/v1",
+			opts:      []Option{WithAPIKey("secret")},
+			model:     "gpt",
+			wantPath:  "/openai/v1/chat/completions",
+			wantModel: "gpt",
+			wantAuth:  "Bearer secret",
+		},
+		{
+			name:        "v1 with api-version",
+			path:        "/openai/v1",
+			opts:        []Option{WithAPIKey("secret"), WithAPIVersion("preview")},
+			model:       "gpt",
+			wantPath:    "/openai/v1/chat/completions",
+			wantVersion: "preview",
+			wantModel:   "gpt",
+			wantAuth:    "Bearer secret",
+		},
+		{
+			name:        "v1 with api-version from environment",
+			path:        "/openai/v1",
+			opts:        []Option{WithAPIKey("secret")},
+			env:         "preview",
+			model:       "gpt",
+			wantPath:    "/openai/v1/chat/completions",
+			wantVersion: "preview",
+			wantModel:   "gpt",
+			wantAuth:    "Bearer secret",
+		},
+		{
+			name:      "v1 below resource endpoint",
+			opts:      []Option{WithAPIKey("secret")},
+			model:     "gpt",
+			wantPath:  "/openai/v1/chat/completions",
+			wantModel: "gpt",
+			wantAuth:  "Bearer secret",
+		},
+		{
+			name:      "v1 maps model to deployment",
+			path:      "/openai/v1",
+			opts:      []Option{WithAPIKey("secret"), WithModel("chat", Deployment{Name: "gpt-dep"})},
+			model:     "chat",
+			wantPath:  "/openai/v1/chat/completions",
+			wantModel: "gpt-dep",
+			wantAuth:  "Bearer secret",
+		},
+		{
+			name:      "v1 with token credential",
+			path:      "/openai/v1",
+			opts:      []Option{WithTokenCredential(&fakeCredential{})},
+			model:     "gpt",
+			wantPath:  "/openai/v1/chat/completions",
+			wantModel: "gpt",
+			wantAuth:  "Bearer token-1",
+		},
+		{
+			name:        "deployment",
+			opts:        []Option{WithAPIKey("secret"), WithModel("chat", Deployment{Name: "gpt-dep"})},
+			model:       "chat",
+			wantPath:    "/openai/deployments/gpt-dep/chat/completions",
+			wantVersion: defaultAPIVersion,
+			wantAPIKey:  "secret",
+		},
+		{
+			name:        "deployment with api-version from environment",
+			opts:        []Option{WithAPIKey("secret"), WithModel("chat", Deployment{Name: "gpt-dep"})},
+			env:         "2025-01-01-preview",
+			model:       "chat",
+			wantPath:    "/openai/deployments/gpt-dep/chat/completions",
+			wantVersion: "2025-01-01-preview",
+			wantAPIKey:  "secret",
+		},
+		{
+			name:        "deployment overrides api-version",
+			opts:        []Option{WithAPIKey("secret"), WithAPIVersion("2024-06-01"), WithModel("chat", Deployment{Name: "gpt-dep", APIVersion: "2025-01-01-preview"})},
+			env:         "2024-02-01",
+			model:       "chat",
+			wantPath:    "/openai/deployments/gpt-dep/chat/completions",
+			wantVersion: "2025-01-01-preview",
+			wantAPIKey:  "secret",
+		},
+		{
+			name:        "default deployment",
+			opts:        []Option{WithAPIKey("secret"), WithDeployment("gpt-dep")},
+			model:       "gpt",
+			wantPath:    "/openai/deployments/gpt-dep/chat/completions",
+			wantVersion: defaultAPIVersion,
+			wantAPIKey:  "secret",
+		},
+		{
+			name:        "deployment name with space",
+			opts:        []Option{WithAPIKey("secret"), WithDeployment("gpt dep")},
+			model:       "gpt",
+			wantPath:    "/openai/deployments/gpt dep/chat/completions",
+			wantVersion: defaultAPIVersion,
+			wantAPIKey:  "secret",
+		},
+		{
+			name:        "deployment with token credential",
+			opts:        []Option{WithTokenCredential(&fakeCredential{}), WithDeployment("gpt-dep")},
+			model:       "gpt",
+			wantPath:    "/openai/deployments/gpt-dep/chat/completions",
+			wantVersion: defaultAPIVersion,
+			wantAuth:    "Bearer token-1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			a := newTestPlugin(t, srv.URL+tt.path, tt.opts...)
+			// The environment is read when the client is set up, and per request
+			t.Setenv("AZ_OPENAI_API_VERSION", tt.env)
+			a.init()
+			c := testClient(a)
+			_, err := c.Chat.Completions.New(context.Background(), openai.ChatCompletionNewParams{
+				Model:    tt.model,
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := srv.last(t)
+			if req.Path != tt.wantPath {
+				t.Errorf("got path %q, want %q", req.Path, tt.wantPath)
+			}
+			if got := req.Query.Get("api-version"); got != tt.wantVersion {
+				t.Errorf("got api-version %q, want %q", got, tt.wantVersion)
+			}
+			if got, _ := req.Body["model"].(string); got != tt.wantModel {
+				t.Errorf("got model %q, want %q", got, tt.wantModel)
+			}
+			if got := req.Header.Get("api-key"); got != tt.wantAPIKey {
+				t.Errorf("got api-key %q, want %q", got, tt.wantAPIKey)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("got Authorization %q, want %q", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestRoutingWithoutModel(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeJSON(w, map[string]any{"id": "file-1", "object": "file", "bytes": 1, "created_at": 1, "filename": "f", "purpose": "batch", "status": "processed"})
+	})
+	a := newTestPlugin(t, srv.URL, WithAPIKey("secret"), WithDeployment("gpt-dep"))
+	c := testClient(a)
+	if _, err := c.Files.Get(context.Background(), "file-1"); err != nil {
+		t.Fatal(err)
+	}
+	// Requests that are not sent to a deployment keep their path
+	req := srv.last(t)
+	if req.Path != "/openai/files/file-1" || req.Query.Get("api-version") != defaultAPIVersion {
+		t.Errorf("got %s?%s", req.Path, req.Query.Encode())
+	}
+}
+
+func TestResolveBaseURL(t *testing.T) {
+	tests := []struct {
+		baseURL    string
+		mode       APIMode
+		deployment string
+		wantMode   APIMode
+		wantURL    string
+	}{
+		{baseURL: "https://res.openai.azure.com/openai/v1", wantMode: APIModeV1, wantURL: "https://res.openai.azure.com/openai/v1"},
+		{baseURL: "https://res.openai.azure.com/openai/v1/", wantMode: APIModeV1, wantURL: "https://res.openai.azure.com/openai/v1/"},
+		{baseURL: "https://res.openai.azure.com", wantMode: APIModeV1, wantURL: "https://res.openai.azure.com/openai/v1"},
+		{baseURL: "https://res.openai.azure.com", deployment: "gpt", wantMode: APIModeDeployment, wantURL: "https://res.openai.azure.com"},
+		{baseURL: "https://gateway.example.com/aoai", wantMode: APIModeV1, wantURL: "https://gateway.example.com/aoai"},
+		{baseURL: "https://gateway.example.com/aoai", mode: APIModeDeployment, wantMode: APIModeDeployment, wantURL: "https://gateway.example.com/aoai"},
+		{baseURL: "https://res.openai.azure.com", mode: APIModeV1, deployment: "gpt", wantMode: APIModeV1, wantURL: "https://res.openai.azure.com"},
+	}
+	for _, tt := range tests {
+		a := &AzureOpenAI{Mode: tt.mode, Deployment: tt.deployment}
+		mode, u, err := a.resolveBaseURL(tt.baseURL)
+		if err != nil {
+			t.Errorf("%s (%v): %v", tt.baseURL, tt.mode, err)
+			continue
+		}
+		if mode != tt.wantMode || u != tt.wantURL {
+			t.Errorf("%s (%v) = %v, %s, want %v, %s", tt.baseURL, tt.mode, mode, u, tt.wantMode, tt.wantURL)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package azopenai
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+)
+
+// provider is the namespace of all models and embedders. The plugin registers
+// its actions under the name of the OpenAI plugin it builds on.
+const provider = "openai"
+
+func providerName(name string) string {
+	return api.NewName(provider, name)
+}
+
+// DefineModel defines a model that is sent to the deployment registered for
+// id, see lookupDeployment.
+func (a *AzureOpenAI) DefineModel(id string, opts ai.ModelOptions) ai.Model {
+	return a.wrapModel(a.OpenAI.DefineModel(id, opts), opts)
+}
+
+// ResolveAction implements api.DynamicPlugin.
+func (a *AzureOpenAI) ResolveAction(atype api.ActionType, name string) api.Action {
+	action := a.OpenAI.ResolveAction(atype, name)
+	if m, ok := action.(ai.Model); ok && atype == api.ActionTypeModel {
+		return a.wrapModel(m, modelOptions(action.Desc())).(api.Action)
+	}
+	return action
+}
+
+// wrapModel returns a model that adds Azure specific behavior to a model of
+// the OpenAI plugin.
+func (a *AzureOpenAI) wrapModel(m ai.Model, opts ai.ModelOptions) ai.Model {
+	return ai.NewModel(m.Name(), &opts, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		ctx, req, grounding := withGrounding(ctx, req)
+		ctx, filter := withFilterCollector(ctx)
+		return filter.apply(grounding.apply(m.Generate(ctx, req, cb)))
+	})
+}
+
+// wrapModels wraps all model actions returned by the OpenAI plugin.
+func (a *AzureOpenAI) wrapModels(actions []api.Action) []api.Action {
+	for i, action := range actions {
+		if m, ok := action.(ai.Model); ok && action.Desc().Type == api.ActionTypeModel {
+			actions[i] = a.wrapModel(m, modelOptions(action.Desc())).(api.Action)
+		}
+	}
+	return actions
+}
+
+// modelOptions recovers the options of a model from its metadata.
+func modelOptions(desc api.ActionDesc) ai.ModelOptions {
+	var meta struct {
+		Label         string            `json:"label"`
+		Supports      *ai.ModelSupports `json:"supports"`
+		Versions      []string          `json:"versions"`
+		Stage         ai.ModelStage     `json:"stage"`
+		CustomOptions map[string]any    `json:"customOptions"`
+	}
+	if b, err := json.Marshal(desc.Metadata["model"]); err == nil {
+		json.Unmarshal(b, &meta)
+	}
+	return ai.ModelOptions{
+		ConfigSchema: meta.CustomOptions,
+		Label:        meta.Label,
+		Supports:     meta.Supports,
+		Versions:     meta.Versions,
+		Stage:        meta.Stage,
+	}
+}
+
+// setCustom sets key in the custom metadata of resp, unless the OpenAI plugin
+// has set the metadata to something other than a map.
+func setCustom(resp *ai.ModelResponse, key string, value any) {
+	switch custom := resp.Custom.(type) {
+	case nil:
+		resp.Custom = map[string]any{key: value}
+	case map[string]any:
+		custom[key] = value
+	}
+}
@@ -0,0 +1,307 @@
+package azopenai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// citationKey is the key of the custom parts of a response message that
+// hold the citations of a response grounded on data sources.
+const citationKey = "citation"
+
+// ChatConfig configures a chat completion with Azure specific extensions.
+// Pass it to a model with ai.WithConfig.
+type ChatConfig struct {
+	// Params are the regular chat completion parameters.
+	Params openai.ChatCompletionNewParams `json:"params"`
+	// DataSources ground the completion on your data ("Azure OpenAI On Your
+	// Data"). Citations are added to the response message as custom parts,
+	// see Citations.
+	DataSources []DataSource `json:"dataSources,omitempty"`
+}
+
+// Types of data sources.
+const (
+	DataSourceAzureSearch = "azure_search"
+	DataSourceCosmosDB    = "azure_cosmos_db"
+)
+
+// DataSource is a data source for Azure OpenAI On Your Data. Set exactly one
+// of AzureSearch and CosmosDB.
+type DataSource struct {
+	AzureSearch *AzureSearchParameters
+	CosmosDB    *CosmosDBParameters
+}
+
+func (d DataSource) MarshalJSON() ([]byte, error) {
+	var v struct {
+		Type       string `json:"type"`
+		Parameters any    `json:"parameters"`
+	}
+	switch {
+	case (d.AzureSearch != nil) == (d.CosmosDB != nil):
+		return nil, errors.New("azopenai: exactly one data source type must be set")
+	case d.AzureSearch != nil:
+		v.Type, v.Parameters = DataSourceAzureSearch, d.AzureSearch
+	case d.CosmosDB != nil:
+		v.Type, v.Parameters = DataSourceCosmosDB, d.CosmosDB
+	}
+	return json.Marshal(v)
+}
+
+// Types of data source authentication.
+const (
+	AuthAPIKey                        = "api_key"
+	AuthConnectionString              = "connection_string"
+	AuthSystemAssignedManagedIdentity = "system_assigned_managed_identity"
+	AuthUserAssignedManagedIdentity   = "user_assigned_managed_identity"
+)
+
+// DataSourceAuth is how Azure OpenAI authenticates with a data source.
+type DataSourceAuth struct {
+	Type                      string `json:"type"`
+	Key                       string `json:"key,omitempty"`
+	ConnectionString          string `json:"connection_string,omitempty"`
+	ManagedIdentityResourceID string `json:"managed_identity_resource_id,omitempty"`
+}
+
+// FieldsMapping maps index fields to the parts of a citation.
+type FieldsMapping struct {
+	ContentFields []string `json:"content_fields,omitempty"`
+	TitleField    string   `json:"title_field,omitempty"`
+	URLField      string   `json:"url_field,omitempty"`
+	FilepathField string   `json:"filepath_field,omitempty"`
+	VectorFields  []string `json:"vector_fields,omitempty"`
+}
+
+// EmbeddingDependency is the embedding deployment used for vector search.
+type EmbeddingDependency struct {
+	// Type is "deployment_name" or "endpoint".
+	Type           string          `json:"type"`
+	DeploymentName string          `json:"deployment_name,omitempty"`
+	Endpoint       string          `json:"endpoint,omitempty"`
+	Authentication *DataSourceAuth `json:"authentication,omitempty"`
+}
+
+// AzureSearchParameters configure an Azure AI Search index as data source.
+type AzureSearchParameters struct {
+	Endpoint       string         `json:"endpoint"`
+	IndexName      string         `json:"index_name"`
+	Authentication DataSourceAuth `json:"authentication"`
+	// QueryType is "simple", "semantic", "vector", "vector_simple_hybrid" or
+	// "vector_semantic_hybrid".
+	QueryType             string               `json:"query_type,omitempty"`
+	SemanticConfiguration string               `json:"semantic_configuration,omitempty"`
+	Filter                string               `json:"filter,omitempty"`
+	FieldsMapping         *FieldsMapping       `json:"fields_mapping,omitempty"`
+	EmbeddingDependency   *EmbeddingDependency `json:"embedding_dependency,omitempty"`
+	TopNDocuments         int                  `json:"top_n_documents,omitempty"`
+	// Strictness ranges from 1 to 5; higher values filter more documents.
+	Strictness int `json:"strictness,omitempty"`
+	// InScope limits answers to the retrieved documents.
+	InScope *bool `json:"in_scope,omitempty"`
+}
+
+// CosmosDBParameters configure an Azure Cosmos DB for MongoDB vCore index as
+// data source.
+type CosmosDBParameters struct {
+	DatabaseName        string              `json:"database_name"`
+	ContainerName       string              `json:"container_name"`
+	IndexName           string              `json:"index_name"`
+	Authentication      DataSourceAuth      `json:"authentication"`
+	FieldsMapping       FieldsMapping       `json:"fields_mapping"`
+	EmbeddingDependency EmbeddingDependency `json:"embedding_dependency"`
+	TopNDocuments       int                 `json:"top_n_documents,omitempty"`
+	Strictness          int                 `json:"strictness,omitempty"`
+	InScope             *bool               `json:"in_scope,omitempty"`
+}
+
+// Citations returns the documents cited by a response grounded on data
+// sources. Each document's metadata holds the citation's "title", "url",
+// "filepath" and "chunkId" if present.
+//
+// The citations are custom parts of the response message, e.g.
+// {"citation": {"content": "...", "title": "...", "url": "..."}}, so that
+// they are kept with the message in the conversation history. They are not
+// sent back to the model.
+func Citations(resp *ai.ModelResponse) []*ai.Document {
+	if resp == nil || resp.Message == nil {
+		return nil
+	}
+	var docs []*ai.Document
+	for _, p := range resp.Message.Content {
+		if !p.IsCustom() {
+			continue
+		}
+		c, ok := p.Custom[citationKey].(map[string]any)
+		if !ok {
+			continue
+		}
+		content, _ := c["content"].(string)
+		metadata := make(map[string]any)
+		for k, v := range c {
+			if k != "content" {
+				metadata[k] = v
+			}
+		}
+		docs = append(docs, ai.DocumentFromText(content, metadata))
+	}
+	return docs
+}
+
+type groundingKey struct{}
+
+// grounding holds the data sources of a model call and receives the
+// citations of its response.
+type grounding struct {
+	sources []DataSource
+
+	mu        sync.Mutex
+	citations []citation
+}
+
+// withGrounding replaces a *ChatConfig in req by its chat completion
+// parameters and adds its data sources to ctx.
+func withGrounding(ctx context.Context, req *ai.ModelRequest) (context.Context, *ai.ModelRequest, *grounding) {
+	var cfg ChatConfig
+	switch c := req.Config.(type) {
+	case ChatConfig:
+		cfg = c
+	case *ChatConfig:
+		cfg = *c
+	default:
+		return ctx, req, nil
+	}
+	r := *req
+	r.Config = cfg.Params
+	if len(cfg.DataSources) == 0 {
+		return ctx, &r, nil
+	}
+	g := &grounding{sources: cfg.DataSources}
+	return context.WithValue(ctx, groundingKey{}, g), &r, g
+}
+
+// apply adds the collected citations to the message of resp.
+func (g *grounding) apply(resp *ai.ModelResponse, err error) (*ai.ModelResponse, error) {
+	if g == nil || err != nil || resp == nil || resp.Message == nil {
+		return resp, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, c := range g.citations {
+		resp.Message.Content = append(resp.Message.Content, c.part())
+	}
+	return resp, nil
+}
+
+type citation struct {
+	Content  string `json:"content"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Filepath string `json:"filepath"`
+	ChunkID  string `json:"chunk_id"`
+}
+
+// part returns a custom part that holds c, see Citations.
+func (c citation) part() *ai.Part {
+	fields := map[string]any{"content": c.Content}
+	for k, v := range map[string]string{"title": c.Title, "url": c.URL, "filepath": c.Filepath, "chunkId": c.ChunkID} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return ai.NewCustomPart(map[string]any{citationKey: fields})
+}
+
+// groundOnData returns middleware that adds the data sources of the model
+// call to chat completion requests, and collects the citations of their
+// responses.
+func groundOnData() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		g, ok := req.Context().Value(groundingKey{}).(*grounding)
+		if !ok || req.Body == nil || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+			return next(req)
+		}
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(b, &body); err != nil {
+			return nil, err
+		}
+		if body["data_sources"], err = json.Marshal(g.sources); err != nil {
+			return nil, err
+		}
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+
+		res, err := next(req)
+		if err != nil || res.StatusCode != http.StatusOK {
+			return res, err
+		}
+		if isEventStream(res.Header.Get("Content-Type")) {
+			// Citations are sent with the first chunk of the message
+			res.Body = observeEvents(res.Body, func(data []byte) {
+				var chunk struct {
+					Choices []struct {
+						Delta messageContext `json:"delta"`
+					} `json:"choices"`
+				}
+				if json.Unmarshal(data, &chunk) == nil && len(chunk.Choices) > 0 {
+					g.add(chunk.Choices[0].Delta.Context.Citations)
+				}
+			})
+			return res, nil
+		}
+		if !isJSON(res.Header.Get("Content-Type")) {
+			return res, nil
+		}
+		rb, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(rb))
+		if err != nil {
+			return nil, err
+		}
+		var rbody struct {
+			Choices []struct {
+				Message messageContext `json:"message"`
+			} `json:"choices"`
+		}
+		if json.Unmarshal(rb, &rbody) != nil || len(rbody.Choices) == 0 {
+			return res, nil
+		}
+		g.add(rbody.Choices[0].Message.Context.Citations)
+		return res, nil
+	})
+}
+
+// messageContext is the context of a grounded message or streamed delta.
+type messageContext struct {
+	Context struct {
+		Citations []citation `json:"citations"`
+	} `json:"context"`
+}
+
+// add collects citations.
+func (g *grounding) add(citations []citation) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.citations = append(g.citations, citations...)
+}
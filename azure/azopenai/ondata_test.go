@@ -0,0 +1,109 @@
+package azopenai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// groundedCompletion returns a chat completion with citations.
+func groundedCompletion(text string, citations ...map[string]any) map[string]any {
+	res := chatCompletion(text)
+	msg := res["choices"].([]any)[0].(map[string]any)["message"].(map[string]any)
+	msg["context"] = map[string]any{"citations": citations, "intent": "[]"}
+	return res
+}
+
+func TestGroundOnData(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		if _, ok := body["data_sources"]; !ok {
+			writeJSON(w, chatCompletion("Not grounded"))
+			return
+		}
+		writeJSON(w, groundedCompletion("Springfield [doc1].",
+			map[string]any{"content": "The Simpsons live in Springfield.", "title": "The Simpsons", "url": "https://example.com/simpsons", "chunk_id": "0"},
+			map[string]any{"content": "Springfield is a town.", "filepath": "towns.md"},
+		))
+	})
+	g := initGenkit(t, srv, WithDeployment("gpt-dep"))
+	source := DataSource{AzureSearch: &AzureSearchParameters{
+		Endpoint:       "https://search.example.com",
+		IndexName:      "shows",
+		Authentication: DataSourceAuth{Type: AuthAPIKey, Key: "search-key"},
+	}}
+	resp, err := genkit.Generate(context.Background(), g,
+		ai.WithModelName("openai/gpt"),
+		ai.WithPrompt("Where do the Simpsons live?"),
+		ai.WithConfig(&ChatConfig{DataSources: []DataSource{source}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources, _ := srv.last(t).Body["data_sources"].([]any)
+	if len(sources) != 1 || sources[0].(map[string]any)["type"] != DataSourceAzureSearch {
+		t.Errorf("got data sources %v", sources)
+	}
+	if got := resp.Text(); got != "Springfield [doc1]." {
+		t.Errorf("got text %q", got)
+	}
+	want := []*ai.Document{
+		ai.DocumentFromText("The Simpsons live in Springfield.", map[string]any{"title": "The Simpsons", "url": "https://example.com/simpsons", "chunkId": "0"}),
+		ai.DocumentFromText("Springfield is a town.", map[string]any{"filepath": "towns.md"}),
+	}
+	if got := Citations(resp); !reflect.DeepEqual(got, want) {
+		t.Errorf("got citations %v, want %v", got, want)
+	}
+
+	// Citations survive serializing the message, e.g. in a session
+	b, err := json.Marshal(resp.Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg ai.Message
+	if err := json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := Citations(&ai.ModelResponse{Message: &msg}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got citations %v after serialization, want %v", got, want)
+	}
+
+	// Citations are not sent back to the model
+	if _, err := genkit.Generate(context.Background(), g,
+		ai.WithModelName("openai/gpt"),
+		ai.WithMessages(append(resp.History(), ai.NewUserTextMessage("And Homer?"))...),
+	); err != nil {
+		t.Fatal(err)
+	}
+	msgs, _ := srv.last(t).Body["messages"].([]any)
+	if len(msgs) != 3 {
+		t.Fatalf("got messages %v, want 3", msgs)
+	}
+	if got := msgs[1].(map[string]any)["content"]; got != "Springfield [doc1]." {
+		t.Errorf("got assistant content %v", got)
+	}
+}
+
+func TestCitationsWithoutGrounding(t *testing.T) {
+	srv := newFakeServer(t)
+	g := initGenkit(t, srv, WithDeployment("gpt-dep"))
+	resp, err := genkit.Generate(context.Background(), g, ai.WithModelName("openai/gpt"), ai.WithPrompt("Hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Citations(resp); got != nil {
+		t.Errorf("got citations %v", got)
+	}
+	if _, ok := srv.last(t).Body["data_sources"]; ok {
+		t.Error("data sources sent without ChatConfig")
+	}
+	if got := Citations(nil); got != nil {
+		t.Errorf("got citations %v for nil response", got)
+	}
+}
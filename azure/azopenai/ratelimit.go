@@ -0,0 +1,291 @@
+package azopenai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+// RateLimit paces requests sent to a deployment on the client side, so that
+// the deployment's quota is not exceeded. Zero values disable the respective
+// limit.
+type RateLimit struct {
+	// RequestsPerMinute corresponds to the deployment's RPM quota.
+	RequestsPerMinute int
+	// TokensPerMinute corresponds to the deployment's TPM quota. Tokens are
+	// estimated from the request size and max_tokens before sending the
+	// request, and corrected by the actual usage reported in the response.
+	TokensPerMinute int
+}
+
+func (r RateLimit) enabled() bool {
+	return r.RequestsPerMinute > 0 || r.TokensPerMinute > 0
+}
+
+// WithRateLimit sets the rate limit applied to each deployment that does
+// not have its own RateLimit.
+func WithRateLimit(r RateLimit) Option {
+	return func(a *AzureOpenAI) {
+		a.RateLimit = r
+	}
+}
+
+// RateLimitError is returned if a request cannot be sent within its
+// context's deadline without exceeding the deployment's rate limit.
+type RateLimitError struct {
+	Deployment string
+	// Wait is how long the request would have had to wait.
+	Wait time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("azopenai: rate limit of deployment %q exceeded, retry in %v", e.Deployment, e.Wait.Round(time.Millisecond))
+}
+
+// RateLimitStats are the usage counters of a rate-limited deployment.
+type RateLimitStats struct {
+	// Requests and Tokens are the totals sent since the plugin was initialized.
+	Requests int64
+	Tokens   int64
+	// Delayed counts requests that had to wait, Rejected counts requests that
+	// failed with a RateLimitError.
+	Delayed  int64
+	Rejected int64
+	// AvailableRequests and AvailableTokens are the current capacity left
+	// within the rate limit's window.
+	AvailableRequests float64
+	AvailableTokens   float64
+}
+
+// RateLimitStats returns the usage counters of all rate-limited deployments,
+// keyed by endpoint host and deployment name, e.g.
+// "myresource.openai.azure.com/gpt-5-mini".
+func (a *AzureOpenAI) RateLimitStats() map[string]RateLimitStats {
+	a.limitersMu.Lock()
+	defer a.limitersMu.Unlock()
+	stats := make(map[string]RateLimitStats, len(a.limiters))
+	now := time.Now()
+	for k, l := range a.limiters {
+		stats[k] = l.stats(now)
+	}
+	return stats
+}
+
+// bucket is a token bucket refilled continuously at capacity per minute.
+// Its level may become negative, which delays subsequent reservations.
+type bucket struct {
+	capacity float64
+	level    float64
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{capacity: float64(perMinute), level: float64(perMinute), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.level = min(b.capacity, b.level+now.Sub(b.last).Minutes()*b.capacity)
+	b.last = now
+}
+
+// reserve takes n, but at most the bucket's capacity, from the bucket. It
+// returns the amount taken and how long to wait until the reservation is
+// covered.
+func (b *bucket) reserve(n float64, now time.Time) (float64, time.Duration) {
+	if b == nil {
+		return 0, 0
+	}
+	b.refill(now)
+	n = min(n, b.capacity)
+	b.level -= n
+	if b.level >= 0 {
+		return n, 0
+	}
+	return n, time.Duration(-b.level / b.capacity * float64(time.Minute))
+}
+
+func (b *bucket) adjust(n float64) {
+	if b != nil {
+		b.level = min(b.capacity, b.level-n)
+	}
+}
+
+type limiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+
+	sent, used, delayed, rejected int64
+}
+
+func newLimiter(r RateLimit) *limiter {
+	now := time.Now()
+	return &limiter{
+		requests: newBucket(r.RequestsPerMinute, now),
+		tokens:   newBucket(r.TokensPerMinute, now),
+	}
+}
+
+// wait blocks until a request with an estimated number of tokens may be
+// sent. It fails fast if ctx's deadline would expire before.
+func (l *limiter) wait(ctx context.Context, deployment string, tokens int) error {
+	l.mu.Lock()
+	now := time.Now()
+	requests, dr := l.requests.reserve(1, now)
+	reserved, dt := l.tokens.reserve(float64(tokens), now)
+	d := max(dr, dt)
+	if deadline, ok := ctx.Deadline(); ok && now.Add(d).After(deadline) {
+		// Return exactly what was reserved
+		l.requests.adjust(-requests)
+		l.tokens.adjust(-reserved)
+		l.rejected++
+		l.mu.Unlock()
+		return &RateLimitError{Deployment: deployment, Wait: d}
+	}
+	l.sent++
+	l.used += int64(tokens)
+	if d > 0 {
+		l.delayed++
+	}
+	l.mu.Unlock()
+
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// The request isn't sent, so its reservation is returned as if it
+		// had been rejected
+		l.mu.Lock()
+		l.requests.adjust(-requests)
+		l.tokens.adjust(-reserved)
+		l.sent--
+		l.used -= int64(tokens)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// correct replaces the estimated by the actual number of tokens.
+func (l *limiter) correct(estimated, actual int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.adjust(float64(actual - estimated))
+	l.used += int64(actual - estimated)
+}
+
+func (l *limiter) stats(now time.Time) RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := RateLimitStats{
+		Requests: l.sent,
+		Tokens:   l.used,
+		Delayed:  l.delayed,
+		Rejected: l.rejected,
+	}
+	if l.requests != nil {
+		l.requests.refill(now)
+		s.AvailableRequests = l.requests.level
+	}
+	if l.tokens != nil {
+		l.tokens.refill(now)
+		s.AvailableTokens = l.tokens.level
+	}
+	return s
+}
+
+func (a *AzureOpenAI) limiterFor(key string, r RateLimit) *limiter {
+	a.limitersMu.Lock()
+	defer a.limitersMu.Unlock()
+	if a.limiters == nil {
+		a.limiters = make(map[string]*limiter)
+	}
+	l, ok := a.limiters[key]
+	if !ok {
+		l = newLimiter(r)
+		a.limiters[key] = l
+	}
+	return l
+}
+
+// estimateTokens roughly estimates the tokens a request counts against a
+// deployment's quota: about four bytes per prompt token plus the maximum
+// number of tokens to generate.
+func estimateTokens(body map[string]json.RawMessage, size int) int {
+	tokens := size / 4
+	for _, k := range []string{"max_completion_tokens", "max_tokens", "max_output_tokens"} {
+		var n int
+		if raw, ok := body[k]; ok && json.Unmarshal(raw, &n) == nil {
+			return tokens + n
+		}
+	}
+	return tokens
+}
+
+// limitRate returns middleware that paces requests according to the rate
+// limit of the deployment selected by routeToDeployment.
+func (a *AzureOpenAI) limitRate() option.RequestOption {
+	return option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+		rr, ok := req.Context().Value(routeKey{}).(route)
+		if !ok {
+			return next(req)
+		}
+		r := rr.deployment.RateLimit
+		if !r.enabled() {
+			r = a.RateLimit
+		}
+		if !r.enabled() {
+			return next(req)
+		}
+
+		l := a.limiterFor(a.endpointFor(req).base.Host+"/"+rr.deployment.Name, r)
+		if err := l.wait(req.Context(), rr.deployment.Name, rr.tokens); err != nil {
+			return nil, err
+		}
+		res, err := next(req)
+		if err != nil {
+			return res, err
+		}
+		if res.StatusCode == http.StatusOK && isEventStream(res.Header.Get("Content-Type")) {
+			res.Body = observeEvents(res.Body, func(data []byte) {
+				var event streamUsage
+				if json.Unmarshal(data, &event) == nil && event.usage() != nil {
+					l.correct(rr.tokens, event.usage().TotalTokens)
+				}
+			})
+			return res, nil
+		}
+		if !isJSON(res.Header.Get("Content-Type")) {
+			return res, nil
+		}
+
+		// Correct the estimate by the actual usage
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Usage *usage `json:"usage"`
+		}
+		if json.Unmarshal(b, &body) == nil && body.Usage != nil {
+			l.correct(rr.tokens, body.Usage.TotalTokens)
+		}
+		return res, nil
+	})
+}
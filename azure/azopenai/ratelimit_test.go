@@ -0,0 +1,142 @@
+package azopenai
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := newBucket(10, now)
+	tests := []struct {
+		n        float64
+		reserved float64
+		wait     time.Duration
+	}{
+		{n: 4, reserved: 4},
+		{n: 6, reserved: 6},
+		// Reservations larger than the capacity are capped
+		{n: 25, reserved: 10, wait: time.Minute},
+		{n: 1, reserved: 1, wait: 66 * time.Second},
+	}
+	for i, tt := range tests {
+		reserved, wait := b.reserve(tt.n, now)
+		if reserved != tt.reserved || wait.Round(time.Millisecond) != tt.wait {
+			t.Errorf("%d: reserve(%v) = %v, %v, want %v, %v", i, tt.n, reserved, wait, tt.reserved, tt.wait)
+		}
+	}
+	var disabled *bucket
+	if reserved, wait := disabled.reserve(5, now); reserved != 0 || wait != 0 {
+		t.Errorf("nil bucket reserved %v, %v", reserved, wait)
+	}
+}
+
+func TestLimiterRefundsRejectedReservation(t *testing.T) {
+	l := newLimiter(RateLimit{RequestsPerMinute: 10, TokensPerMinute: 100})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := l.wait(ctx, "gpt", 100); err != nil {
+		t.Fatal(err)
+	}
+	// 500 tokens exceed the capacity, only 100 are reserved and returned
+	err := l.wait(ctx, "gpt", 500)
+	var rle *RateLimitError
+	if !errors.As(err, &rle) || rle.Deployment != "gpt" || rle.Wait < 59*time.Second {
+		t.Fatalf("got error %v, want a RateLimitError waiting a minute", err)
+	}
+
+	s := l.stats(time.Now())
+	if s.Requests != 1 || s.Tokens != 100 || s.Rejected != 1 || s.Delayed != 0 {
+		t.Errorf("got stats %+v", s)
+	}
+	// Allow for refilling since the reservation
+	if s.AvailableTokens > 1 || s.AvailableRequests < 9 || s.AvailableRequests > 9.1 {
+		t.Errorf("got %v tokens and %v requests available, want 0 and 9", s.AvailableTokens, s.AvailableRequests)
+	}
+}
+
+func TestLimiterDelays(t *testing.T) {
+	l := newLimiter(RateLimit{TokensPerMinute: 6000})
+	if err := l.wait(context.Background(), "gpt", 6000); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	// 5 tokens are refilled after 50ms
+	if err := l.wait(context.Background(), "gpt", 5); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("waited %v, want about 50ms", d)
+	}
+	if s := l.stats(time.Now()); s.Delayed != 1 || s.Requests != 2 {
+		t.Errorf("got stats %+v", s)
+	}
+}
+
+func TestLimiterRefundsCancelledReservation(t *testing.T) {
+	l := newLimiter(RateLimit{RequestsPerMinute: 10, TokensPerMinute: 100})
+	if err := l.wait(context.Background(), "gpt", 100); err != nil {
+		t.Fatal(err)
+	}
+	// 50 tokens are refilled after 30s, but the request is cancelled before
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := l.wait(ctx, "gpt", 50); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+
+	s := l.stats(time.Now())
+	if s.Requests != 1 || s.Tokens != 100 || s.Delayed != 1 || s.Rejected != 0 {
+		t.Errorf("got stats %+v", s)
+	}
+	// Only the first request's reservation is left, allowing for refilling
+	if s.AvailableTokens < 0 || s.AvailableTokens > 1 || s.AvailableRequests < 9 || s.AvailableRequests > 9.1 {
+		t.Errorf("got %v tokens and %v requests available, want 0 and 9", s.AvailableTokens, s.AvailableRequests)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	srv := newFakeServer(t)
+	a := newTestPlugin(t, srv.URL,
+		WithAPIKey("secret"),
+		WithModel("chat", Deployment{Name: "gpt-dep", RateLimit: RateLimit{RequestsPerMinute: 1, TokensPerMinute: 1000}}),
+	)
+	c := testClient(a)
+	params := openai.ChatCompletionNewParams{
+		Model:    "chat",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("Hi")},
+	}
+	if _, err := c.Chat.Completions.New(context.Background(), params); err != nil {
+		t.Fatal(err)
+	}
+
+	// The only request per minute has been used
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := c.Chat.Completions.New(ctx, params)
+	if rle := (*RateLimitError)(nil); !errors.As(err, &rle) {
+		t.Fatalf("got error %v, want a RateLimitError", err)
+	}
+	if n := len(srv.requests()); n != 1 {
+		t.Errorf("server received %d requests, want 1", n)
+	}
+
+	key := srv.Listener.Addr().String() + "/gpt-dep"
+	s, ok := a.RateLimitStats()[key]
+	if !ok {
+		t.Fatalf("no stats for %q in %v", key, a.RateLimitStats())
+	}
+	// The estimate has been replaced by the actual usage
+	if s.Requests != 1 || s.Tokens != 7 || s.Rejected != 1 {
+		t.Errorf("got stats %+v", s)
+	}
+	if math.Abs(s.AvailableTokens-993) > 1 {
+		t.Errorf("got %v tokens available, want 993", s.AvailableTokens)
+	}
+}
@@ -0,0 +1,367 @@
+package azopenai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/responses"
+	"github.com/openai/openai-go/shared"
+)
+
+// responsesAPIVersion is the api-version used for the Responses API with the
+// deployment-based API, unless a Deployment sets its own APIVersion.
+const responsesAPIVersion = "2025-04-01-preview"
+
+// responseIDKey is the key of ai.ModelResponse.Custom that holds the ID of a
+// Responses API response.
+const responseIDKey = "responseId"
+
+// ModelAPI selects the API that a model is sent to.
+type ModelAPI int
+
+const (
+	// APIChatCompletions sends requests to the Chat Completions API.
+	APIChatCompletions ModelAPI = iota
+	// APIResponses sends requests to the Responses API, which supports
+	// server-side conversation state and reasoning summaries.
+	APIResponses
+)
+
+func (m ModelAPI) String() string {
+	switch m {
+	case APIChatCompletions:
+		return "chat completions"
+	case APIResponses:
+		return "responses"
+	default:
+		return fmt.Sprintf("ModelAPI(%d)", int(m))
+	}
+}
+
+// ResponseID returns the ID of a response generated by a model that uses the
+// Responses API. Pass it as PreviousResponseID of the next request's
+// responses.ResponseNewParams to continue a conversation with server-side
+// state instead of resending its history.
+func ResponseID(resp *ai.ModelResponse) string {
+	if resp == nil {
+		return ""
+	}
+	if custom, ok := resp.Custom.(map[string]any); ok {
+		id, _ := custom[responseIDKey].(string)
+		return id
+	}
+	return ""
+}
+
+// defineResponsesModel returns a model that sends requests for the Genkit
+// model name to the Responses API.
+func (a *AzureOpenAI) defineResponsesModel(name string, opts ai.ModelOptions) ai.Model {
+	m := ai.NewModel(providerName(name), &opts, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		params, err := responseParams(name, req)
+		if err != nil {
+			return nil, err
+		}
+		if cb != nil {
+			return a.streamResponse(ctx, params, req, cb)
+		}
+		res, err := a.client.Responses.New(ctx, *params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create response: %w", err)
+		}
+		return toModelResponse(res, req)
+	})
+	return a.wrapModel(m, opts)
+}
+
+// responseParams converts a Genkit model request to Responses API parameters.
+func responseParams(name string, req *ai.ModelRequest) (*responses.ResponseNewParams, error) {
+	var params responses.ResponseNewParams
+	switch cfg := req.Config.(type) {
+	case nil:
+	case responses.ResponseNewParams:
+		params = cfg
+	case *responses.ResponseNewParams:
+		params = *cfg
+	case ai.GenerationCommonConfig:
+		params = commonParams(&cfg)
+	case *ai.GenerationCommonConfig:
+		params = commonParams(cfg)
+	case map[string]any:
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &params); err != nil {
+			return nil, fmt.Errorf("failed to convert config to responses.ResponseNewParams: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected config type: %T", req.Config)
+	}
+	params.Model = name
+
+	input, err := responseInput(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	if len(input) == 0 && !params.PreviousResponseID.Valid() {
+		return nil, fmt.Errorf("no messages provided")
+	}
+	params.Input = responses.ResponseNewParamsInputUnion{OfInputItemList: input}
+
+	for _, t := range req.Tools {
+		if t == nil || t.Name == "" {
+			continue
+		}
+		strict, _ := t.Metadata["strict"].(bool)
+		tool := responses.ToolParamOfFunction(t.Name, t.InputSchema, strict)
+		if t.Description != "" {
+			tool.OfFunction.Description = openai.String(t.Description)
+		}
+		params.Tools = append(params.Tools, tool)
+	}
+	switch req.ToolChoice {
+	case ai.ToolChoiceAuto, ai.ToolChoiceRequired, ai.ToolChoiceNone:
+		params.ToolChoice.OfToolChoiceMode = openai.Opt(responses.ToolChoiceOptions(req.ToolChoice))
+	}
+
+	if out := req.Output; out != nil && out.Format == "json" {
+		if out.Schema != nil {
+			params.Text.Format = responses.ResponseFormatTextConfigParamOfJSONSchema("output", out.Schema)
+			params.Text.Format.OfJSONSchema.Strict = openai.Bool(true)
+		} else {
+			params.Text.Format.OfJSONObject = &shared.ResponseFormatJSONObjectParam{}
+		}
+	}
+	return &params, nil
+}
+
+func commonParams(cfg *ai.GenerationCommonConfig) responses.ResponseNewParams {
+	var params responses.ResponseNewParams
+	if cfg.MaxOutputTokens > 0 {
+		params.MaxOutputTokens = openai.Int(int64(cfg.MaxOutputTokens))
+	}
+	if cfg.Temperature != 0 {
+		params.Temperature = openai.Float(cfg.Temperature)
+	}
+	if cfg.TopP != 0 {
+		params.TopP = openai.Float(cfg.TopP)
+	}
+	return params
+}
+
+// responseInput converts Genkit messages to Responses API input items.
+// Reasoning parts are not sent back; use ResponseID to continue a
+// conversation including the model's reasoning.
+func responseInput(messages []*ai.Message) (responses.ResponseInputParam, error) {
+	var input responses.ResponseInputParam
+	for _, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			input = append(input, responses.ResponseInputItemParamOfMessage(concatText(msg.Content), responses.EasyInputMessageRoleSystem))
+		case ai.RoleUser:
+			var content responses.ResponseInputMessageContentListParam
+			for _, p := range msg.Content {
+				switch {
+				case p.IsText():
+					content = append(content, responses.ResponseInputContentParamOfInputText(p.Text))
+				case p.IsMedia():
+					image := responses.ResponseInputContentParamOfInputImage(responses.ResponseInputImageDetailAuto)
+					image.OfInputImage.ImageURL = openai.String(p.Text)
+					content = append(content, image)
+				}
+			}
+			if len(content) > 0 {
+				input = append(input, responses.ResponseInputItemParamOfMessage(content, responses.EasyInputMessageRoleUser))
+			}
+		case ai.RoleModel:
+			// Text and tool calls keep their order, consecutive text parts
+			// become a single message
+			var text strings.Builder
+			flush := func() {
+				if text.Len() > 0 {
+					input = append(input, responses.ResponseInputItemParamOfMessage(text.String(), responses.EasyInputMessageRoleAssistant))
+					text.Reset()
+				}
+			}
+			for _, p := range msg.Content {
+				switch {
+				case p.IsText():
+					text.WriteString(p.Text)
+				case p.IsToolRequest():
+					flush()
+					args, err := json.Marshal(p.ToolRequest.Input)
+					if err != nil {
+						return nil, fmt.Errorf("failed to marshal tool input: %w", err)
+					}
+					input = append(input, responses.ResponseInputItemParamOfFunctionCall(string(args), cmpRef(p.ToolRequest.Ref, p.ToolRequest.Name), p.ToolRequest.Name))
+				}
+			}
+			flush()
+		case ai.RoleTool:
+			for _, p := range msg.Content {
+				if !p.IsToolResponse() {
+					continue
+				}
+				output, err := json.Marshal(p.ToolResponse.Output)
+				if err != nil {
+					return nil, fmt.Errorf("failed to marshal tool output: %w", err)
+				}
+				input = append(input, responses.ResponseInputItemParamOfFunctionCallOutput(cmpRef(p.ToolResponse.Ref, p.ToolResponse.Name), string(output)))
+			}
+		}
+	}
+	return input, nil
+}
+
+// cmpRef returns the tool call ID ref, or name if the call has no ID.
+func cmpRef(ref, name string) string {
+	if ref != "" {
+		return ref
+	}
+	return name
+}
+
+// concatText concatenates the text parts of a message. Reasoning parts are
+// skipped.
+func concatText(parts []*ai.Part) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		if p.IsText() {
+			sb.WriteString(p.Text)
+		}
+	}
+	return sb.String()
+}
+
+// toModelResponse converts a Responses API response to a Genkit model
+// response.
+func toModelResponse(res *responses.Response, req *ai.ModelRequest) (*ai.ModelResponse, error) {
+	if res.Status == responses.ResponseStatusFailed {
+		return nil, fmt.Errorf("response failed: %s: %s", res.Error.Code, res.Error.Message)
+	}
+	resp := &ai.ModelResponse{
+		Request: req,
+		Message: &ai.Message{Role: ai.RoleModel},
+		Usage: &ai.GenerationUsage{
+			InputTokens:         int(res.Usage.InputTokens),
+			OutputTokens:        int(res.Usage.OutputTokens),
+			TotalTokens:         int(res.Usage.TotalTokens),
+			ThoughtsTokens:      int(res.Usage.OutputTokensDetails.ReasoningTokens),
+			CachedContentTokens: int(res.Usage.InputTokensDetails.CachedTokens),
+		},
+		Custom: map[string]any{
+			responseIDKey: res.ID,
+			"model":       res.Model,
+		},
+	}
+
+	switch res.Status {
+	case responses.ResponseStatusCompleted:
+		resp.FinishReason = ai.FinishReasonStop
+	case responses.ResponseStatusIncomplete:
+		switch res.IncompleteDetails.Reason {
+		case "max_output_tokens":
+			resp.FinishReason = ai.FinishReasonLength
+		case "content_filter":
+			resp.FinishReason = ai.FinishReasonBlocked
+		default:
+			resp.FinishReason = ai.FinishReasonOther
+		}
+	default:
+		resp.FinishReason = ai.FinishReasonUnknown
+	}
+
+	for _, item := range res.Output {
+		switch item.Type {
+		case "reasoning":
+			r := item.AsReasoning()
+			var summary []string
+			for _, s := range r.Summary {
+				summary = append(summary, s.Text)
+			}
+			part := ai.NewReasoningPart(strings.Join(summary, "\n\n"), []byte(r.EncryptedContent))
+			part.Metadata["id"] = r.ID
+			resp.Message.Content = append(resp.Message.Content, part)
+		case "message":
+			for _, c := range item.AsMessage().Content {
+				switch c.Type {
+				case "output_text":
+					resp.Message.Content = append(resp.Message.Content, ai.NewTextPart(c.Text))
+				case "refusal":
+					resp.FinishReason = ai.FinishReasonBlocked
+					resp.FinishMessage = c.Refusal
+				}
+			}
+		case "function_call":
+			part, err := toolRequestPart(item.AsFunctionCall())
+			if err != nil {
+				return nil, err
+			}
+			resp.Message.Content = append(resp.Message.Content, part)
+		}
+	}
+	return resp, nil
+}
+
+// toolRequestPart converts a function call into a tool request, parsing its
+// JSON arguments.
+func toolRequestPart(fc responses.ResponseFunctionToolCall) (*ai.Part, error) {
+	var args map[string]any
+	if err := json.Unmarshal([]byte(fc.Arguments), &args); err != nil {
+		return nil, fmt.Errorf("could not parse tool args of %q: %w", fc.Name, err)
+	}
+	return ai.NewToolRequestPart(&ai.ToolRequest{
+		Ref:   fc.CallID,
+		Name:  fc.Name,
+		Input: args,
+	}), nil
+}
+
+// streamResponse streams a response, passing text, reasoning summaries and
+// tool requests to cb as they arrive.
+func (a *AzureOpenAI) streamResponse(ctx context.Context, params *responses.ResponseNewParams, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	stream := a.client.Responses.NewStreaming(ctx, *params)
+	defer stream.Close()
+
+	var final *responses.Response
+	for stream.Next() {
+		event := stream.Current()
+		var chunk ai.ModelResponseChunk
+		switch event.Type {
+		case "response.output_text.delta":
+			chunk.Content = append(chunk.Content, ai.NewTextPart(event.Delta.OfString))
+		case "response.reasoning_summary_text.delta":
+			chunk.Content = append(chunk.Content, ai.NewReasoningPart(event.Delta.OfString, nil))
+		case "response.output_item.done":
+			if event.Item.Type == "function_call" {
+				part, err := toolRequestPart(event.Item.AsFunctionCall())
+				if err != nil {
+					return nil, err
+				}
+				chunk.Content = append(chunk.Content, part)
+			}
+		case "response.completed", "response.incomplete", "response.failed":
+			res := event.Response
+			final = &res
+		case "error":
+			return nil, fmt.Errorf("stream error: %s: %s", event.Code, event.Message)
+		}
+		if len(chunk.Content) > 0 {
+			chunk.Role = ai.RoleModel
+			if err := cb(ctx, &chunk); err != nil {
+				return nil, fmt.Errorf("callback error: %w", err)
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("stream error: %w", err)
+	}
+	if final == nil {
+		return nil, fmt.Errorf("stream ended without a response")
+	}
+	return toModelResponse(final, req)
+}
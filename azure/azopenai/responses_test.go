@@ -0,0 +1,326 @@
+package azopenai
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/responses"
+)
+
+func TestToolRequestPart(t *testing.T) {
+	fc := responses.ResponseFunctionToolCall{CallID: "call_1", Name: "lookup", Arguments: `{"city":"Berlin","days":3}`}
+	part, err := toolRequestPart(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"city": "Berlin", "days": float64(3)}
+	if tr := part.ToolRequest; tr.Ref != "call_1" || tr.Name != "lookup" || !reflect.DeepEqual(tr.Input, want) {
+		t.Errorf("got %+v, want input %v", tr, want)
+	}
+
+	fc.Arguments = `{"city":`
+	if _, err := toolRequestPart(fc); err == nil {
+		t.Error("expected an error for invalid arguments")
+	}
+}
+
+// checkJSON checks that v marshals to the same JSON as want.
+func checkJSON(t *testing.T, v any, want string) {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, wantV any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantV); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wantV) {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestResponseInput(t *testing.T) {
+	call := &ai.ToolRequest{Ref: "call_1", Name: "lookup", Input: map[string]any{"city": "Berlin"}}
+	tests := []struct {
+		name     string
+		messages []*ai.Message
+		want     string
+	}{
+		{
+			name:     "system",
+			messages: []*ai.Message{ai.NewSystemMessage(ai.NewTextPart("Be "), ai.NewReasoningPart("hidden", nil), ai.NewTextPart("brief."))},
+			want:     `[{"role":"system","content":"Be brief."}]`,
+		},
+		{
+			name:     "user",
+			messages: []*ai.Message{ai.NewUserMessage(ai.NewTextPart("What is this?"), ai.NewMediaPart("image/png", "https://example.com/a.png"))},
+			want: `[{"role":"user","content":[
+				{"type":"input_text","text":"What is this?"},
+				{"type":"input_image","detail":"auto","image_url":"https://example.com/a.png"}
+			]}]`,
+		},
+		{
+			name:     "user without content",
+			messages: []*ai.Message{ai.NewUserMessage(ai.NewReasoningPart("hidden", nil))},
+			want:     `null`,
+		},
+		{
+			name: "model",
+			messages: []*ai.Message{ai.NewModelMessage(
+				ai.NewReasoningPart("thinking", nil),
+				ai.NewTextPart("Let me "),
+				ai.NewTextPart("check."),
+				ai.NewToolRequestPart(call),
+				ai.NewToolRequestPart(&ai.ToolRequest{Name: "now"}),
+				ai.NewTextPart("Done."),
+			)},
+			want: `[
+				{"role":"assistant","content":"Let me check."},
+				{"type":"function_call","call_id":"call_1","name":"lookup","arguments":"{\"city\":\"Berlin\"}"},
+				{"type":"function_call","call_id":"now","name":"now","arguments":"null"},
+				{"role":"assistant","content":"Done."}
+			]`,
+		},
+		{
+			name:     "model tool call only",
+			messages: []*ai.Message{ai.NewModelMessage(ai.NewToolRequestPart(call))},
+			want:     `[{"type":"function_call","call_id":"call_1","name":"lookup","arguments":"{\"city\":\"Berlin\"}"}]`,
+		},
+		{
+			name: "tool results",
+			messages: []*ai.Message{ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{Ref: "call_1", Name: "lookup", Output: map[string]any{"temp": 20}}),
+				ai.NewTextPart("ignored"),
+				ai.NewToolResponsePart(&ai.ToolResponse{Name: "now", Output: "noon"}),
+			)},
+			want: `[
+				{"type":"function_call_output","call_id":"call_1","output":"{\"temp\":20}"},
+				{"type":"function_call_output","call_id":"now","output":"\"noon\""}
+			]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := responseInput(tt.messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkJSON(t, input, tt.want)
+		})
+	}
+
+	bad := ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{Name: "lookup", Input: make(chan int)}))
+	if _, err := responseInput([]*ai.Message{bad}); err == nil {
+		t.Error("expected an error for tool input that can't be marshaled")
+	}
+}
+
+func TestResponseParams(t *testing.T) {
+	hi := []*ai.Message{ai.NewUserTextMessage("Hi")}
+	const input = `[{"role":"user","content":[{"type":"input_text","text":"Hi"}]}]`
+	tests := []struct {
+		name string
+		req  *ai.ModelRequest
+		want string
+		err  string
+	}{
+		{
+			name: "without config",
+			req:  &ai.ModelRequest{Messages: hi},
+			want: `{"model":"gpt","input":` + input + `}`,
+		},
+		{
+			name: "common config",
+			req:  &ai.ModelRequest{Messages: hi, Config: &ai.GenerationCommonConfig{MaxOutputTokens: 10, Temperature: 0.5, TopP: 0.9}},
+			want: `{"model":"gpt","input":` + input + `,"max_output_tokens":10,"temperature":0.5,"top_p":0.9}`,
+		},
+		{
+			name: "params",
+			req:  &ai.ModelRequest{Messages: hi, Config: responses.ResponseNewParams{Model: "ignored", Instructions: openai.String("Be brief.")}},
+			want: `{"model":"gpt","input":` + input + `,"instructions":"Be brief."}`,
+		},
+		{
+			// A previous response's state replaces the conversation
+			name: "map config",
+			req:  &ai.ModelRequest{Config: map[string]any{"previous_response_id": "resp-0", "max_output_tokens": 5}},
+			want: `{"model":"gpt","previous_response_id":"resp-0","max_output_tokens":5}`,
+		},
+		{
+			name: "tools",
+			req: &ai.ModelRequest{
+				Messages: hi,
+				Tools: []*ai.ToolDefinition{
+					{Name: "lookup", Description: "Looks up", InputSchema: map[string]any{"type": "object"}, Metadata: map[string]any{"strict": true}},
+					nil,
+					{Description: "without name"},
+				},
+				ToolChoice: ai.ToolChoiceRequired,
+			},
+			want: `{"model":"gpt","input":` + input + `,"tool_choice":"required","tools":[
+				{"type":"function","name":"lookup","description":"Looks up","parameters":{"type":"object"},"strict":true}
+			]}`,
+		},
+		{
+			name: "JSON schema output",
+			req:  &ai.ModelRequest{Messages: hi, Output: &ai.ModelOutputConfig{Format: "json", Schema: map[string]any{"type": "object"}}},
+			want: `{"model":"gpt","input":` + input + `,"text":{"format":{"type":"json_schema","name":"output","schema":{"type":"object"},"strict":true}}}`,
+		},
+		{
+			name: "JSON output",
+			req:  &ai.ModelRequest{Messages: hi, Output: &ai.ModelOutputConfig{Format: "json"}},
+			want: `{"model":"gpt","input":` + input + `,"text":{"format":{"type":"json_object"}}}`,
+		},
+		{name: "no messages", req: &ai.ModelRequest{}, err: "no messages provided"},
+		{name: "unexpected config", req: &ai.ModelRequest{Messages: hi, Config: 42}, err: "unexpected config type: int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := responseParams("gpt", tt.req)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkJSON(t, params, tt.want)
+		})
+	}
+}
+
+// response decodes a Responses API response with the given status and output
+// items.
+func response(t *testing.T, status, extra string, output ...string) *responses.Response {
+	t.Helper()
+	var res responses.Response
+	body := `{
+		"id": "resp-1", "object": "response", "model": "gpt-5-mini", "status": "` + status + `",
+		"output": [` + strings.Join(output, ",") + `],
+		"usage": {
+			"input_tokens": 20, "output_tokens": 12, "total_tokens": 32,
+			"input_tokens_details": {"cached_tokens": 4},
+			"output_tokens_details": {"reasoning_tokens": 8}
+		}` + extra + `
+	}`
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatal(err)
+	}
+	return &res
+}
+
+const outputText = `{"type":"message","id":"msg-1","role":"assistant","status":"completed","content":[{"type":"output_text","text":"Hello","annotations":[]}]}`
+
+func TestToModelResponse(t *testing.T) {
+	req := &ai.ModelRequest{}
+	res := response(t, "completed", "",
+		`{"type":"reasoning","id":"rs-1","encrypted_content":"opaque","summary":[{"type":"summary_text","text":"First"},{"type":"summary_text","text":"second"}]}`,
+		outputText,
+		`{"type":"function_call","id":"fc-1","call_id":"call_1","name":"lookup","arguments":"{\"city\":\"Berlin\"}"}`,
+	)
+	resp, err := toModelResponse(res, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Request != req || resp.FinishReason != ai.FinishReasonStop || resp.Message.Role != ai.RoleModel {
+		t.Errorf("got request %p, finish reason %q and role %q", resp.Request, resp.FinishReason, resp.Message.Role)
+	}
+	wantUsage := &ai.GenerationUsage{InputTokens: 20, OutputTokens: 12, TotalTokens: 32, ThoughtsTokens: 8, CachedContentTokens: 4}
+	if !reflect.DeepEqual(resp.Usage, wantUsage) {
+		t.Errorf("got usage %+v, want %+v", resp.Usage, wantUsage)
+	}
+	if ResponseID(resp) != "resp-1" || resp.Custom.(map[string]any)["model"] != "gpt-5-mini" {
+		t.Errorf("got custom %v", resp.Custom)
+	}
+
+	// Parts keep the order of the output items
+	content := resp.Message.Content
+	if len(content) != 3 {
+		t.Fatalf("got %d parts, want 3", len(content))
+	}
+	if r := content[0]; !r.IsReasoning() || r.Text != "First\n\nsecond" || !reflect.DeepEqual(r.Metadata["signature"], []byte("opaque")) || r.Metadata["id"] != "rs-1" {
+		t.Errorf("got reasoning part %+v", r)
+	}
+	if p := content[1]; !p.IsText() || p.Text != "Hello" {
+		t.Errorf("got text part %+v", p)
+	}
+	tr := content[2].ToolRequest
+	if !content[2].IsToolRequest() || tr.Ref != "call_1" || tr.Name != "lookup" || !reflect.DeepEqual(tr.Input, map[string]any{"city": "Berlin"}) {
+		t.Errorf("got tool request %+v", tr)
+	}
+}
+
+func TestToModelResponseFinishReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		extra   string
+		output  []string
+		reason  ai.FinishReason
+		message string
+	}{
+		{name: "completed", status: "completed", output: []string{outputText}, reason: ai.FinishReasonStop},
+		{name: "max tokens", status: "incomplete", extra: `,"incomplete_details":{"reason":"max_output_tokens"}`, reason: ai.FinishReasonLength},
+		{name: "content filter", status: "incomplete", extra: `,"incomplete_details":{"reason":"content_filter"}`, reason: ai.FinishReasonBlocked},
+		{name: "other incomplete", status: "incomplete", extra: `,"incomplete_details":{"reason":"timeout"}`, reason: ai.FinishReasonOther},
+		{name: "in progress", status: "in_progress", reason: ai.FinishReasonUnknown},
+		{
+			name:   "refusal",
+			status: "completed",
+			output: []string{`{"type":"message","id":"msg-1","role":"assistant","status":"completed","content":[{"type":"refusal","refusal":"I can't help with that."}]}`},
+			reason: ai.FinishReasonBlocked, message: "I can't help with that.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := toModelResponse(response(t, tt.status, tt.extra, tt.output...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.FinishReason != tt.reason || resp.FinishMessage != tt.message {
+				t.Errorf("got %q %q, want %q %q", resp.FinishReason, resp.FinishMessage, tt.reason, tt.message)
+			}
+		})
+	}
+}
+
+func TestToModelResponseErrors(t *testing.T) {
+	failed := response(t, "failed", `,"error":{"code":"server_error","message":"boom"}`)
+	if _, err := toModelResponse(failed, nil); err == nil || err.Error() != "response failed: server_error: boom" {
+		t.Errorf("got error %v", err)
+	}
+	badArgs := response(t, "completed", "", `{"type":"function_call","id":"fc-1","call_id":"call_1","name":"lookup","arguments":"{"}`)
+	if _, err := toModelResponse(badArgs, nil); err == nil {
+		t.Error("expected an error for invalid tool arguments")
+	}
+}
+
+func TestResponseID(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *ai.ModelResponse
+		want string
+	}{
+		{name: "nil", resp: nil},
+		{name: "without custom", resp: &ai.ModelResponse{}},
+		{name: "other custom", resp: &ai.ModelResponse{Custom: "x"}},
+		{name: "without ID", resp: &ai.ModelResponse{Custom: map[string]any{"model": "gpt"}}},
+		{name: "ID", resp: &ai.ModelResponse{Custom: map[string]any{responseIDKey: "resp-1"}}, want: "resp-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResponseID(tt.resp); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
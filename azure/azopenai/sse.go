@@ -0,0 +1,72 @@
+package azopenai
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+)
+
+// isEventStream reports whether contentType is a stream of server-sent
+// events, as returned for streamed chat completions and responses.
+func isEventStream(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == "text/event-stream"
+}
+
+// streamUsage is the token usage of a streamed response. Chat completions
+// report it in their final chunk, the Responses API in its
+// "response.completed" event.
+type streamUsage struct {
+	Usage    *usage `json:"usage"`
+	Response struct {
+		Usage *usage `json:"usage"`
+	} `json:"response"`
+}
+
+func (s streamUsage) usage() *usage {
+	if s.Usage != nil {
+		return s.Usage
+	}
+	return s.Response.Usage
+}
+
+// observeEvents returns body with the data of each server-sent event passed
+// to fn while the OpenAI SDK reads the stream. fn must not retain data. The
+// stream is not buffered, so closing body or cancelling the request aborts it
+// as usual.
+func observeEvents(body io.ReadCloser, fn func(data []byte)) io.ReadCloser {
+	return &eventObserver{ReadCloser: body, fn: fn}
+}
+
+type eventObserver struct {
+	io.ReadCloser
+	fn func(data []byte)
+	// line is the incomplete line read so far
+	line []byte
+}
+
+func (o *eventObserver) Read(p []byte) (int, error) {
+	n, err := o.ReadCloser.Read(p)
+	o.scan(p[:n])
+	return n, err
+}
+
+func (o *eventObserver) scan(b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			o.line = append(o.line, b...)
+			return
+		}
+		o.line = append(o.line, b[:i]...)
+		b = b[i+1:]
+		if data, ok := bytes.CutPrefix(bytes.TrimSuffix(o.line, []byte("\r")), []byte("data:")); ok {
+			data = bytes.TrimSpace(data)
+			if json.Valid(data) {
+				o.fn(data)
+			}
+		}
+		o.line = o.line[:0]
+	}
+}
@@ -0,0 +1,289 @@
+package azopenai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// writeEvents writes events as server-sent events and flushes them.
+func writeEvents(w http.ResponseWriter, events ...any) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, e := range events {
+		if s, ok := e.(string); ok {
+			fmt.Fprintf(w, "data: %s\n\n", s)
+			continue
+		}
+		b, _ := json.Marshal(e)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+	w.(http.Flusher).Flush()
+}
+
+func chatChunk(delta map[string]any, finishReason any) map[string]any {
+	return map[string]any{
+		"id":      "chatcmpl-1",
+		"object":  "chat.completion.chunk",
+		"created": 1,
+		"model":   "gpt",
+		"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finishReason}},
+	}
+}
+
+func usageChunk() map[string]any {
+	return map[string]any{
+		"id":      "chatcmpl-1",
+		"object":  "chat.completion.chunk",
+		"created": 1,
+		"model":   "gpt",
+		"choices": []any{},
+		"usage":   map[string]any{"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7},
+	}
+}
+
+// collect returns a stream callback that appends all chunks to chunks.
+func collect(chunks *[]*ai.ModelResponseChunk) ai.ModelStreamCallback {
+	return func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		*chunks = append(*chunks, chunk)
+		return nil
+	}
+}
+
+func chunkTexts(chunks []*ai.ModelResponseChunk) []string {
+	var texts []string
+	for _, c := range chunks {
+		texts = append(texts, c.Text())
+	}
+	return texts
+}
+
+func TestStreamChatCompletion(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeEvents(w,
+			chatChunk(map[string]any{"role": "assistant", "content": ""}, nil),
+			chatChunk(map[string]any{"content": "Hel"}, nil),
+			chatChunk(map[string]any{"content": "lo"}, nil),
+			chatChunk(map[string]any{}, "stop"),
+			usageChunk(),
+			"[DONE]",
+		)
+	})
+	g := initGenkit(t, srv, WithModel("chat", Deployment{Name: "gpt-dep"}), WithRateLimit(RateLimit{TokensPerMinute: 1000}))
+
+	var chunks []*ai.ModelResponseChunk
+	resp, err := genkit.Generate(context.Background(), g,
+		ai.WithModelName("openai/chat"),
+		ai.WithPrompt("Hi"),
+		ai.WithStreaming(collect(&chunks)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	for _, s := range chunkTexts(chunks) {
+		if s != "" {
+			texts = append(texts, s)
+		}
+	}
+	if want := []string{"Hel", "lo"}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("got chunks %q, want %q", texts, want)
+	}
+	if got := resp.Text(); got != "Hello" {
+		t.Errorf("got text %q, want Hello", got)
+	}
+	if resp.Usage == nil || resp.Usage.TotalTokens != 7 {
+		t.Errorf("got usage %+v, want 7 total tokens", resp.Usage)
+	}
+	// Usage has been requested, and corrected the rate limiter's estimate
+	if opts, _ := srv.last(t).Body["stream_options"].(map[string]any); opts["include_usage"] != true {
+		t.Errorf("got stream_options %v", opts)
+	}
+	stats := genkit.LookupPlugin(g, "openai").(*AzureOpenAI).RateLimitStats()
+	if len(stats) != 1 {
+		t.Fatalf("got rate limit stats %v, want one deployment", stats)
+	}
+	for _, s := range stats {
+		if s.Tokens != 7 {
+			t.Errorf("rate limiter counted %d tokens, want 7", s.Tokens)
+		}
+	}
+}
+
+func TestStreamGroundedChatCompletion(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeEvents(w,
+			chatChunk(map[string]any{
+				"role":    "assistant",
+				"content": "",
+				"context": map[string]any{"citations": []any{map[string]any{"content": "Springfield", "title": "Towns"}}},
+			}, nil),
+			chatChunk(map[string]any{"content": "Springfield [doc1]"}, nil),
+			chatChunk(map[string]any{}, "stop"),
+			"[DONE]",
+		)
+	})
+	g := initGenkit(t, srv, WithDeployment("gpt-dep"))
+	var chunks []*ai.ModelResponseChunk
+	resp, err := genkit.Generate(context.Background(), g,
+		ai.WithModelName("openai/gpt"),
+		ai.WithPrompt("Where?"),
+		ai.WithConfig(&ChatConfig{DataSources: []DataSource{{AzureSearch: &AzureSearchParameters{Endpoint: "https://search.example.com", IndexName: "towns"}}}}),
+		ai.WithStreaming(collect(&chunks)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*ai.Document{ai.DocumentFromText("Springfield", map[string]any{"title": "Towns"})}
+	if got := Citations(resp); !reflect.DeepEqual(got, want) {
+		t.Errorf("got citations %v, want %v", got, want)
+	}
+	if got := resp.Text(); got != "Springfield [doc1]" {
+		t.Errorf("got text %q", got)
+	}
+}
+
+func responseEvents(toolArgs string) []any {
+	return []any{
+		map[string]any{"type": "response.created", "sequence_number": 0, "response": map[string]any{"id": "resp-1", "object": "response", "status": "in_progress", "output": []any{}}},
+		map[string]any{"type": "response.reasoning_summary_text.delta", "sequence_number": 1, "item_id": "rs-1", "output_index": 0, "summary_index": 0, "delta": "Thinking"},
+		map[string]any{"type": "response.output_text.delta", "sequence_number": 2, "item_id": "msg-1", "output_index": 1, "content_index": 0, "delta": "Hel"},
+		map[string]any{"type": "response.output_text.delta", "sequence_number": 3, "item_id": "msg-1", "output_index": 1, "content_index": 0, "delta": "lo"},
+		map[string]any{"type": "response.output_item.done", "sequence_number": 4, "output_index": 2, "item": map[string]any{
+			"type": "function_call", "id": "fc-1", "call_id": "call_1", "name": "lookup", "arguments": toolArgs, "status": "completed",
+		}},
+		map[string]any{"type": "response.completed", "sequence_number": 5, "response": responseObject("Hello")},
+	}
+}
+
+func TestStreamResponse(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeEvents(w, responseEvents(`{"city":"Berlin"}`)...)
+	})
+	g := initGenkit(t, srv, WithModel("chat", Deployment{Name: "gpt-dep", API: APIResponses}))
+
+	var chunks []*ai.ModelResponseChunk
+	resp, err := genkit.Generate(context.Background(), g,
+		ai.WithModelName("openai/chat"),
+		ai.WithPrompt("Hi"),
+		ai.WithStreaming(collect(&chunks)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != 4 {
+		t.Fatalf("got %d chunks, want 4", len(chunks))
+	}
+	parts := []*ai.Part{chunks[0].Content[0], chunks[1].Content[0], chunks[2].Content[0], chunks[3].Content[0]}
+	if !parts[0].IsReasoning() || parts[0].Text != "Thinking" {
+		t.Errorf("got first part %+v, want reasoning", parts[0])
+	}
+	if !parts[1].IsText() || parts[1].Text != "Hel" || !parts[2].IsText() || parts[2].Text != "lo" {
+		t.Errorf("got text parts %+v, %+v", parts[1], parts[2])
+	}
+	if tr := parts[3].ToolRequest; tr == nil || tr.Name != "lookup" || !reflect.DeepEqual(tr.Input, map[string]any{"city": "Berlin"}) {
+		t.Errorf("got tool request %+v", tr)
+	}
+	if got := resp.Text(); got != "Hello" {
+		t.Errorf("got text %q, want Hello", got)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 5 || resp.Usage.OutputTokens != 2 || resp.Usage.TotalTokens != 7 {
+		t.Errorf("got usage %+v", resp.Usage)
+	}
+	if stream := srv.last(t).Body["stream"]; stream != true {
+		t.Errorf("got stream %v, want true", stream)
+	}
+}
+
+func TestStreamResponseInvalidToolArgs(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+		writeEvents(w, responseEvents(`{"city":`)...)
+	})
+	g := initGenkit(t, srv, WithModel("chat", Deployment{Name: "gpt-dep", API: APIResponses}))
+	var chunks []*ai.ModelResponseChunk
+	_, err := genkit.Generate(context.Background(), g,
+		ai.WithModelName("openai/chat"),
+		ai.WithPrompt("Hi"),
+		ai.WithStreaming(collect(&chunks)),
+	)
+	if err == nil {
+		t.Fatal("no error for invalid tool arguments")
+	}
+}
+
+func TestStreamCanceled(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		first any
+	}{
+		{
+			name:  "chat completions",
+			opts:  []Option{WithModel("chat", Deployment{Name: "gpt-dep"}), WithRateLimit(RateLimit{TokensPerMinute: 1000})},
+			first: chatChunk(map[string]any{"role": "assistant", "content": "Hel"}, nil),
+		},
+		{
+			name:  "responses",
+			opts:  []Option{WithModel("chat", Deployment{Name: "gpt-dep", API: APIResponses}), WithRateLimit(RateLimit{TokensPerMinute: 1000})},
+			first: responseEvents("{}")[2],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t)
+			// closed is closed once the client disconnected
+			closed := make(chan struct{})
+			srv.setHandler(func(w http.ResponseWriter, r *http.Request, body map[string]any) {
+				defer close(closed)
+				writeEvents(w, tt.first)
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			})
+			g := initGenkit(t, srv, tt.opts...)
+			goroutines := runtime.NumGoroutine()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var chunks []*ai.ModelResponseChunk
+			_, err := genkit.Generate(ctx, g,
+				ai.WithModelName("openai/chat"),
+				ai.WithPrompt("Hi"),
+				ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+					chunks = append(chunks, chunk)
+					cancel()
+					return nil
+				}),
+			)
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("got error %v, want context.Canceled", err)
+			}
+			if len(chunks) != 1 || chunks[0].Text() != "Hel" {
+				t.Errorf("got chunks %q, want the first chunk only", chunkTexts(chunks))
+			}
+
+			select {
+			case <-closed:
+			case <-time.After(time.Second):
+				t.Fatal("response body has not been closed")
+			}
+			http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+			waitFor(t, func() bool { return runtime.NumGoroutine() <= goroutines })
+		})
+	}
+}
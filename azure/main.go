@@ -3,16 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/azure/azopenai"
 )
 
 func main() {
-	ctx := context.Background()
+	// Cancelling the context (Ctrl+C) aborts a streamed response
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	baseURL := os.Getenv("AZ_OPENAI_BASE_URL")
 	apiKey := os.Getenv("AZ_OPENAI_API_KEY")
@@ -22,11 +27,13 @@ func main() {
 
 	deployment := os.Getenv("AZ_OPENAI_DEPLOYMENT")
 	modelName := "gpt-5-mini"
+	var opts []azopenai.Option
 	switch deployment {
 	case "":
 		fmt.Println("AZ_OPENAI_DEPLOYMENT not set, using Azure OpenAI v1 API")
 	default:
-		modelName = deployment
+		// Route requests for modelName to the deployment
+		opts = append(opts, azopenai.WithModel(modelName, azopenai.Deployment{Name: deployment}))
 		fmt.Printf("Using deployment %q\n", deployment)
 	}
 
@@ -36,10 +43,10 @@ func main() {
 		log.Fatalf("could not create credential: %v\n", err)
 	}
 
-	azOpenAI := &AzureOpenAI{
-		BaseURL:         baseURL,
-		Deployment:      deployment,
-		TokenCredential: cred,
+	azOpenAI, err := azopenai.New(baseURL,
+		append(opts, azopenai.WithTokenCredential(cred))...)
+	if err != nil {
+		log.Fatalf("invalid Azure OpenAI configuration: %v\n", err)
 	}
 
 	g := genkit.Init(ctx, genkit.WithPlugins(azOpenAI))
@@ -57,19 +64,25 @@ func main() {
 
 	fmt.Println("Using API key for Azure OpenAI")
 	// We already know that the API key is not empty.
-	azOpenAI = &AzureOpenAI{
-		BaseURL:    baseURL,
-		Deployment: deployment,
-		APIKey:     apiKey,
+	azOpenAI, err = azopenai.New(baseURL,
+		append(opts, azopenai.WithAPIKey(apiKey))...)
+	if err != nil {
+		log.Fatalf("invalid Azure OpenAI configuration: %v\n", err)
 	}
 	g = genkit.Init(ctx, genkit.WithPlugins(azOpenAI))
+	model = azOpenAI.Model(g, modelName)
 
-	text, err = generate(ctx, g, model, "Invent a menu for a pirate-themed restaurant")
+	// Stream the response as it is generated
+	usage, err := generateStream(ctx, g, model, "Invent a menu for a pirate-themed restaurant", os.Stdout)
 	if err != nil {
 		log.Fatalf("could not generate model response: %v\n", err)
 	}
-	log.Println(text)
-
+	// Usage is only reported if the service includes it in the stream
+	if usage == nil {
+		fmt.Println("\n\nToken usage not reported")
+		return
+	}
+	fmt.Printf("\n\nTokens used: %d (prompt: %d, completion: %d)\n", usage.TotalTokens, usage.InputTokens, usage.OutputTokens)
 }
 
 func generate(ctx context.Context, g *genkit.Genkit, model ai.Model, prompt string) (string, error) {
@@ -82,3 +95,19 @@ func generate(ctx context.Context, g *genkit.Genkit, model ai.Model, prompt stri
 	}
 	return resp.Text(), nil
 }
+
+func generateStream(ctx context.Context, g *genkit.Genkit, model ai.Model, prompt string, w io.Writer) (*ai.GenerationUsage, error) {
+	// Streamed chat completion, the final response includes the token usage
+	// if the service reported it, otherwise it is nil
+	resp, err := genkit.Generate(ctx, g,
+		ai.WithPrompt(prompt),
+		ai.WithModel(model),
+		ai.WithStreaming(func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+			_, err := io.WriteString(w, chunk.Text())
+			return err
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Usage, nil
+}
@@ -0,0 +1,241 @@
+// Package chunking splits long texts, such as episode scripts, into chunks
+// that are embedded and stored as separate rows of a vector store.
+//
+// Splitting strategies implement langchaingo's textsplitter.TextSplitter, so
+// any of its splitters can be used with Split as well.
+package chunking
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// Splitting strategies supported by New.
+const (
+	// StrategyFixed splits texts into chunks of Size characters.
+	StrategyFixed = "fixed"
+	// StrategySentence packs whole sentences into chunks of at most Size
+	// characters.
+	StrategySentence = "sentence"
+	// StrategyRecursive splits texts at paragraphs, lines and words until
+	// chunks have less than Size characters.
+	StrategyRecursive = "recursive"
+	// StrategyToken splits texts into chunks of Size tokens. The tokenizer's
+	// encoding is downloaded on first use.
+	StrategyToken = "token"
+)
+
+// Strategies lists the names of all strategies supported by New.
+var Strategies = []string{StrategyFixed, StrategySentence, StrategyRecursive, StrategyToken}
+
+// defaultEncoding is the tokenizer encoding of OpenAI's text-embedding-3
+// models.
+const defaultEncoding = "cl100k_base"
+
+// Options configures a splitting strategy.
+type Options struct {
+	// Strategy is one of Strategies. Defaults to StrategyRecursive.
+	Strategy string
+	// Size is the maximum size of a chunk in characters, or in tokens for
+	// StrategyToken.
+	Size int
+	// Overlap is the number of characters (or tokens) shared by consecutive
+	// chunks. It must be less than Size.
+	Overlap int
+	// Encoding is the tokenizer encoding used by StrategyToken. Defaults to
+	// "cl100k_base".
+	Encoding string
+}
+
+// New returns the splitter for opts.
+func New(opts Options) (textsplitter.TextSplitter, error) {
+	if opts.Size <= 0 {
+		return nil, fmt.Errorf("chunking: size must be positive, got %d", opts.Size)
+	}
+	if opts.Overlap < 0 || opts.Overlap >= opts.Size {
+		return nil, fmt.Errorf("chunking: overlap must be between 0 and %d, got %d", opts.Size-1, opts.Overlap)
+	}
+	switch opts.Strategy {
+	case StrategyFixed:
+		return Fixed{Size: opts.Size, Overlap: opts.Overlap}, nil
+	case StrategySentence:
+		return Sentence{Size: opts.Size, Overlap: opts.Overlap}, nil
+	case StrategyRecursive, "":
+		return textsplitter.NewRecursiveCharacter(
+			textsplitter.WithChunkSize(opts.Size),
+			textsplitter.WithChunkOverlap(opts.Overlap),
+			textsplitter.WithLenFunc(utf8.RuneCountInString)), nil
+	case StrategyToken:
+		encoding := opts.Encoding
+		if encoding == "" {
+			encoding = defaultEncoding
+		}
+		return textsplitter.NewTokenSplitter(
+			textsplitter.WithChunkSize(opts.Size),
+			textsplitter.WithChunkOverlap(opts.Overlap),
+			textsplitter.WithEncodingName(encoding)), nil
+	default:
+		return nil, fmt.Errorf("chunking: unknown strategy %q, must be one of %s", opts.Strategy, strings.Join(Strategies, ", "))
+	}
+}
+
+// Chunk is a chunk of a text.
+type Chunk struct {
+	// Index is the chunk's ordinal within the text, starting at 0.
+	Index int
+	Text  string
+	// Start and End are the byte offsets of the chunk in the text. They are
+	// -1 if the splitter changed the chunk's text so that it can't be
+	// located. Chunks of StrategyToken may split a multi-byte character, so
+	// their Text isn't necessarily valid UTF-8.
+	Start int
+	End   int
+}
+
+// Split splits text with s and locates the chunks in text. Empty chunks are
+// skipped.
+func Split(s textsplitter.TextSplitter, text string) ([]Chunk, error) {
+	texts, err := s.SplitText(text)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]Chunk, 0, len(texts))
+	// Chunks are returned in order, but may overlap. A chunk that extends the
+	// previous one starts at the same offset, e.g. "a b" after "a".
+	prev := Chunk{Start: -1}
+	for _, t := range texts {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+		c := Chunk{Index: len(chunks), Text: t, Start: -1, End: -1}
+		if prev.Start >= 0 && len(t) > len(prev.Text) && strings.HasPrefix(text[prev.Start:], t) {
+			c.Start = prev.Start
+		} else if i := strings.Index(text[prev.Start+1:], t); i >= 0 {
+			c.Start = prev.Start + 1 + i
+		}
+		if c.Start >= 0 {
+			c.End = c.Start + len(t)
+			prev = c
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+// Fixed splits texts into chunks of Size characters, where consecutive chunks
+// share Overlap characters.
+type Fixed struct {
+	Size    int
+	Overlap int
+}
+
+// SplitText implements textsplitter.TextSplitter.
+func (f Fixed) SplitText(text string) ([]string, error) {
+	if f.Size <= 0 || f.Overlap < 0 || f.Overlap >= f.Size {
+		return nil, errors.New("chunking: invalid size or overlap")
+	}
+	// Byte offset of each character, plus the end of text
+	offsets := make([]int, 0, len(text)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+	n := len(offsets) - 1
+
+	var chunks []string
+	for start := 0; start < n; start += f.Size - f.Overlap {
+		end := min(start+f.Size, n)
+		chunks = append(chunks, text[offsets[start]:offsets[end]])
+		if end == n {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// Sentence packs whole sentences into chunks of at most Size characters.
+// Consecutive chunks share the trailing sentences of the previous chunk that
+// fit into Overlap characters. Sentences longer than Size become chunks of
+// their own.
+type Sentence struct {
+	Size    int
+	Overlap int
+}
+
+// SplitText implements textsplitter.TextSplitter.
+func (s Sentence) SplitText(text string) ([]string, error) {
+	if s.Size <= 0 || s.Overlap < 0 || s.Overlap >= s.Size {
+		return nil, errors.New("chunking: invalid size or overlap")
+	}
+	sentences := sentences(text)
+	length := func(from, to int) int {
+		return utf8.RuneCountInString(text[sentences[from][0]:sentences[to-1][1]])
+	}
+
+	var chunks []string
+	for first := 0; first < len(sentences); {
+		last := first + 1
+		for last < len(sentences) && length(first, last+1) <= s.Size {
+			last++
+		}
+		chunks = append(chunks, text[sentences[first][0]:sentences[last-1][1]])
+		if last == len(sentences) {
+			break
+		}
+		// Start the next chunk with the sentences that fit into Overlap, but
+		// always make progress
+		next := last
+		for next-1 > first && length(next-1, last) <= s.Overlap {
+			next--
+		}
+		first = next
+	}
+	return chunks, nil
+}
+
+// sentences returns the start and end offsets of the sentences in text,
+// without surrounding whitespace. Sentences end with ".", "!" or "?"
+// followed by whitespace, or with a blank line.
+func sentences(text string) [][2]int {
+	var spans [][2]int
+	add := func(start, end int) {
+		for start < end && isSpace(text[start]) {
+			start++
+		}
+		for end > start && isSpace(text[end-1]) {
+			end--
+		}
+		if start < end {
+			spans = append(spans, [2]int{start, end})
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '.' || c == '!' || c == '?':
+			// Include closing quotes and repeated punctuation
+			j := i + 1
+			for j < len(text) && strings.IndexByte(".!?\"')", text[j]) >= 0 {
+				j++
+			}
+			if j == len(text) || isSpace(text[j]) {
+				add(start, j)
+				start, i = j, j-1
+			}
+		case c == '\n' && strings.HasPrefix(strings.TrimLeft(text[i+1:], " \t\r"), "\n"):
+			add(start, i)
+			start = i + 1
+		}
+	}
+	add(start, len(text))
+	return spans
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
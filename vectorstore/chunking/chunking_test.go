@@ -0,0 +1,284 @@
+package chunking
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// byteLoader is a tokenizer vocabulary without merges, so that every byte of
+// a text is a token and no encoding needs to be downloaded.
+type byteLoader struct{}
+
+func (byteLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for b := range 256 {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	return ranks, nil
+}
+
+func init() {
+	tiktoken.SetBpeLoader(byteLoader{})
+}
+
+// checkChunks checks that chunks have at most size characters, are numbered
+// in order, and that their offsets locate them in text.
+func checkChunks(t *testing.T, text string, chunks []Chunk, size int) {
+	t.Helper()
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has index %d", i, c.Index)
+		}
+		if n := utf8.RuneCountInString(c.Text); n > size {
+			t.Errorf("chunk %q has %d characters, want at most %d", c.Text, n, size)
+		}
+		if c.Start < 0 || c.End > len(text) || text[c.Start:c.End] != c.Text {
+			t.Errorf("chunk %q has offsets %d:%d", c.Text, c.Start, c.End)
+		}
+		if i > 0 && c.Start < chunks[i-1].Start {
+			t.Errorf("chunk %q starts at %d, before chunk %q", c.Text, c.Start, chunks[i-1].Text)
+		}
+	}
+}
+
+func texts(chunks []Chunk) []string {
+	var s []string
+	for _, c := range chunks {
+		s = append(s, c.Text)
+	}
+	return s
+}
+
+func split(t *testing.T, opts Options, text string) []Chunk {
+	t.Helper()
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := Split(s, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func TestFixed(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		size    int
+		overlap int
+		want    []string
+	}{
+		{name: "without overlap", text: "abcdefgh", size: 3, want: []string{"abc", "def", "gh"}},
+		{name: "overlap", text: "abcdefgh", size: 4, overlap: 2, want: []string{"abcd", "cdef", "efgh"}},
+		{name: "shorter than size", text: "abc", size: 10, want: []string{"abc"}},
+		{name: "multi-byte", text: "héllo wörld", size: 4, overlap: 1, want: []string{"héll", "lo w", "wörl", "ld"}},
+		{name: "empty", text: "", size: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := split(t, Options{Strategy: StrategyFixed, Size: tt.size, Overlap: tt.overlap}, tt.text)
+			if got := texts(chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			checkChunks(t, tt.text, chunks, tt.size)
+		})
+	}
+}
+
+func TestSentence(t *testing.T) {
+	const text = "Pierre arrives. Natasha waits!  Who is he?\n\nA new scene"
+	tests := []struct {
+		name    string
+		text    string
+		size    int
+		overlap int
+		want    []string
+	}{
+		{
+			name: "packed",
+			text: text,
+			size: 30,
+			want: []string{"Pierre arrives. Natasha waits!", "Who is he?\n\nA new scene"},
+		},
+		{
+			name:    "overlap",
+			text:    text,
+			size:    30,
+			overlap: 15,
+			want:    []string{"Pierre arrives. Natasha waits!", "Natasha waits!  Who is he?", "Who is he?\n\nA new scene"},
+		},
+		{
+			name: "long sentence",
+			text: "Hi. This sentence is longer than the size. Bye.",
+			size: 10,
+			want: []string{"Hi.", "This sentence is longer than the size.", "Bye."},
+		},
+		{
+			name: "quotes and abbreviations",
+			text: `He said "Go!" She left... 3.5 hours later.`,
+			size: 15,
+			want: []string{`He said "Go!"`, "She left...", "3.5 hours later."},
+		},
+		{
+			name: "multi-byte",
+			text: "Ça va? Très bien. Merci à vous.",
+			size: 17,
+			want: []string{"Ça va? Très bien.", "Merci à vous."},
+		},
+		{name: "empty", text: "", size: 10},
+		{name: "whitespace", text: " \n\n ", size: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := split(t, Options{Strategy: StrategySentence, Size: tt.size, Overlap: tt.overlap}, tt.text)
+			if got := texts(chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			// Sentences longer than size are kept whole
+			checkChunks(t, tt.text, chunks, max(tt.size, 38))
+		})
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	text := "Première scène. Pierre entre.\n\nDeuxième scène.\nNatasha répond à Pierre et sourit longuement."
+	for _, opts := range []Options{
+		{Size: 20},
+		{Strategy: StrategyRecursive, Size: 20, Overlap: 8},
+		{Strategy: StrategyRecursive, Size: 200},
+	} {
+		chunks := split(t, opts, text)
+		checkChunks(t, text, chunks, opts.Size)
+		// Every word of text is in a chunk
+		joined := strings.Join(texts(chunks), " ")
+		for _, w := range strings.Fields(text) {
+			if !strings.Contains(joined, w) {
+				t.Errorf("%+v: word %q is missing from %q", opts, w, texts(chunks))
+			}
+		}
+		if opts.Size == 200 && len(chunks) != 1 {
+			t.Errorf("%+v: got %q, want a single chunk", opts, texts(chunks))
+		}
+	}
+	if chunks := split(t, Options{Size: 20}, ""); len(chunks) != 0 {
+		t.Errorf("got %q for empty text", texts(chunks))
+	}
+}
+
+func TestToken(t *testing.T) {
+	// Every byte is a token. langchaingo's splitter ends with a chunk of the
+	// last Overlap tokens.
+	chunks := split(t, Options{Strategy: StrategyToken, Size: 4, Overlap: 1}, "abcdefghij")
+	if got, want := texts(chunks), []string{"abcd", "defg", "ghij", "j"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	checkChunks(t, "abcdefghij", chunks, 4)
+
+	// Chunks may split a multi-byte character, but are still located
+	const text = "aéb"
+	chunks = split(t, Options{Strategy: StrategyToken, Size: 2}, text)
+	want := []Chunk{
+		{Index: 0, Text: "a\xc3", Start: 0, End: 2},
+		{Index: 1, Text: "\xa9b", Start: 2, End: 4},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("got %+v, want %+v", chunks, want)
+	}
+	checkChunks(t, text, chunks, 2)
+
+	if chunks := split(t, Options{Strategy: StrategyToken, Size: 4}, ""); len(chunks) != 0 {
+		t.Errorf("got %q for empty text", texts(chunks))
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want textsplitter.TextSplitter
+		err  string
+	}{
+		{name: "fixed", opts: Options{Strategy: StrategyFixed, Size: 10, Overlap: 2}, want: Fixed{Size: 10, Overlap: 2}},
+		{name: "sentence", opts: Options{Strategy: StrategySentence, Size: 10}, want: Sentence{Size: 10}},
+		{name: "unknown strategy", opts: Options{Strategy: "words", Size: 10}, err: `chunking: unknown strategy "words", must be one of fixed, sentence, recursive, token`},
+		{name: "zero size", opts: Options{Strategy: StrategyFixed}, err: "chunking: size must be positive, got 0"},
+		{name: "overlap equals size", opts: Options{Size: 10, Overlap: 10}, err: "chunking: overlap must be between 0 and 9, got 10"},
+		{name: "negative overlap", opts: Options{Size: 10, Overlap: -1}, err: "chunking: overlap must be between 0 and 9, got -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.opts)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// The recursive splitter is the default
+	for _, strategy := range []string{"", StrategyRecursive} {
+		s, err := New(Options{Strategy: strategy, Size: 10})
+		if _, ok := s.(textsplitter.RecursiveCharacter); err != nil || !ok {
+			t.Errorf("strategy %q: got %T, %v, want a recursive splitter", strategy, s, err)
+		}
+	}
+	if s, err := New(Options{Strategy: StrategyToken, Size: 10}); err != nil || s.(textsplitter.TokenSplitter).EncodingName != defaultEncoding {
+		t.Errorf("got %#v, %v, want a token splitter with encoding %s", s, err, defaultEncoding)
+	}
+}
+
+func TestSplitterErrors(t *testing.T) {
+	// Splitters that aren't created by New validate their options
+	for _, s := range []textsplitter.TextSplitter{Fixed{}, Fixed{Size: 2, Overlap: 2}, Sentence{Size: 2, Overlap: -1}} {
+		if _, err := Split(s, "text"); err == nil {
+			t.Errorf("%#v: got no error", s)
+		}
+	}
+}
+
+func TestSplitLocatesOverlappingChunks(t *testing.T) {
+	// The recursive splitter returns chunks that start where the previous
+	// chunk starts
+	const text = "aaaa  bbbb cccc dddd"
+	chunks := split(t, Options{Size: 9, Overlap: 4}, text)
+	want := []Chunk{
+		{Index: 0, Text: "aaaa", Start: 0, End: 4},
+		{Index: 1, Text: "bbbb", Start: 6, End: 10},
+		{Index: 2, Text: "bbbb cccc", Start: 6, End: 15},
+		{Index: 3, Text: "cccc dddd", Start: 11, End: 20},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("got %+v, want %+v", chunks, want)
+	}
+}
+
+func TestSplitLocatesRepeatedChunks(t *testing.T) {
+	const text = "ab ab ab"
+	chunks, err := Split(Fixed{Size: 3}, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var starts []int
+	for _, c := range chunks {
+		starts = append(starts, c.Start)
+	}
+	if want := []int{0, 3, 6}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("got starts %v, want %v", starts, want)
+	}
+	checkChunks(t, text, chunks, 3)
+}
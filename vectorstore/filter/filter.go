@@ -0,0 +1,308 @@
+// Package filter compiles typed metadata filters into parameterized SQL
+// conditions for the SQL vector store samples.
+//
+// Field names are checked against the columns configured in a Compiler, and
+// all values and JSON keys are passed as query parameters, so filters can be
+// taken from untrusted input such as a retriever's request options.
+package filter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Op is a comparison operator.
+type Op string
+
+// Supported operators.
+const (
+	OpEq  Op = "eq"
+	OpNe  Op = "ne"
+	OpLt  Op = "lt"
+	OpLte Op = "lte"
+	OpGt  Op = "gt"
+	OpGte Op = "gte"
+	// OpIn matches any of Values.
+	OpIn Op = "in"
+	// OpBetween matches values between Values[0] and Values[1], inclusive.
+	OpBetween Op = "between"
+	// OpAnd and OpOr match if all or any of Conditions match. They have no
+	// Field.
+	OpAnd Op = "and"
+	OpOr  Op = "or"
+)
+
+// maxDepth limits the nesting of OpAnd and OpOr conditions.
+const maxDepth = 8
+
+var comparisons = map[Op]string{
+	OpEq:  "=",
+	OpNe:  "<>",
+	OpLt:  "<",
+	OpLte: "<=",
+	OpGt:  ">",
+	OpGte: ">=",
+}
+
+// Condition compares a column or a key of a JSON metadata column with one or
+// more values, or combines nested conditions.
+type Condition struct {
+	// Field is the name of a column, or "<json column>.<key>" for a key of
+	// the JSON metadata column, e.g. "metadata.genre".
+	Field string `json:"field"`
+	Op    Op     `json:"op"`
+	// Value is the operand of comparisons.
+	Value any `json:"value,omitempty"`
+	// Values are the operands of OpIn and OpBetween.
+	Values []any `json:"values,omitempty"`
+	// Conditions are the operands of OpAnd and OpOr.
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// Filter is a list of conditions that must all match.
+type Filter []Condition
+
+// Eq returns a condition that matches if field equals v.
+func Eq(field string, v any) Condition {
+	return Condition{Field: field, Op: OpEq, Value: v}
+}
+
+// In returns a condition that matches if field equals any of vs.
+func In(field string, vs ...any) Condition {
+	return Condition{Field: field, Op: OpIn, Values: vs}
+}
+
+// Between returns a condition that matches if field is between from and to,
+// inclusive.
+func Between(field string, from, to any) Condition {
+	return Condition{Field: field, Op: OpBetween, Values: []any{from, to}}
+}
+
+// And returns a condition that matches if all of conds match.
+func And(conds ...Condition) Condition {
+	return Condition{Op: OpAnd, Conditions: conds}
+}
+
+// Or returns a condition that matches if any of conds match.
+func Or(conds ...Condition) Condition {
+	return Condition{Op: OpOr, Conditions: conds}
+}
+
+// Dialect renders the database-specific parts of a condition.
+type Dialect interface {
+	// Param returns the placeholder of the nth query parameter, starting at
+	// 1, and the argument to pass for value v.
+	Param(n int, v any) (placeholder string, arg any)
+	// JSONValue returns an expression for the value of a key in a JSON
+	// column, where key is the placeholder of the key. If numeric is true,
+	// the value is cast to a number, and compared as text otherwise.
+	JSONValue(column, key string, numeric bool) string
+	// JSONKey returns the query parameter for a JSON key.
+	JSONKey(key string) string
+}
+
+// Postgres is the dialect of PostgreSQL, for JSONB metadata columns.
+var Postgres Dialect = postgres{}
+
+type postgres struct{}
+
+func (postgres) Param(n int, v any) (string, any) {
+	return fmt.Sprintf("$%d", n), v
+}
+
+func (postgres) JSONValue(column, key string, numeric bool) string {
+	if numeric {
+		return fmt.Sprintf("(%s->>%s::text)::numeric", column, key)
+	}
+	return fmt.Sprintf("%s->>%s::text", column, key)
+}
+
+func (postgres) JSONKey(key string) string {
+	return key
+}
+
+// SQLServer is the dialect of Azure SQL and SQL Server, for JSON metadata
+// stored in NVARCHAR columns. Parameters are named "@filter<n>".
+var SQLServer Dialect = sqlServer{}
+
+type sqlServer struct{}
+
+func (sqlServer) Param(n int, v any) (string, any) {
+	name := fmt.Sprintf("filter%d", n)
+	return "@" + name, sql.Named(name, v)
+}
+
+func (sqlServer) JSONValue(column, key string, numeric bool) string {
+	if numeric {
+		return fmt.Sprintf("TRY_CAST(JSON_VALUE(%s, %s) AS FLOAT)", column, key)
+	}
+	return fmt.Sprintf("JSON_VALUE(%s, %s)", column, key)
+}
+
+func (sqlServer) JSONKey(key string) string {
+	// A JSON path with the key in quotes, so it may contain any character
+	return `$."` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(key) + `"`
+}
+
+// SQLite is the dialect of SQLite, for JSON metadata stored in TEXT columns.
+// Parameters are numbered, e.g. "?1".
+var SQLite Dialect = sqlite{}
+
+type sqlite struct{}
+
+func (sqlite) Param(n int, v any) (string, any) {
+	return fmt.Sprintf("?%d", n), v
+}
+
+func (sqlite) JSONValue(column, key string, numeric bool) string {
+	if numeric {
+		// Values that aren't numbers are NULL, as with SQL Server's TRY_CAST
+		return fmt.Sprintf("CASE WHEN json_type(%[1]s, %[2]s) IN ('integer', 'real') THEN json_extract(%[1]s, %[2]s) END", column, key)
+	}
+	// json_extract returns booleans as 1 and 0, but they are compared as
+	// "true" and "false" as in the other dialects
+	return fmt.Sprintf("CASE json_type(%[1]s, %[2]s) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(%[1]s, %[2]s) AS TEXT) END", column, key)
+}
+
+func (sqlite) JSONKey(key string) string {
+	return sqlServer{}.JSONKey(key)
+}
+
+// Compiler compiles filters for a table.
+type Compiler struct {
+	Dialect Dialect
+	// Columns are the names of the columns conditions may refer to.
+	Columns []string
+	// JSONColumn is the name of the table's JSON metadata column, if any.
+	JSONColumn string
+}
+
+// Compile returns the SQL condition for f, and args with the arguments of the
+// condition appended. Placeholders are numbered after the existing args. The
+// condition is empty if f is empty.
+func (c Compiler) Compile(f Filter, args []any) (string, []any, error) {
+	return c.join(f, " AND ", args, "filter", 0)
+}
+
+// join compiles conds joined by sep. Errors are reported with the path of the
+// failed condition, e.g. "filter[1].conditions[0]".
+func (c Compiler) join(conds []Condition, sep string, args []any, path string, depth int) (string, []any, error) {
+	ss := make([]string, 0, len(conds))
+	for i, cond := range conds {
+		var (
+			s   string
+			err error
+		)
+		s, args, err = c.compile(cond, args, fmt.Sprintf("%s[%d]", path, i), depth)
+		if err != nil {
+			return "", nil, err
+		}
+		ss = append(ss, s)
+	}
+	return strings.Join(ss, sep), args, nil
+}
+
+func (c Compiler) compile(cond Condition, args []any, path string, depth int) (string, []any, error) {
+	if cond.Op != OpAnd && cond.Op != OpOr {
+		s, args, err := c.compare(cond, args)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return s, args, nil
+	}
+	switch {
+	case cond.Field != "":
+		return "", nil, fmt.Errorf("%s: %q takes conditions, not field %q", path, cond.Op, cond.Field)
+	case len(cond.Conditions) == 0:
+		return "", nil, fmt.Errorf("%s: %q needs at least one condition", path, cond.Op)
+	case depth >= maxDepth:
+		return "", nil, fmt.Errorf("%s: conditions are nested deeper than %d levels", path, maxDepth)
+	}
+	s, args, err := c.join(cond.Conditions, " "+strings.ToUpper(string(cond.Op))+" ", args, path+".conditions", depth+1)
+	if err != nil {
+		return "", nil, err
+	}
+	return "(" + s + ")", args, nil
+}
+
+// compare compiles a comparison of a field.
+func (c Compiler) compare(cond Condition, args []any) (string, []any, error) {
+	// JSON values may be converted to text below
+	operands := slices.Clone(cond.Values)
+	switch cond.Op {
+	case OpIn:
+		if len(operands) == 0 {
+			return "", nil, fmt.Errorf("%s: %q needs at least one value", cond.Field, cond.Op)
+		}
+	case OpBetween:
+		if len(operands) != 2 {
+			return "", nil, fmt.Errorf("%s: %q needs two values, got %d", cond.Field, cond.Op, len(operands))
+		}
+	default:
+		if _, ok := comparisons[cond.Op]; !ok {
+			return "", nil, fmt.Errorf("%s: unknown operator %q", cond.Field, cond.Op)
+		}
+		operands = []any{cond.Value}
+	}
+	numeric, err := checkOperands(operands)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", cond.Field, err)
+	}
+
+	param := func(v any) string {
+		p, arg := c.Dialect.Param(len(args)+1, v)
+		args = append(args, arg)
+		return p
+	}
+	var lhs string
+	switch column, key, isJSON := strings.Cut(cond.Field, "."); {
+	case isJSON && c.JSONColumn != "" && column == c.JSONColumn && key != "":
+		lhs = c.Dialect.JSONValue(c.JSONColumn, param(c.Dialect.JSONKey(key)), numeric)
+		// JSON values are compared as text unless they are numbers
+		if !numeric {
+			for i, v := range operands {
+				operands[i] = fmt.Sprint(v)
+			}
+		}
+	case !isJSON && slices.Contains(c.Columns, cond.Field):
+		lhs = cond.Field
+	default:
+		return "", nil, fmt.Errorf("unknown field %q", cond.Field)
+	}
+
+	switch cond.Op {
+	case OpIn:
+		ps := make([]string, len(operands))
+		for i, v := range operands {
+			ps[i] = param(v)
+		}
+		return fmt.Sprintf("%s IN (%s)", lhs, strings.Join(ps, ", ")), args, nil
+	case OpBetween:
+		return fmt.Sprintf("%s BETWEEN %s AND %s", lhs, param(operands[0]), param(operands[1])), args, nil
+	default:
+		return fmt.Sprintf("%s %s %s", lhs, comparisons[cond.Op], param(operands[0])), args, nil
+	}
+}
+
+// checkOperands checks that all operands are strings, booleans, or numbers,
+// and reports whether they are all numbers. Numbers decoded from JSON are
+// float64.
+func checkOperands(operands []any) (numeric bool, err error) {
+	numbers := 0
+	for _, v := range operands {
+		switch v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			numbers++
+		case string, bool:
+		default:
+			return false, fmt.Errorf("unsupported value %v of type %T", v, v)
+		}
+	}
+	if numbers > 0 && numbers < len(operands) {
+		return false, errors.New("values must be all numbers or no numbers")
+	}
+	return numbers > 0, nil
+}
@@ -0,0 +1,238 @@
+package filter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// compiled is the expected result of compiling a filter.
+type compiled struct {
+	sql  string
+	args []any
+}
+
+var columns = []string{"show_id", "season_number"}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		// args are the arguments before the filter's
+		args                      []any
+		postgres, sqlServer, lite compiled
+	}{
+		{
+			name:      "empty",
+			args:      []any{"vector"},
+			postgres:  compiled{"", []any{"vector"}},
+			sqlServer: compiled{"", []any{"vector"}},
+			lite:      compiled{"", []any{"vector"}},
+		},
+		{
+			name:      "column",
+			filter:    Filter{Eq("show_id", "La Vie")},
+			args:      []any{"vector"},
+			postgres:  compiled{"show_id = $2", []any{"vector", "La Vie"}},
+			sqlServer: compiled{"show_id = @filter2", []any{"vector", sql.Named("filter2", "La Vie")}},
+			lite:      compiled{"show_id = ?2", []any{"vector", "La Vie"}},
+		},
+		{
+			name: "comparisons",
+			filter: Filter{
+				{Field: "season_number", Op: OpNe, Value: 1},
+				{Field: "season_number", Op: OpLt, Value: 5},
+				{Field: "season_number", Op: OpLte, Value: 4},
+				{Field: "season_number", Op: OpGt, Value: 0},
+				{Field: "season_number", Op: OpGte, Value: 2},
+			},
+			postgres:  compiled{"season_number <> $1 AND season_number < $2 AND season_number <= $3 AND season_number > $4 AND season_number >= $5", []any{1, 5, 4, 0, 2}},
+			sqlServer: compiled{"season_number <> @filter1 AND season_number < @filter2 AND season_number <= @filter3 AND season_number > @filter4 AND season_number >= @filter5", []any{sql.Named("filter1", 1), sql.Named("filter2", 5), sql.Named("filter3", 4), sql.Named("filter4", 0), sql.Named("filter5", 2)}},
+			lite:      compiled{"season_number <> ?1 AND season_number < ?2 AND season_number <= ?3 AND season_number > ?4 AND season_number >= ?5", []any{1, 5, 4, 0, 2}},
+		},
+		{
+			name:      "in and between",
+			filter:    Filter{In("show_id", "La Vie", "Springfield"), Between("season_number", 1, 2)},
+			postgres:  compiled{"show_id IN ($1, $2) AND season_number BETWEEN $3 AND $4", []any{"La Vie", "Springfield", 1, 2}},
+			sqlServer: compiled{"show_id IN (@filter1, @filter2) AND season_number BETWEEN @filter3 AND @filter4", []any{sql.Named("filter1", "La Vie"), sql.Named("filter2", "Springfield"), sql.Named("filter3", 1), sql.Named("filter4", 2)}},
+			lite:      compiled{"show_id IN (?1, ?2) AND season_number BETWEEN ?3 AND ?4", []any{"La Vie", "Springfield", 1, 2}},
+		},
+		{
+			name:     "JSON text",
+			filter:   Filter{In("metadata.genre", "drama", "comedy")},
+			postgres: compiled{"metadata->>$1::text IN ($2, $3)", []any{"genre", "drama", "comedy"}},
+			sqlServer: compiled{"JSON_VALUE(metadata, @filter1) IN (@filter2, @filter3)", []any{
+				sql.Named("filter1", `$."genre"`), sql.Named("filter2", "drama"), sql.Named("filter3", "comedy"),
+			}},
+			lite: compiled{
+				`CASE json_type(metadata, ?1) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(metadata, ?1) AS TEXT) END IN (?2, ?3)`,
+				[]any{`$."genre"`, "drama", "comedy"},
+			},
+		},
+		{
+			name:     "JSON boolean",
+			filter:   Filter{Eq("metadata.finale", true)},
+			postgres: compiled{"metadata->>$1::text = $2", []any{"finale", "true"}},
+			sqlServer: compiled{"JSON_VALUE(metadata, @filter1) = @filter2", []any{
+				sql.Named("filter1", `$."finale"`), sql.Named("filter2", "true"),
+			}},
+			lite: compiled{
+				`CASE json_type(metadata, ?1) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(metadata, ?1) AS TEXT) END = ?2`,
+				[]any{`$."finale"`, "true"},
+			},
+		},
+		{
+			name:     "JSON number",
+			filter:   Filter{{Field: "metadata.rating", Op: OpGte, Value: 7.5}},
+			postgres: compiled{"(metadata->>$1::text)::numeric >= $2", []any{"rating", 7.5}},
+			sqlServer: compiled{"TRY_CAST(JSON_VALUE(metadata, @filter1) AS FLOAT) >= @filter2", []any{
+				sql.Named("filter1", `$."rating"`), sql.Named("filter2", 7.5),
+			}},
+			lite: compiled{
+				`CASE WHEN json_type(metadata, ?1) IN ('integer', 'real') THEN json_extract(metadata, ?1) END >= ?2`,
+				[]any{`$."rating"`, 7.5},
+			},
+		},
+		{
+			// Keys are parameters, never part of the SQL
+			name:     "JSON key with quotes",
+			filter:   Filter{Eq(`metadata.x' OR 1=1; DROP TABLE shows; --"`, "a")},
+			postgres: compiled{"metadata->>$1::text = $2", []any{`x' OR 1=1; DROP TABLE shows; --"`, "a"}},
+			sqlServer: compiled{"JSON_VALUE(metadata, @filter1) = @filter2", []any{
+				sql.Named("filter1", `$."x' OR 1=1; DROP TABLE shows; --\""`), sql.Named("filter2", "a"),
+			}},
+			lite: compiled{
+				`CASE json_type(metadata, ?1) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(metadata, ?1) AS TEXT) END = ?2`,
+				[]any{`$."x' OR 1=1; DROP TABLE shows; --\""`, "a"},
+			},
+		},
+		{
+			name: "nested",
+			filter: Filter{
+				Eq("show_id", "La Vie"),
+				Or(
+					Eq("season_number", 1),
+					And(Between("season_number", 3, 4), In("metadata.genre", "drama", "comedy")),
+				),
+			},
+			args:     []any{"vector"},
+			postgres: compiled{"show_id = $2 AND (season_number = $3 OR (season_number BETWEEN $4 AND $5 AND metadata->>$6::text IN ($7, $8)))", []any{"vector", "La Vie", 1, 3, 4, "genre", "drama", "comedy"}},
+			sqlServer: compiled{"show_id = @filter2 AND (season_number = @filter3 OR (season_number BETWEEN @filter4 AND @filter5 AND JSON_VALUE(metadata, @filter6) IN (@filter7, @filter8)))", []any{
+				"vector", sql.Named("filter2", "La Vie"), sql.Named("filter3", 1), sql.Named("filter4", 3), sql.Named("filter5", 4),
+				sql.Named("filter6", `$."genre"`), sql.Named("filter7", "drama"), sql.Named("filter8", "comedy"),
+			}},
+			lite: compiled{
+				`show_id = ?2 AND (season_number = ?3 OR (season_number BETWEEN ?4 AND ?5 AND CASE json_type(metadata, ?6) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(metadata, ?6) AS TEXT) END IN (?7, ?8)))`,
+				[]any{"vector", "La Vie", 1, 3, 4, `$."genre"`, "drama", "comedy"},
+			},
+		},
+		{
+			name:      "single nested condition",
+			filter:    Filter{Or(Eq("show_id", "La Vie"))},
+			postgres:  compiled{"(show_id = $1)", []any{"La Vie"}},
+			sqlServer: compiled{"(show_id = @filter1)", []any{sql.Named("filter1", "La Vie")}},
+			lite:      compiled{"(show_id = ?1)", []any{"La Vie"}},
+		},
+	}
+	dialects := []struct {
+		name    string
+		dialect Dialect
+		want    func(i int) compiled
+	}{
+		{"Postgres", Postgres, func(i int) compiled { return tests[i].postgres }},
+		{"SQLServer", SQLServer, func(i int) compiled { return tests[i].sqlServer }},
+		{"SQLite", SQLite, func(i int) compiled { return tests[i].lite }},
+	}
+	for i, tt := range tests {
+		for _, d := range dialects {
+			t.Run(tt.name+"/"+d.name, func(t *testing.T) {
+				c := Compiler{Dialect: d.dialect, Columns: columns, JSONColumn: "metadata"}
+				got, args, err := c.Compile(tt.filter, tt.args)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := d.want(i)
+				if got != want.sql {
+					t.Errorf("got SQL\n%s\nwant\n%s", got, want.sql)
+				}
+				if !reflect.DeepEqual(args, want.args) {
+					t.Errorf("got args %#v, want %#v", args, want.args)
+				}
+			})
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	deep := Eq("show_id", "La Vie")
+	for range maxDepth + 1 {
+		deep = Or(deep)
+	}
+	tests := []struct {
+		name       string
+		filter     Filter
+		jsonColumn string
+		err        string
+	}{
+		{name: "unknown column", filter: Filter{Eq("title", "x")}, err: `filter[0]: unknown field "title"`},
+		{name: "column with SQL", filter: Filter{Eq("show_id; DROP TABLE shows", "x")}, err: `unknown field "show_id; DROP TABLE shows"`},
+		{name: "column with key", filter: Filter{Eq("show_id.x", "x")}, err: `unknown field "show_id.x"`},
+		{name: "no JSON column", filter: Filter{Eq("metadata.genre", "x")}, err: `unknown field "metadata.genre"`},
+		{name: "other JSON column", filter: Filter{Eq("content.genre", "x")}, jsonColumn: "metadata", err: `unknown field "content.genre"`},
+		{name: "empty key", filter: Filter{Eq("metadata.", "x")}, jsonColumn: "metadata", err: `unknown field "metadata."`},
+		{name: "JSON column", filter: Filter{Eq("metadata", "x")}, jsonColumn: "metadata", err: `unknown field "metadata"`},
+		{name: "unknown operator", filter: Filter{{Field: "show_id", Op: "like", Value: "x%"}}, err: `show_id: unknown operator "like"`},
+		{name: "operator with SQL", filter: Filter{{Field: "show_id", Op: "= 1 OR 1 =", Value: "x"}}, err: `unknown operator`},
+		{name: "empty in", filter: Filter{In("show_id")}, err: `"in" needs at least one value`},
+		{name: "between with one value", filter: Filter{{Field: "season_number", Op: OpBetween, Values: []any{1}}}, err: `"between" needs two values, got 1`},
+		{name: "mixed values", filter: Filter{In("season_number", 1, "2")}, err: "values must be all numbers or no numbers"},
+		{name: "unsupported value", filter: Filter{Eq("show_id", map[string]any{"$gt": ""})}, err: "unsupported value"},
+		{name: "nil value", filter: Filter{Eq("show_id", nil)}, err: "unsupported value"},
+		{name: "second condition", filter: Filter{Eq("show_id", "x"), Eq("title", "x")}, err: `filter[1]: unknown field "title"`},
+		{name: "nested", filter: Filter{Eq("show_id", "x"), Or(Eq("show_id", "y"), And(Eq("title", "x")))}, err: `filter[1].conditions[1].conditions[0]: unknown field "title"`},
+		{name: "empty or", filter: Filter{Or()}, err: `filter[0]: "or" needs at least one condition`},
+		{name: "and with field", filter: Filter{{Field: "show_id", Op: OpAnd, Conditions: []Condition{Eq("show_id", "x")}}}, err: `"and" takes conditions, not field "show_id"`},
+		{name: "too deep", filter: Filter{deep}, err: "nested deeper than 8 levels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Compiler{Dialect: Postgres, Columns: columns, JSONColumn: tt.jsonColumn}
+			s, args, err := c.Compile(tt.filter, []any{"vector"})
+			if err == nil {
+				t.Fatalf("got %q, %v, want error %q", s, args, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompileJSON(t *testing.T) {
+	// Filters passed in the Developer UI are decoded from JSON
+	var f Filter
+	err := json.Unmarshal([]byte(`[
+		{"field": "season_number", "op": "between", "values": [1, 2]},
+		{"op": "or", "conditions": [
+			{"field": "metadata.genre", "op": "eq", "value": "drama"},
+			{"field": "metadata.rating", "op": "gt", "value": 8}
+		]}
+	]`), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Compiler{Dialect: Postgres, Columns: columns, JSONColumn: "metadata"}
+	got, args, err := c.Compile(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "season_number BETWEEN $1 AND $2 AND (metadata->>$3::text = $4 OR (metadata->>$5::text)::numeric > $6)"
+	if got != want {
+		t.Errorf("got SQL\n%s\nwant\n%s", got, want)
+	}
+	if wantArgs := []any{1.0, 2.0, "genre", "drama", "rating", 8.0}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("got args %#v, want %#v", args, wantArgs)
+	}
+}
@@ -0,0 +1,178 @@
+// Package indexing embeds documents in batches and writes the embeddings to a
+// vector store, with bounded concurrency and retries of failed batches.
+package indexing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Defaults of Options.
+const (
+	DefaultBatchSize   = 100
+	DefaultConcurrency = 4
+	DefaultAttempts    = 3
+	DefaultBackoff     = 2 * time.Second
+)
+
+// Embedder returns the embeddings of a batch of documents, in order.
+type Embedder func(ctx context.Context, docs []*ai.Document) ([][]float32, error)
+
+// Writer stores the embeddings of a batch of documents, e.g. in a single
+// transaction.
+type Writer func(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error
+
+// GenkitEmbedder returns an Embedder that embeds documents with e.
+func GenkitEmbedder(g *genkit.Genkit, e ai.Embedder) Embedder {
+	return func(ctx context.Context, docs []*ai.Document) ([][]float32, error) {
+		res, err := genkit.Embed(ctx, g, ai.WithEmbedder(e), ai.WithDocs(docs...))
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Embeddings) != len(docs) {
+			return nil, fmt.Errorf("embedder returned %d embeddings for %d documents", len(res.Embeddings), len(docs))
+		}
+		embeddings := make([][]float32, len(res.Embeddings))
+		for i, e := range res.Embeddings {
+			embeddings[i] = e.Embedding
+		}
+		return embeddings, nil
+	}
+}
+
+// Options configures Run.
+type Options struct {
+	// BatchSize is the number of documents per embedding request and write.
+	// Azure OpenAI accepts up to 2048 inputs per request. Defaults to
+	// DefaultBatchSize.
+	BatchSize int
+	// Concurrency is the maximum number of batches processed at the same
+	// time. Defaults to DefaultConcurrency.
+	Concurrency int
+	// Attempts is the number of times a batch is tried before it fails.
+	// Defaults to DefaultAttempts.
+	Attempts int
+	// Backoff is the delay before the first retry of a batch. It is doubled
+	// for every further retry. Defaults to DefaultBackoff.
+	Backoff time.Duration
+	// Progress is called after each batch has been written or has failed.
+	Progress func(Progress)
+}
+
+// Progress is the progress of Run.
+type Progress struct {
+	// Done and Failed are the numbers of documents written and failed so far.
+	Done   int
+	Failed int
+	Total  int
+}
+
+// BatchError is the error of a failed batch.
+type BatchError struct {
+	// Docs are the documents of the batch.
+	Docs []*ai.Document
+	Err  error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch of %d documents failed: %v", len(e.Docs), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Stats are the results of Run.
+type Stats struct {
+	Updated int
+	Failed  int
+	// Errors are the errors of all failed batches.
+	Errors []*BatchError
+}
+
+// Run splits docs into batches, and embeds and writes up to
+// opts.Concurrency batches at the same time. A batch that fails is retried
+// with exponential backoff, and counted as failed once all attempts have
+// failed; Run continues with the remaining batches. Run only returns an error
+// if ctx is cancelled.
+func Run(ctx context.Context, docs []*ai.Document, embed Embedder, write Writer, opts Options) (Stats, error) {
+	opts = opts.withDefaults()
+
+	var (
+		mu    sync.Mutex
+		stats Stats
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, opts.Concurrency)
+	for start := 0; start < len(docs); start += opts.BatchSize {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		batch := docs[start:min(start+opts.BatchSize, len(docs))]
+		wg.Go(func() {
+			defer func() { <-sem }()
+			err := runBatch(ctx, batch, embed, write, opts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				stats.Failed += len(batch)
+				stats.Errors = append(stats.Errors, &BatchError{Docs: batch, Err: err})
+			} else {
+				stats.Updated += len(batch)
+			}
+			if opts.Progress != nil {
+				opts.Progress(Progress{Done: stats.Updated, Failed: stats.Failed, Total: len(docs)})
+			}
+		})
+	}
+	wg.Wait()
+	return stats, ctx.Err()
+}
+
+func runBatch(ctx context.Context, docs []*ai.Document, embed Embedder, write Writer, opts Options) error {
+	backoff := opts.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var embeddings [][]float32
+		if embeddings, err = embed(ctx, docs); err == nil {
+			if err = write(ctx, docs, embeddings); err == nil {
+				return nil
+			}
+		}
+		if attempt == opts.Attempts || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (o Options) withDefaults() Options {
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultConcurrency
+	}
+	if o.Attempts <= 0 {
+		o.Attempts = DefaultAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = DefaultBackoff
+	}
+	return o
+}
@@ -0,0 +1,274 @@
+package indexing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+func testDocs(n int) []*ai.Document {
+	docs := make([]*ai.Document, n)
+	for i := range docs {
+		docs[i] = ai.DocumentFromText(fmt.Sprint(i), nil)
+	}
+	return docs
+}
+
+// fakeEmbedder embeds a document as its text's length. Its first failFirst
+// calls fail, and all calls with a batch that contains the text failDoc.
+type fakeEmbedder struct {
+	failFirst int
+	failDoc   string
+
+	mu    sync.Mutex
+	calls map[string][]time.Time
+}
+
+var errEmbed = errors.New("embedding failed")
+
+func (f *fakeEmbedder) embed(ctx context.Context, docs []*ai.Document) ([][]float32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = map[string][]time.Time{}
+	}
+	// Calls are recorded by the batch's first document
+	first := docs[0].Content[0].Text
+	f.calls[first] = append(f.calls[first], time.Now())
+	if f.failFirst > 0 {
+		f.failFirst--
+		return nil, errEmbed
+	}
+	embs := make([][]float32, len(docs))
+	for i, doc := range docs {
+		if doc.Content[0].Text == f.failDoc {
+			return nil, errEmbed
+		}
+		embs[i] = []float32{float32(len(doc.Content[0].Text))}
+	}
+	return embs, nil
+}
+
+// attempts returns the number of calls with each batch.
+func (f *fakeEmbedder) attempts() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := map[string]int{}
+	for first, calls := range f.calls {
+		n[first] = len(calls)
+	}
+	return n
+}
+
+// store records written documents.
+type store struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (s *store) write(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+	if len(embeddings) != len(docs) {
+		return fmt.Errorf("got %d embeddings for %d documents", len(embeddings), len(docs))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range docs {
+		s.texts = append(s.texts, doc.Content[0].Text)
+	}
+	return nil
+}
+
+func (s *store) written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	texts := slices.Clone(s.texts)
+	slices.Sort(texts)
+	return texts
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		docs     int
+		embedder *fakeEmbedder
+		attempts int
+		updated  int
+		failed   []string
+		calls    map[string]int
+	}{
+		{
+			name:     "success",
+			docs:     5,
+			embedder: &fakeEmbedder{},
+			updated:  5,
+			calls:    map[string]int{"0": 1, "2": 1, "4": 1},
+		},
+		{
+			name:     "transient failures",
+			docs:     2,
+			embedder: &fakeEmbedder{failFirst: 2},
+			updated:  2,
+			calls:    map[string]int{"0": 3},
+		},
+		{
+			name:     "too many failures",
+			docs:     2,
+			embedder: &fakeEmbedder{failFirst: 3},
+			failed:   []string{"0", "1"},
+			calls:    map[string]int{"0": 3},
+		},
+		{
+			name:     "permanent failure",
+			docs:     5,
+			embedder: &fakeEmbedder{failDoc: "3"},
+			updated:  3,
+			failed:   []string{"2", "3"},
+			calls:    map[string]int{"0": 1, "2": 3, "4": 1},
+		},
+		{
+			name:     "single attempt",
+			docs:     4,
+			embedder: &fakeEmbedder{failDoc: "1"},
+			attempts: 1,
+			updated:  2,
+			failed:   []string{"0", "1"},
+			calls:    map[string]int{"0": 1, "2": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &store{}
+			var progress []Progress
+			stats, err := Run(context.Background(), testDocs(tt.docs), tt.embedder.embed, s.write, Options{
+				BatchSize:   2,
+				Concurrency: 1,
+				Attempts:    tt.attempts,
+				Backoff:     time.Millisecond,
+				Progress:    func(p Progress) { progress = append(progress, p) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.embedder.attempts(); !reflect.DeepEqual(got, tt.calls) {
+				t.Errorf("got calls per batch %v, want %v", got, tt.calls)
+			}
+			if stats.Updated != tt.updated || stats.Failed != len(tt.failed) {
+				t.Errorf("got %d updated and %d failed, want %d and %d", stats.Updated, stats.Failed, tt.updated, len(tt.failed))
+			}
+			var failed []string
+			for _, e := range stats.Errors {
+				if !errors.Is(e, errEmbed) {
+					t.Errorf("got batch error %v, want %v", e, errEmbed)
+				}
+				for _, doc := range e.Docs {
+					failed = append(failed, doc.Content[0].Text)
+				}
+			}
+			if !slices.Equal(failed, tt.failed) {
+				t.Errorf("got failed documents %q, want %q", failed, tt.failed)
+			}
+			// Successful batches are written despite failed ones
+			if got := s.written(); len(got) != tt.updated {
+				t.Errorf("wrote %q, want %d documents", got, tt.updated)
+			}
+
+			batches := (tt.docs + 1) / 2
+			if len(progress) != batches {
+				t.Fatalf("got %d progress reports, want one per batch", len(progress))
+			}
+			want := Progress{Done: tt.updated, Failed: len(tt.failed), Total: tt.docs}
+			if last := progress[len(progress)-1]; last != want {
+				t.Errorf("got last progress %+v, want %+v", last, want)
+			}
+			for i := 1; i < len(progress); i++ {
+				if progress[i].Done+progress[i].Failed <= progress[i-1].Done+progress[i-1].Failed {
+					t.Errorf("progress %+v doesn't advance", progress)
+				}
+			}
+		})
+	}
+}
+
+func TestRunBackoff(t *testing.T) {
+	const backoff = 20 * time.Millisecond
+	e := &fakeEmbedder{failFirst: 2}
+	s := &store{}
+	if _, err := Run(context.Background(), testDocs(1), e.embed, s.write, Options{Attempts: 3, Backoff: backoff}); err != nil {
+		t.Fatal(err)
+	}
+	calls := e.calls["0"]
+	if len(calls) != 3 {
+		t.Fatalf("got %d calls, want 3", len(calls))
+	}
+	// The delay doubles with every retry
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if d := calls[i+1].Sub(calls[i]); d < want {
+			t.Errorf("retry %d after %v, want at least %v", i+1, d, want)
+		}
+	}
+}
+
+func TestRunWriteFailure(t *testing.T) {
+	writes := 0
+	write := func(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+		writes++
+		if writes == 1 {
+			return errors.New("deadlock")
+		}
+		return nil
+	}
+	e := &fakeEmbedder{}
+	stats, err := Run(context.Background(), testDocs(1), e.embed, write, Options{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A failed write embeds the batch again
+	if stats.Updated != 1 || writes != 2 || e.attempts()["0"] != 2 {
+		t.Errorf("got stats %+v after %d writes and %d embeddings", stats, writes, e.attempts()["0"])
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &fakeEmbedder{failFirst: 1}
+	embed := func(ctx context.Context, docs []*ai.Document) ([][]float32, error) {
+		defer cancel()
+		return e.embed(ctx, docs)
+	}
+	s := &store{}
+	start := time.Now()
+	stats, err := Run(ctx, testDocs(4), embed, s.write, Options{BatchSize: 2, Concurrency: 1, Attempts: 3, Backoff: time.Minute})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	// The retry is abandoned instead of waiting for the backoff, and no
+	// further batches are started
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Run returned after %v", d)
+	}
+	if got := e.attempts(); !reflect.DeepEqual(got, map[string]int{"0": 1}) {
+		t.Errorf("got calls per batch %v, want one call", got)
+	}
+	if stats.Updated != 0 || stats.Failed != 2 || len(stats.Errors) != 1 || !errors.Is(stats.Errors[0], errEmbed) {
+		t.Errorf("got stats %+v, want the first batch failed", stats)
+	}
+}
+
+func TestOptionsDefaults(t *testing.T) {
+	got := Options{}.withDefaults()
+	want := Options{BatchSize: DefaultBatchSize, Concurrency: DefaultConcurrency, Attempts: DefaultAttempts, Backoff: DefaultBackoff}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	set := Options{BatchSize: 1, Concurrency: 2, Attempts: 3, Backoff: time.Second}
+	if got := set.withDefaults(); !reflect.DeepEqual(got, set) {
+		t.Errorf("got %+v, want %+v", got, set)
+	}
+}
@@ -0,0 +1,223 @@
+// Package migrate applies versioned SQL migrations, which the SQL vector store
+// samples embed with go:embed.
+//
+// A migration is a file named <version>_<name>.sql, e.g. 001_create_embeddings.sql.
+// Migrations are text/template templates, so that they can depend on
+// settings such as the number of dimensions of the configured embedder.
+// Applied migrations are recorded in the schema_migrations table.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// noTransaction is the first line of migrations that must not run in a
+// transaction, e.g. because they create a full-text index in SQL Server.
+const noTransaction = "-- migrate:no-transaction"
+
+// Migration is a versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	// SQL is the migration's template.
+	SQL string
+	// Transaction reports whether the migration runs in a transaction.
+	Transaction bool
+}
+
+// Dialect holds the database-specific statements used to record migrations.
+type Dialect struct {
+	// CreateTable creates the schema_migrations table if it doesn't exist.
+	CreateTable string
+	// Insert records a migration, with placeholders for its version and
+	// name.
+	Insert string
+	// Separator is a line that separates batches of statements that are
+	// executed one at a time, e.g. "GO" for SQL Server. Empty if the whole
+	// migration is executed at once.
+	Separator string
+}
+
+// Postgres is the dialect of PostgreSQL.
+var Postgres = Dialect{
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	Insert: `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+}
+
+// SQLServer is the dialect of Azure SQL and SQL Server.
+var SQLServer = Dialect{
+	CreateTable: `IF OBJECT_ID('schema_migrations', 'U') IS NULL
+		CREATE TABLE schema_migrations (
+			version INT PRIMARY KEY,
+			name NVARCHAR(255) NOT NULL,
+			applied_at DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME()
+		)`,
+	Insert:    `INSERT INTO schema_migrations (version, name) VALUES (@p1, @p2)`,
+	Separator: "GO",
+}
+
+// SQLite is the dialect of SQLite. Its driver must execute multiple
+// statements at once.
+var SQLite = Dialect{
+	CreateTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	Insert: `INSERT INTO schema_migrations (version, name) VALUES (?1, ?2)`,
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// Load returns the migrations in dir of fsys, ordered by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version:     version,
+			Name:        m[2],
+			SQL:         string(b),
+			Transaction: !strings.HasPrefix(string(b), noTransaction),
+		})
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migrate: duplicate version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Version returns the version of the latest migration applied to db, or 0 if
+// none has been applied.
+func Version(ctx context.Context, db *sql.DB, d Dialect) (int, error) {
+	if _, err := db.ExecContext(ctx, d.CreateTable); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	return int(version.Int64), err
+}
+
+// Up applies all migrations newer than the database's version in order, and
+// returns the migrations that were applied. Each migration is rendered with
+// data. A migration that runs in a transaction is recorded in the same
+// transaction, so that it is either applied and recorded, or neither.
+func Up(ctx context.Context, db *sql.DB, d Dialect, migrations []Migration, data any) ([]Migration, error) {
+	current, err := Version(ctx, db, d)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := apply(ctx, db, d, m, data); err != nil {
+			return applied, fmt.Errorf("migrate: %03d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func apply(ctx context.Context, db *sql.DB, d Dialect, m Migration, data any) error {
+	stmts, err := render(m, data)
+	if err != nil {
+		return err
+	}
+
+	var ex execer = db
+	var tx *sql.Tx
+	if m.Transaction {
+		if tx, err = db.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		ex = tx
+	}
+	for _, batch := range split(stmts, d.Separator) {
+		if _, err := ex.ExecContext(ctx, batch); err != nil {
+			return err
+		}
+	}
+	if _, err := ex.ExecContext(ctx, d.Insert, m.Version, m.Name); err != nil {
+		return err
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// render executes the template of m with data. Fields that data doesn't have
+// are an error.
+func render(m Migration, data any) (string, error) {
+	tmpl, err := template.New(m.Name).Option("missingkey=error").Parse(m.SQL)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// split splits sql into batches at lines that only contain separator. Empty
+// batches are skipped.
+func split(sql, separator string) []string {
+	if separator == "" {
+		return []string{sql}
+	}
+	var batches []string
+	var batch strings.Builder
+	flush := func() {
+		if strings.TrimSpace(batch.String()) != "" {
+			batches = append(batches, batch.String())
+		}
+		batch.Reset()
+	}
+	for line := range strings.Lines(sql) {
+		if strings.EqualFold(strings.TrimSpace(line), separator) {
+			flush()
+			continue
+		}
+		batch.WriteString(line)
+	}
+	flush()
+	return batches
+}
@@ -0,0 +1,268 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/010_add_index.sql":           {Data: []byte(noTransaction + "\nCREATE INDEX ix ON embeddings (chunk);\n")},
+		"migrations/002_add_genre.sql":           {Data: []byte("ALTER TABLE embeddings ADD genre TEXT;\n")},
+		"migrations/001_create_embeddings.sql":   {Data: []byte("CREATE TABLE embeddings (embedding vector({{.Dimensions}}));\n")},
+		"migrations/README.md":                   {Data: []byte("ignored")},
+		"migrations/003_invalid-name.sql":        {Data: []byte("ignored")},
+		"migrations/nested/004_nested.sql":       {Data: []byte("ignored")},
+		"migrations/005_late_directive.sql":      {Data: []byte("SELECT 1;\n" + noTransaction + "\n")},
+		"other/006_other_directory.sql":          {Data: []byte("ignored")},
+		"migrations/011_directive_and_space.sql": {Data: []byte(" " + noTransaction + "\n")},
+	}
+	got, err := Load(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "create_embeddings", SQL: "CREATE TABLE embeddings (embedding vector({{.Dimensions}}));\n", Transaction: true},
+		{Version: 2, Name: "add_genre", SQL: "ALTER TABLE embeddings ADD genre TEXT;\n", Transaction: true},
+		// The directive must be the first line
+		{Version: 5, Name: "late_directive", SQL: "SELECT 1;\n" + noTransaction + "\n", Transaction: true},
+		{Version: 10, Name: "add_index", SQL: noTransaction + "\nCREATE INDEX ix ON embeddings (chunk);\n"},
+		{Version: 11, Name: "directive_and_space", SQL: " " + noTransaction + "\n", Transaction: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_create.sql":  {Data: []byte("SELECT 1;")},
+		"migrations/0001_again.sql":  {Data: []byte("SELECT 2;")},
+		"empty/README.md":            {Data: []byte("no migrations")},
+		"migrations/002_another.sql": {Data: []byte("SELECT 3;")},
+	}
+	if _, err := Load(fsys, "migrations"); err == nil || err.Error() != "migrate: duplicate version 1" {
+		t.Errorf("got error %v, want duplicate version 1", err)
+	}
+	if _, err := Load(fsys, "missing"); err == nil {
+		t.Error("got no error for a missing directory")
+	}
+	if got, err := Load(fsys, "empty"); err != nil || len(got) != 0 {
+		t.Errorf("got %+v, %v, want no migrations", got, err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	m := Migration{Name: "create", SQL: "CREATE TABLE t (v vector({{.Dimensions}}));"}
+	got, err := render(m, struct{ Dimensions int }{1536})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "CREATE TABLE t (v vector(1536));"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Migrations without actions don't need data
+	if got, err := render(Migration{Name: "plain", SQL: "SELECT 1;"}, nil); err != nil || got != "SELECT 1;" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := render(m, map[string]any{"Dims": 3}); err == nil {
+		t.Error("got no error for a missing key")
+	}
+	if _, err := render(m, struct{ Dims int }{3}); err == nil {
+		t.Error("got no error for a missing field")
+	}
+	if _, err := render(Migration{Name: "invalid", SQL: "SELECT {{.Dimensions"}, nil); err == nil {
+		t.Error("got no error for an invalid template")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name      string
+		sql       string
+		separator string
+		want      []string
+	}{
+		{
+			name: "without separator",
+			sql:  "CREATE TABLE a (id INT);\nGO\nCREATE TABLE b (id INT);\n",
+			want: []string{"CREATE TABLE a (id INT);\nGO\nCREATE TABLE b (id INT);\n"},
+		},
+		{
+			name:      "batches",
+			sql:       "CREATE TABLE a (id INT);\nGO\nCREATE FULLTEXT CATALOG c;\nCREATE TABLE b (id INT);\nGO\n",
+			separator: "GO",
+			want:      []string{"CREATE TABLE a (id INT);\n", "CREATE FULLTEXT CATALOG c;\nCREATE TABLE b (id INT);\n"},
+		},
+		{
+			// The separator is case-insensitive and may be indented, but must be
+			// on a line of its own
+			name:      "separator lines",
+			sql:       "SELECT 1;\n  go  \r\nSELECT 'GO';\nGOTO x;\nGo\nSELECT 2;",
+			separator: "GO",
+			want:      []string{"SELECT 1;\n", "SELECT 'GO';\nGOTO x;\n", "SELECT 2;"},
+		},
+		{
+			name:      "empty batches",
+			sql:       "GO\n\nGO\nSELECT 1;\nGO\n   \nGO",
+			separator: "GO",
+			want:      []string{"SELECT 1;\n"},
+		},
+		{name: "empty", sql: "", separator: "GO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := split(tt.sql, tt.separator); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDB is a database/sql driver that records executed statements, and
+// whose schema_migrations table holds version.
+type fakeDB struct {
+	mu      sync.Mutex
+	version int64
+	// log is the executed statements, and "BEGIN", "COMMIT" and "ROLLBACK"
+	log []string
+	// fail is a statement that fails, if not empty
+	fail string
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (f *fakeDB) record(s string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.log = append(f.log, s)
+}
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                        { return nil }
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.f.record("BEGIN")
+	return c, nil
+}
+
+func (c fakeConn) Commit() error {
+	c.f.record("COMMIT")
+	return nil
+}
+
+func (c fakeConn) Rollback() error {
+	c.f.record("ROLLBACK")
+	return nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	query = strings.TrimSpace(query)
+	if query == c.f.fail {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if query == Postgres.Insert {
+		c.f.record("INSERT " + args[1].Value.(string))
+	} else if query != Postgres.CreateTable {
+		c.f.record(query)
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	return &versionRows{version: c.f.version}, nil
+}
+
+// versionRows is the result of SELECT MAX(version).
+type versionRows struct {
+	version int64
+	done    bool
+}
+
+func (r *versionRows) Columns() []string { return []string{"max"} }
+func (r *versionRows) Close() error      { return nil }
+
+func (r *versionRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	if r.version > 0 {
+		dest[0] = r.version
+	}
+	return nil
+}
+
+func TestUp(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "create", SQL: "CREATE TABLE a;", Transaction: true},
+		{Version: 2, Name: "vector", SQL: "ALTER TABLE a ADD v vector({{.Dimensions}});", Transaction: true},
+		{Version: 3, Name: "index", SQL: "CREATE INDEX ix ON a;"},
+	}
+	data := struct{ Dimensions int }{3}
+	f := &fakeDB{version: 1}
+	db := sql.OpenDB(f)
+	defer db.Close()
+
+	applied, err := Up(context.Background(), db, Postgres, migrations, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, migrations[1:]) {
+		t.Errorf("applied %+v, want %+v", applied, migrations[1:])
+	}
+	// Migrations are recorded in their transaction, if they have one
+	want := []string{
+		"BEGIN", "ALTER TABLE a ADD v vector(3);", "INSERT vector", "COMMIT",
+		"CREATE INDEX ix ON a;", "INSERT index",
+	}
+	if !reflect.DeepEqual(f.log, want) {
+		t.Errorf("got statements %q, want %q", f.log, want)
+	}
+
+	// A failed migration is rolled back, and later migrations aren't applied
+	f = &fakeDB{fail: "ALTER TABLE a ADD v vector(3);"}
+	db = sql.OpenDB(f)
+	defer db.Close()
+	applied, err = Up(context.Background(), db, Postgres, migrations, data)
+	if err == nil || !strings.HasPrefix(err.Error(), "migrate: 002_vector: ") {
+		t.Errorf("got error %v, want the failed migration", err)
+	}
+	if !reflect.DeepEqual(applied, migrations[:1]) {
+		t.Errorf("applied %+v, want %+v", applied, migrations[:1])
+	}
+	want = []string{"BEGIN", "CREATE TABLE a;", "INSERT create", "COMMIT", "BEGIN", "ROLLBACK"}
+	if !reflect.DeepEqual(f.log, want) {
+		t.Errorf("got statements %q, want %q", f.log, want)
+	}
+}
+
+func TestUpBatches(t *testing.T) {
+	f := &fakeDB{}
+	db := sql.OpenDB(f)
+	defer db.Close()
+	d := Postgres
+	d.Separator = "GO"
+	m := Migration{Version: 1, Name: "fulltext", SQL: "CREATE FULLTEXT CATALOG c;\nGO\nCREATE FULLTEXT INDEX ON a;\n"}
+	if _, err := Up(context.Background(), db, d, []Migration{m}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Each batch is executed on its own
+	want := []string{"CREATE FULLTEXT CATALOG c;", "CREATE FULLTEXT INDEX ON a;", "INSERT fulltext"}
+	if !reflect.DeepEqual(f.log, want) {
+		t.Errorf("got statements %q, want %q", f.log, want)
+	}
+}
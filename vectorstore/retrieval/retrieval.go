@@ -0,0 +1,132 @@
+// Package retrieval defines retriever options shared by the SQL vector store
+// samples: the number of documents to return, the distance metric, a minimum
+// score, and hybrid search.
+package retrieval
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Metric is a distance metric used to compare embeddings.
+type Metric string
+
+// Supported distance metrics.
+const (
+	// MetricCosine is the cosine distance.
+	MetricCosine Metric = "cosine"
+	// MetricL2 is the Euclidean distance.
+	MetricL2 Metric = "l2"
+	// MetricDot is the negative inner product, as computed by pgvector's <#>
+	// operator and Azure SQL's VECTOR_DISTANCE('dot', ...).
+	MetricDot Metric = "dot"
+)
+
+// Metrics lists all supported distance metrics.
+var Metrics = []Metric{MetricCosine, MetricL2, MetricDot}
+
+// Defaults of Options and Hybrid.
+const (
+	DefaultK            = 2
+	DefaultMetric       = MetricDot
+	DefaultRankConstant = 60
+	DefaultCandidates   = 20
+)
+
+// Options configures a retriever.
+type Options struct {
+	// K is the maximum number of documents to return. Defaults to DefaultK.
+	K int `json:"k,omitempty"`
+	// Metric is the distance metric used to rank documents. Defaults to
+	// DefaultMetric.
+	Metric Metric `json:"metric,omitempty"`
+	// MinScore excludes documents whose score is less than MinScore, see
+	// Score. With Hybrid, it applies to the fused score.
+	MinScore *float64 `json:"minScore,omitempty"`
+	// Hybrid, if set, combines vector search with full-text search.
+	Hybrid *Hybrid `json:"hybrid,omitempty"`
+}
+
+// Hybrid configures hybrid search. The results of vector search and
+// full-text search are fused with reciprocal rank fusion (RRF): a document's
+// score is the sum of weight / (RankConstant + rank) over both searches, where
+// rank is the document's rank in a search starting at 1. Documents found by
+// only one search get no score from the other.
+type Hybrid struct {
+	// VectorWeight and TextWeight weight the ranks of vector search and
+	// full-text search. They default to 1.
+	VectorWeight float64 `json:"vectorWeight,omitempty"`
+	TextWeight   float64 `json:"textWeight,omitempty"`
+	// RankConstant dampens the influence of top ranks. Defaults to
+	// DefaultRankConstant.
+	RankConstant int `json:"rankConstant,omitempty"`
+	// Candidates is the number of results of each search that are fused. It
+	// is at least K, and defaults to DefaultCandidates.
+	Candidates int `json:"candidates,omitempty"`
+}
+
+// WithDefaults returns o with defaults for all unset fields, or an error if
+// o is invalid.
+func (o Options) WithDefaults() (Options, error) {
+	if o.K < 0 {
+		return o, fmt.Errorf("retrieval: k must not be negative, got %d", o.K)
+	}
+	if o.K == 0 {
+		o.K = DefaultK
+	}
+	switch o.Metric {
+	case "":
+		o.Metric = DefaultMetric
+	case MetricCosine, MetricL2, MetricDot:
+	default:
+		names := make([]string, len(Metrics))
+		for i, m := range Metrics {
+			names[i] = string(m)
+		}
+		return o, fmt.Errorf("retrieval: unknown metric %q, must be one of %s", o.Metric, strings.Join(names, ", "))
+	}
+	if o.Hybrid != nil {
+		h := *o.Hybrid
+		if h.VectorWeight < 0 || h.TextWeight < 0 || h.RankConstant < 0 || h.Candidates < 0 {
+			return o, errors.New("retrieval: hybrid weights, rank constant, and candidates must not be negative")
+		}
+		if h.VectorWeight == 0 {
+			h.VectorWeight = 1
+		}
+		if h.TextWeight == 0 {
+			h.TextWeight = 1
+		}
+		if h.RankConstant == 0 {
+			h.RankConstant = DefaultRankConstant
+		}
+		if h.Candidates == 0 {
+			h.Candidates = DefaultCandidates
+		}
+		h.Candidates = max(h.Candidates, o.K)
+		o.Hybrid = &h
+	}
+	return o, nil
+}
+
+// Score converts a distance computed with m to a score, where a higher score
+// means more similar:
+//
+//   - MetricCosine: the cosine similarity, 1 - distance, between -1 and 1
+//   - MetricL2: 1 / (1 + distance), between 0 and 1
+//   - MetricDot: the inner product, -distance
+func Score(m Metric, distance float64) float64 {
+	switch m {
+	case MetricCosine:
+		return 1 - distance
+	case MetricL2:
+		return 1 / (1 + distance)
+	default:
+		return -distance
+	}
+}
+
+// Keep reports whether a document with score is kept by o.MinScore.
+func (o Options) Keep(score float64) bool {
+	return o.MinScore == nil || score >= *o.MinScore
+}
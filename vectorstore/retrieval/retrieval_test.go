@@ -0,0 +1,131 @@
+package retrieval
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		metric   Metric
+		distance float64
+		want     float64
+	}{
+		{MetricCosine, 0, 1},
+		{MetricCosine, 0.25, 0.75},
+		{MetricCosine, 2, -1},
+		{MetricL2, 0, 1},
+		{MetricL2, 1, 0.5},
+		{MetricL2, 3, 0.25},
+		{MetricDot, -0.8, 0.8},
+		{MetricDot, 1.5, -1.5},
+		// Unknown metrics are treated as MetricDot
+		{"", -2, 2},
+	}
+	for _, tt := range tests {
+		if got := Score(tt.metric, tt.distance); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("Score(%q, %v) = %v, want %v", tt.metric, tt.distance, got, tt.want)
+		}
+	}
+}
+
+func TestKeep(t *testing.T) {
+	if o := (Options{}); !o.Keep(-100) {
+		t.Error("document dropped without MinScore")
+	}
+	minScore := 0.3
+	o := Options{MinScore: &minScore}
+	for score, want := range map[float64]bool{0.29: false, 0.3: true, 0.9: true, -1: false} {
+		if got := o.Keep(score); got != want {
+			t.Errorf("Keep(%v) = %v, want %v", score, got, want)
+		}
+	}
+	// A minimum score of 0 is set
+	zero := 0.0
+	if o := (Options{MinScore: &zero}); o.Keep(-0.1) || !o.Keep(0) {
+		t.Error("MinScore 0 isn't applied")
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want Options
+		err  string
+	}{
+		{
+			name: "defaults",
+			want: Options{K: DefaultK, Metric: DefaultMetric},
+		},
+		{
+			name: "set",
+			opts: Options{K: 5, Metric: MetricCosine},
+			want: Options{K: 5, Metric: MetricCosine},
+		},
+		{
+			name: "hybrid defaults",
+			opts: Options{Metric: MetricL2, Hybrid: &Hybrid{}},
+			want: Options{K: DefaultK, Metric: MetricL2, Hybrid: &Hybrid{VectorWeight: 1, TextWeight: 1, RankConstant: DefaultRankConstant, Candidates: DefaultCandidates}},
+		},
+		{
+			// At least K candidates are fused
+			name: "hybrid set",
+			opts: Options{K: 30, Hybrid: &Hybrid{VectorWeight: 0.5, TextWeight: 2, RankConstant: 10, Candidates: 25}},
+			want: Options{K: 30, Metric: DefaultMetric, Hybrid: &Hybrid{VectorWeight: 0.5, TextWeight: 2, RankConstant: 10, Candidates: 30}},
+		},
+		{name: "negative k", opts: Options{K: -1}, err: "retrieval: k must not be negative, got -1"},
+		{name: "unknown metric", opts: Options{Metric: "manhattan"}, err: `retrieval: unknown metric "manhattan", must be one of cosine, l2, dot`},
+		{name: "negative weight", opts: Options{Hybrid: &Hybrid{TextWeight: -1}}, err: "must not be negative"},
+		{name: "negative candidates", opts: Options{Hybrid: &Hybrid{Candidates: -1}}, err: "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.WithDefaults()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// The caller's Hybrid isn't modified
+	h := &Hybrid{}
+	if _, err := (Options{Hybrid: h}).WithDefaults(); err != nil || *h != (Hybrid{}) {
+		t.Errorf("got %+v, %v", h, err)
+	}
+}
+
+func TestOptionsJSON(t *testing.T) {
+	// Options as sent by the Developer UI
+	var got Options
+	err := json.Unmarshal([]byte(`{"k":4,"metric":"cosine","minScore":0.5,"hybrid":{"textWeight":0.5,"candidates":10}}`), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minScore := 0.5
+	want := Options{K: 4, Metric: MetricCosine, MinScore: &minScore, Hybrid: &Hybrid{TextWeight: 0.5, Candidates: 10}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Unset fields are omitted
+	b, err := json.Marshal(Options{K: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"k":2}` {
+		t.Errorf("got %s", b)
+	}
+}
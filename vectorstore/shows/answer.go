@@ -0,0 +1,199 @@
+// Package shows answers questions about TV show scripts stored in a sqlstore
+// table, which the SQL vector store samples share: it ingests episode scripts
+// as chunks, and generates answers grounded in the chunks retrieved for a
+// question.
+package shows
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+const (
+	// answerChunks is the number of chunks an answer is generated from.
+	answerChunks = 5
+	// minRelevance is the minimum score of a chunk to be passed to the model.
+	// OpenAI's embeddings are normalized, so with the dot and cosine metrics,
+	// scores are the cosine similarity of question and chunk.
+	minRelevance = 0.3
+	// refusal is the answer if no relevant chunks were retrieved.
+	refusal = "I can't answer this question from the show's scripts."
+)
+
+const answerPrompt = `You answer questions about the scripts of a TV show.
+Answer only with information from the provided script excerpts, and don't make
+up details of the show. Each excerpt is prefixed with the reference of its
+episode in square brackets, e.g. [S01E02]. List the references of all excerpts
+your answer is based on in sources. If the excerpts don't contain the answer,
+set answerable to false and explain briefly that you can't answer the question.`
+
+// Question is the input of the askQuestion flow.
+type Question struct {
+	Question string
+	Show     string
+	// Season optionally restricts the search to a season
+	Season int `json:",omitempty"`
+}
+
+// Answer is the output of the askQuestion flow.
+type Answer struct {
+	// Answer is the answer to the question, or why it can't be answered.
+	Answer string `json:"answer"`
+	// Refused is true if the question can't be answered from the retrieved
+	// chunks.
+	Refused bool `json:"refused"`
+	// Citations are the episodes the answer is based on.
+	Citations []Citation `json:"citations"`
+	// Chunks are the retrieved chunks the answer was generated from.
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Citation identifies an episode of a show.
+type Citation struct {
+	ShowID       string `json:"showId"`
+	SeasonNumber int    `json:"seasonNumber"`
+	EpisodeID    int    `json:"episodeId"`
+}
+
+// ref returns the reference of the episode in the model's prompt.
+func (c Citation) ref() string {
+	return fmt.Sprintf("S%02dE%02d", c.SeasonNumber, c.EpisodeID)
+}
+
+// Chunk is a retrieved chunk of an episode's script.
+type Chunk struct {
+	Citation
+	ChunkIndex int     `json:"chunkIndex"`
+	Score      float64 `json:"score"`
+	Text       string  `json:"text"`
+}
+
+// modelAnswer is the structured output requested from the model.
+type modelAnswer struct {
+	Answer     string   `json:"answer"`
+	Answerable bool     `json:"answerable"`
+	Sources    []string `json:"sources"`
+}
+
+// Filter returns the filter for a show and, if season is not 0, a season.
+func Filter(show string, season int) filter.Filter {
+	f := filter.Filter{filter.Eq("show_id", show)}
+	if season != 0 {
+		f = append(f, filter.Eq("season_number", season))
+	}
+	return f
+}
+
+// AnswerConfig returns the retriever config of the askQuestion flow, which
+// retrieves the chunks of a show that are relevant to a question.
+func AnswerConfig(show string, season int) sqlstore.Config {
+	minScore := minRelevance
+	return sqlstore.Config{
+		Filter:  Filter(show, season),
+		Options: retrieval.Options{K: answerChunks, MinScore: &minScore},
+	}
+}
+
+// DefineFlow defines the askQuestion flow, which retrieves the chunks of a
+// show with retriever and answers the question with model, see
+// AnswerQuestion. config returns the retriever's options for AnswerConfig, e.g.
+// a sample's config type that embeds it. If config is nil, the options are a
+// *sqlstore.Config.
+func DefineFlow(g *genkit.Genkit, retriever ai.Retriever, model ai.Model, config func(sqlstore.Config) any) *core.Flow[Question, *Answer, struct{}] {
+	if config == nil {
+		config = func(cfg sqlstore.Config) any { return &cfg }
+	}
+	return genkit.DefineFlow(g, "askQuestion", func(ctx context.Context, in Question) (*Answer, error) {
+		res, err := genkit.Retrieve(ctx, g,
+			ai.WithRetriever(retriever),
+			ai.WithConfig(config(AnswerConfig(in.Show, in.Season))),
+			ai.WithTextDocs(in.Question))
+		if err != nil {
+			return nil, err
+		}
+		return AnswerQuestion(ctx, g, model, in.Question, res.Documents)
+	})
+}
+
+// AnswerQuestion generates an answer to question that is grounded in docs,
+// the chunks returned by a shows retriever. If docs is empty, the question is
+// refused without calling the model.
+func AnswerQuestion(ctx context.Context, g *genkit.Genkit, model ai.Model, question string, docs []*ai.Document) (*Answer, error) {
+	ans := &Answer{Citations: []Citation{}, Chunks: make([]Chunk, 0, len(docs))}
+	excerpts := make([]*ai.Document, 0, len(docs))
+	byRef := map[string]Citation{}
+	for _, doc := range docs {
+		c := Chunk{
+			Citation: Citation{
+				ShowID:       fmt.Sprint(doc.Metadata["show_id"]),
+				SeasonNumber: metadataInt(doc.Metadata, "season_number"),
+				EpisodeID:    metadataInt(doc.Metadata, "episode_id"),
+			},
+			ChunkIndex: metadataInt(doc.Metadata, "chunk_index"),
+			Score:      metadataFloat(doc.Metadata, "score"),
+			Text:       doc.Content[0].Text,
+		}
+		ans.Chunks = append(ans.Chunks, c)
+		byRef[c.ref()] = c.Citation
+		// Genkit prefixes each document in the prompt with its "ref" metadata
+		meta := maps.Clone(doc.Metadata)
+		meta["ref"] = c.ref()
+		excerpts = append(excerpts, ai.DocumentFromText(c.Text, meta))
+	}
+	if len(docs) == 0 {
+		ans.Answer = refusal
+		ans.Refused = true
+		return ans, nil
+	}
+
+	out, _, err := genkit.GenerateData[modelAnswer](ctx, g,
+		ai.WithModel(model),
+		ai.WithSystem(answerPrompt),
+		ai.WithDocs(excerpts...),
+		ai.WithPrompt(question))
+	if err != nil {
+		return nil, err
+	}
+	ans.Answer = out.Answer
+	ans.Refused = !out.Answerable
+	if ans.Refused {
+		return ans, nil
+	}
+	// Only cite episodes that were retrieved, in case the model made up a source
+	for _, ref := range out.Sources {
+		if c, ok := byRef[strings.Trim(ref, "[] ")]; ok && !slices.Contains(ans.Citations, c) {
+			ans.Citations = append(ans.Citations, c)
+		}
+	}
+	return ans, nil
+}
+
+// metadataInt returns an integer metadata value, which is a float64 if the
+// metadata was decoded from JSON.
+func metadataInt(meta map[string]any, key string) int {
+	switch v := meta[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// metadataFloat returns a float metadata value.
+func metadataFloat(meta map[string]any, key string) float64 {
+	v, _ := meta[key].(float64)
+	return v
+}
@@ -0,0 +1,192 @@
+package shows
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+// fakeModel defines a model that answers with out, and records its requests.
+func fakeModel(g *genkit.Genkit, out modelAnswer, reqs *[]*ai.ModelRequest) ai.Model {
+	return genkit.DefineModel(g, "test/model", &ai.ModelOptions{
+		Supports: &ai.ModelSupports{Multiturn: true, SystemRole: true, Constrained: ai.ConstrainedSupportAll},
+	}, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		*reqs = append(*reqs, req)
+		b, _ := json.Marshal(out)
+		return &ai.ModelResponse{Message: ai.NewModelTextMessage(string(b)), FinishReason: ai.FinishReasonStop}, nil
+	})
+}
+
+// requestText returns the text of all messages of req.
+func requestText(req *ai.ModelRequest) string {
+	var b strings.Builder
+	for _, m := range req.Messages {
+		b.WriteString(m.Text())
+	}
+	return b.String()
+}
+
+func chunkDoc(text string, season, episode, index int, score float64) *ai.Document {
+	// Metadata decoded from JSON, e.g. by the Developer UI, has float64 numbers
+	return ai.DocumentFromText(text, map[string]any{
+		"show_id":       "La Vie",
+		"season_number": float64(season),
+		"episode_id":    episode,
+		"chunk_index":   int64(index),
+		"score":         score,
+	})
+}
+
+func TestAnswerQuestion(t *testing.T) {
+	ctx := context.Background()
+	g := genkit.Init(ctx)
+	var reqs []*ai.ModelRequest
+	model := fakeModel(g, modelAnswer{
+		Answer:     "In Paris.",
+		Answerable: true,
+		// S09E09 hasn't been retrieved
+		Sources: []string{"[S01E02]", "S01E02", "S09E09", " [S02E01] "},
+	}, &reqs)
+
+	docs := []*ai.Document{
+		chunkDoc("They live in Paris.", 1, 2, 0, 0.8),
+		chunkDoc("Back in Paris.", 2, 1, 3, 0.5),
+		chunkDoc("Not cited.", 2, 2, 1, 0.4),
+	}
+	got, err := AnswerQuestion(ctx, g, model, "Where do they live?", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Answer{
+		Answer: "In Paris.",
+		Citations: []Citation{
+			{ShowID: "La Vie", SeasonNumber: 1, EpisodeID: 2},
+			{ShowID: "La Vie", SeasonNumber: 2, EpisodeID: 1},
+		},
+		Chunks: []Chunk{
+			{Citation: Citation{ShowID: "La Vie", SeasonNumber: 1, EpisodeID: 2}, ChunkIndex: 0, Score: 0.8, Text: "They live in Paris."},
+			{Citation: Citation{ShowID: "La Vie", SeasonNumber: 2, EpisodeID: 1}, ChunkIndex: 3, Score: 0.5, Text: "Back in Paris."},
+			{Citation: Citation{ShowID: "La Vie", SeasonNumber: 2, EpisodeID: 2}, ChunkIndex: 1, Score: 0.4, Text: "Not cited."},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if len(reqs) != 1 {
+		t.Fatalf("got %d model requests, want 1", len(reqs))
+	}
+	text := requestText(reqs[0])
+	for _, s := range []string{"S01E02", "They live in Paris.", "S02E01", "Back in Paris.", "Where do they live?", "answerable"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("request doesn't contain %q:\n%s", s, text)
+		}
+	}
+	// The excerpts' refs don't change the retrieved documents
+	if _, ok := docs[0].Metadata["ref"]; ok {
+		t.Error("retrieved document has been modified")
+	}
+}
+
+func TestAnswerQuestionRefused(t *testing.T) {
+	ctx := context.Background()
+	g := genkit.Init(ctx)
+	var reqs []*ai.ModelRequest
+	model := fakeModel(g, modelAnswer{Answer: "The scripts don't say.", Sources: []string{"S01E02"}}, &reqs)
+
+	got, err := AnswerQuestion(ctx, g, model, "Who wrote it?", []*ai.Document{chunkDoc("They live in Paris.", 1, 2, 0, 0.8)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Refused || got.Answer != "The scripts don't say." || len(got.Citations) != 0 || len(got.Chunks) != 1 {
+		t.Errorf("got %+v, want a refusal without citations", got)
+	}
+
+	// Without relevant chunks, the model isn't asked
+	reqs = nil
+	got, err = AnswerQuestion(ctx, g, model, "Who wrote it?", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Answer{Answer: refusal, Refused: true, Citations: []Citation{}, Chunks: []Chunk{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(reqs) != 0 {
+		t.Errorf("got %d model requests, want none", len(reqs))
+	}
+}
+
+func TestAnswerConfig(t *testing.T) {
+	cfg := AnswerConfig("La Vie", 0)
+	if want := (filter.Filter{filter.Eq("show_id", "La Vie")}); !reflect.DeepEqual(cfg.Filter, want) {
+		t.Errorf("got filter %v, want %v", cfg.Filter, want)
+	}
+	if cfg.K != answerChunks || cfg.MinScore == nil || *cfg.MinScore != minRelevance {
+		t.Errorf("got options %+v", cfg.Options)
+	}
+	cfg = AnswerConfig("La Vie", 2)
+	if want := (filter.Filter{filter.Eq("show_id", "La Vie"), filter.Eq("season_number", 2)}); !reflect.DeepEqual(cfg.Filter, want) {
+		t.Errorf("got filter %v, want %v", cfg.Filter, want)
+	}
+}
+
+func TestDefineFlow(t *testing.T) {
+	// sampleConfig is a sample's retriever config, which embeds sqlstore.Config
+	type sampleConfig struct {
+		Approximate bool
+		sqlstore.Config
+	}
+	tests := []struct {
+		name   string
+		config func(sqlstore.Config) any
+		want   any
+	}{
+		{
+			name: "default",
+			want: &sqlstore.Config{Filter: Filter("La Vie", 2), Options: AnswerConfig("La Vie", 2).Options},
+		},
+		{
+			name:   "sample config",
+			config: func(cfg sqlstore.Config) any { return &sampleConfig{Config: cfg} },
+			want:   &sampleConfig{Config: AnswerConfig("La Vie", 2)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			g := genkit.Init(ctx)
+			var opts any
+			var query string
+			retriever := genkit.DefineRetriever(g, "test/shows", nil, func(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
+				opts = req.Options
+				query = req.Query.Content[0].Text
+				return &ai.RetrieverResponse{Documents: []*ai.Document{chunkDoc("They live in Paris.", 2, 1, 0, 0.8)}}, nil
+			})
+			var reqs []*ai.ModelRequest
+			model := fakeModel(g, modelAnswer{Answer: "In Paris.", Answerable: true, Sources: []string{"S02E01"}}, &reqs)
+
+			flow := DefineFlow(g, retriever, model, tt.config)
+			got, err := flow.Run(ctx, Question{Question: "Where do they live?", Show: "La Vie", Season: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(opts, tt.want) {
+				t.Errorf("got retriever options %+v, want %+v", opts, tt.want)
+			}
+			if query != "Where do they live?" {
+				t.Errorf("got query %q", query)
+			}
+			if got.Answer != "In Paris." || len(got.Citations) != 1 {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
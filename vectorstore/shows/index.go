@@ -0,0 +1,156 @@
+package shows
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/joergjo/genkit-go-samples/azure/azopenai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/indexing"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+// DefaultPollInterval is the default of BatchOptions.PollInterval.
+const DefaultPollInterval = 30 * time.Second
+
+// Index embeds docs with s.Embed and writes them with s.Write in batches,
+// see indexing.Run. Failed batches are logged.
+func Index(ctx context.Context, s *sqlstore.Store, docs []*ai.Document, opts indexing.Options) (indexing.Stats, error) {
+	stats, err := indexing.Run(ctx, docs, s.Embed, s.Write, opts)
+	logFailures(s.Table, stats)
+	return stats, err
+}
+
+// BatchEmbedder embeds documents with batch jobs. It is implemented by
+// *azopenai.AzureOpenAI.
+type BatchEmbedder interface {
+	SubmitEmbeddingBatch(ctx context.Context, embedder string, reqs []azopenai.BatchRequest) (*azopenai.BatchJob, error)
+	WaitBatch(ctx context.Context, job *azopenai.BatchJob, interval time.Duration) error
+	EmbeddingBatchResults(ctx context.Context, job *azopenai.BatchJob) (*azopenai.EmbeddingResults, error)
+}
+
+var _ BatchEmbedder = (*azopenai.AzureOpenAI)(nil)
+
+// BatchOptions configures IndexBatch.
+type BatchOptions struct {
+	// Embedder is the name of the embedder that runs the batch job.
+	Embedder string
+	// StateFile is the file that the job's state is saved to.
+	StateFile string
+	// PollInterval is the interval at which the job's status is checked.
+	// Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// Writes configures the batches in which the embeddings are written.
+	Writes indexing.Options
+}
+
+// IndexBatch embeds docs with a batch job and writes the embeddings with
+// s.Write once the job has completed, which may take up to 24 hours. The
+// job's state is saved to opts.StateFile, so that an interrupted run resumes
+// waiting for the same job. The file is removed once the embeddings have been
+// written, or if the job ended without results, so that the next run submits
+// a new job. Documents that failed are counted in the returned stats.
+func IndexBatch(ctx context.Context, b BatchEmbedder, s *sqlstore.Store, docs []*ai.Document, opts BatchOptions) (indexing.Stats, error) {
+	ids := make([]string, len(docs))
+	reqs := make([]azopenai.BatchRequest, len(docs))
+	known := make(map[string]bool, len(docs))
+	for i, doc := range docs {
+		key, err := s.Table.KeyArgs(doc)
+		if err != nil {
+			return indexing.Stats{}, fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		ids[i] = batchID(key)
+		reqs[i] = azopenai.BatchRequest{ID: ids[i], Doc: doc}
+		known[ids[i]] = true
+	}
+
+	job, err := azopenai.LoadBatchJob(opts.StateFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if job, err = b.SubmitEmbeddingBatch(ctx, opts.Embedder, reqs); err != nil {
+			return indexing.Stats{}, err
+		}
+		if err := job.Save(opts.StateFile); err != nil {
+			return indexing.Stats{}, err
+		}
+		log.Printf("Submitted batch job %s with %d documents", job.ID, len(reqs))
+	case err != nil:
+		return indexing.Stats{}, err
+	default:
+		log.Printf("Resuming batch job %s", job.ID)
+	}
+
+	if err := b.WaitBatch(ctx, job, cmp.Or(opts.PollInterval, DefaultPollInterval)); err != nil {
+		var jobErr *azopenai.BatchJobError
+		if errors.As(err, &jobErr) {
+			// The job failed, expired or was cancelled without results, and
+			// waiting for it again wouldn't change that
+			if rerr := os.Remove(opts.StateFile); rerr != nil {
+				return indexing.Stats{}, errors.Join(err, rerr)
+			}
+		}
+		return indexing.Stats{}, err
+	}
+	res, err := b.EmbeddingBatchResults(ctx, job)
+	if err != nil {
+		return indexing.Stats{}, err
+	}
+	for id, err := range res.Errors {
+		log.Printf("Failed to embed %s: %v", id, err)
+	}
+	log.Printf("Batch job %s: %d embeddings returned, %d failed", job.ID, len(res.Embeddings), len(res.Errors))
+	for id := range res.Embeddings {
+		if !known[id] {
+			log.Printf("Skipping result for %s: row no longer exists", id)
+		}
+	}
+
+	embeddings := make(map[*ai.Document][]float32, len(res.Embeddings))
+	var embedded []*ai.Document
+	for i, doc := range docs {
+		if emb, ok := res.Embeddings[ids[i]]; ok {
+			embeddings[doc] = emb
+			embedded = append(embedded, doc)
+		}
+	}
+	// The embeddings are already known, so only writes are batched
+	embed := func(_ context.Context, docs []*ai.Document) ([][]float32, error) {
+		embs := make([][]float32, len(docs))
+		for i, doc := range docs {
+			embs[i] = embeddings[doc]
+		}
+		return embs, nil
+	}
+	stats, err := indexing.Run(ctx, embedded, embed, s.Write, opts.Writes)
+	logFailures(s.Table, stats)
+	stats.Failed += len(res.Errors)
+	if err != nil {
+		return stats, err
+	}
+	// Rows that failed are embedded again by the next run
+	return stats, os.Remove(opts.StateFile)
+}
+
+// batchID returns the ID of the batch request of a row, e.g. "La Vie/1/2/0".
+func batchID(key []any) string {
+	parts := make([]string, len(key))
+	for i, v := range key {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, "/")
+}
+
+// logFailures logs the failed batches of stats.
+func logFailures(t sqlstore.Table, stats indexing.Stats) {
+	for _, e := range stats.Errors {
+		key, _ := t.KeyArgs(e.Docs[0])
+		log.Printf("Failed to index %d rows starting at %v: %v", len(e.Docs), key, e.Err)
+	}
+}
@@ -0,0 +1,261 @@
+package shows
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/joergjo/genkit-go-samples/azure/azopenai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/indexing"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+// writes records the documents written to a store.
+type writes struct {
+	mu    sync.Mutex
+	texts []string
+	// embeddings are the written embeddings by text
+	embeddings map[string][]float32
+}
+
+// newIndexStore returns a store that embeds a text as its length, and
+// records writes. Writing a document whose text is fail fails.
+func newIndexStore(fail string) (*sqlstore.Store, *writes) {
+	w := &writes{embeddings: map[string][]float32{}}
+	return &sqlstore.Store{
+		Table: table,
+		Embed: func(ctx context.Context, docs []*ai.Document) ([][]float32, error) {
+			embs := make([][]float32, len(docs))
+			for i, doc := range docs {
+				embs[i] = []float32{float32(len(doc.Content[0].Text))}
+			}
+			return embs, nil
+		},
+		Writer: func(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			for i, doc := range docs {
+				if doc.Content[0].Text == fail {
+					return errors.New("write failed")
+				}
+				w.texts = append(w.texts, doc.Content[0].Text)
+				w.embeddings[doc.Content[0].Text] = embeddings[i]
+			}
+			return nil
+		},
+	}, w
+}
+
+func indexDoc(text string, episode, index int) *ai.Document {
+	return ai.DocumentFromText(text, map[string]any{
+		"show_id":       "La Vie",
+		"season_number": 1,
+		"episode_id":    episode,
+		"chunk_index":   index,
+	})
+}
+
+func TestIndex(t *testing.T) {
+	s, w := newIndexStore("fails")
+	docs := []*ai.Document{indexDoc("a", 1, 0), indexDoc("fails", 1, 1), indexDoc("ccc", 2, 0)}
+	stats, err := Index(context.Background(), s, docs, indexing.Options{BatchSize: 1, Concurrency: 1, Attempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Updated != 2 || stats.Failed != 1 || len(stats.Errors) != 1 {
+		t.Errorf("got stats %+v, want 2 updated and 1 failed", stats)
+	}
+	want := map[string][]float32{"a": {1}, "ccc": {3}}
+	if !reflect.DeepEqual(w.embeddings, want) {
+		t.Errorf("got embeddings %v, want %v", w.embeddings, want)
+	}
+}
+
+// fakeBatches is a BatchEmbedder whose jobs end with status and results.
+type fakeBatches struct {
+	status     string
+	results    *azopenai.EmbeddingResults
+	waitErr    error
+	submitted  [][]azopenai.BatchRequest
+	waited     []string
+	stateSaved bool
+	stateFile  string
+}
+
+func (f *fakeBatches) SubmitEmbeddingBatch(ctx context.Context, embedder string, reqs []azopenai.BatchRequest) (*azopenai.BatchJob, error) {
+	if embedder != "embedder" {
+		return nil, errors.New("unknown embedder " + embedder)
+	}
+	f.submitted = append(f.submitted, reqs)
+	return &azopenai.BatchJob{ID: "batch-new", Status: "validating"}, nil
+}
+
+func (f *fakeBatches) WaitBatch(ctx context.Context, job *azopenai.BatchJob, interval time.Duration) error {
+	// The job's state has been saved before waiting
+	_, err := os.Stat(f.stateFile)
+	f.stateSaved = err == nil
+	f.waited = append(f.waited, job.ID)
+	if interval != DefaultPollInterval {
+		return errors.New("unexpected poll interval")
+	}
+	if f.waitErr != nil {
+		return f.waitErr
+	}
+	job.Status = f.status
+	return nil
+}
+
+func (f *fakeBatches) EmbeddingBatchResults(ctx context.Context, job *azopenai.BatchJob) (*azopenai.EmbeddingResults, error) {
+	return f.results, nil
+}
+
+func batchOptions(t *testing.T) BatchOptions {
+	return BatchOptions{
+		Embedder:  "embedder",
+		StateFile: filepath.Join(t.TempDir(), "index-batch.json"),
+		Writes:    indexing.Options{BatchSize: 1, Concurrency: 1, Attempts: 1},
+	}
+}
+
+func stateExists(t *testing.T, path string) bool {
+	t.Helper()
+	_, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestIndexBatch(t *testing.T) {
+	s, w := newIndexStore("")
+	docs := []*ai.Document{indexDoc("a", 1, 0), indexDoc("bb", 1, 1), indexDoc("ccc", 2, 0)}
+	opts := batchOptions(t)
+	b := &fakeBatches{
+		stateFile: opts.StateFile,
+		status:    "completed",
+		results: &azopenai.EmbeddingResults{
+			Embeddings: map[string][]float32{
+				"La Vie/1/1/0": {10},
+				"La Vie/1/2/0": {30},
+				// A row that has been deleted since the job was submitted
+				"La Vie/1/9/0": {90},
+			},
+			Errors: map[string]error{"La Vie/1/1/1": errors.New("too long")},
+		},
+	}
+	stats, err := IndexBatch(context.Background(), b, s, docs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.submitted) != 1 {
+		t.Fatalf("submitted %d jobs, want 1", len(b.submitted))
+	}
+	var ids []string
+	for _, r := range b.submitted[0] {
+		ids = append(ids, r.ID)
+	}
+	if want := []string{"La Vie/1/1/0", "La Vie/1/1/1", "La Vie/1/2/0"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got request IDs %q, want %q", ids, want)
+	}
+	if !b.stateSaved || !reflect.DeepEqual(b.waited, []string{"batch-new"}) {
+		t.Errorf("waited for jobs %v, state saved: %v", b.waited, b.stateSaved)
+	}
+	if stats.Updated != 2 || stats.Failed != 1 {
+		t.Errorf("got stats %+v, want 2 updated and 1 failed", stats)
+	}
+	// Embeddings of the batch job are written in the order of docs
+	want := map[string][]float32{"a": {10}, "ccc": {30}}
+	if !reflect.DeepEqual(w.texts, []string{"a", "ccc"}) || !reflect.DeepEqual(w.embeddings, want) {
+		t.Errorf("got writes %q %v, want %v", w.texts, w.embeddings, want)
+	}
+	if stateExists(t, opts.StateFile) {
+		t.Error("state file has not been removed")
+	}
+}
+
+func TestIndexBatchResume(t *testing.T) {
+	s, w := newIndexStore("")
+	opts := batchOptions(t)
+	if err := (&azopenai.BatchJob{ID: "batch-old", Status: "in_progress"}).Save(opts.StateFile); err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBatches{
+		stateFile: opts.StateFile,
+		status:    "completed",
+		results:   &azopenai.EmbeddingResults{Embeddings: map[string][]float32{"La Vie/1/1/0": {10}}},
+	}
+	stats, err := IndexBatch(context.Background(), b, s, []*ai.Document{indexDoc("a", 1, 0)}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.submitted) != 0 || !reflect.DeepEqual(b.waited, []string{"batch-old"}) {
+		t.Errorf("submitted %d jobs and waited for %v, want to resume batch-old", len(b.submitted), b.waited)
+	}
+	if stats.Updated != 1 || len(w.texts) != 1 {
+		t.Errorf("got stats %+v and writes %q", stats, w.texts)
+	}
+}
+
+func TestIndexBatchJobFailed(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		keepState bool
+	}{
+		{name: "failed", err: &azopenai.BatchJobError{ID: "batch-new", Status: "failed", Errors: []string{"invalid input"}}},
+		{name: "expired", err: &azopenai.BatchJobError{ID: "batch-new", Status: "expired"}},
+		{name: "cancelled", err: &azopenai.BatchJobError{ID: "batch-new", Status: "cancelled"}},
+		// The job may still complete, so the next run waits for it again
+		{name: "interrupted", err: context.Canceled, keepState: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, w := newIndexStore("")
+			opts := batchOptions(t)
+			b := &fakeBatches{stateFile: opts.StateFile, waitErr: tt.err}
+			docs := []*ai.Document{indexDoc("a", 1, 0)}
+			_, err := IndexBatch(context.Background(), b, s, docs, opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got := stateExists(t, opts.StateFile); got != tt.keepState {
+				t.Fatalf("state file exists: %v, want %v", got, tt.keepState)
+			}
+
+			// The next run submits a new job, or resumes the interrupted one
+			b.waitErr, b.status = nil, "completed"
+			b.results = &azopenai.EmbeddingResults{Embeddings: map[string][]float32{"La Vie/1/1/0": {10}}}
+			if _, err := IndexBatch(context.Background(), b, s, docs, opts); err != nil {
+				t.Fatal(err)
+			}
+			wantSubmitted := 2
+			if tt.keepState {
+				wantSubmitted = 1
+			}
+			if len(b.submitted) != wantSubmitted || len(w.texts) != 1 {
+				t.Errorf("submitted %d jobs and wrote %q, want %d jobs", len(b.submitted), w.texts, wantSubmitted)
+			}
+		})
+	}
+}
+
+func TestIndexBatchInvalidDocument(t *testing.T) {
+	s, _ := newIndexStore("")
+	opts := batchOptions(t)
+	b := &fakeBatches{stateFile: opts.StateFile}
+	doc := ai.DocumentFromText("a", map[string]any{"show_id": "La Vie"})
+	if _, err := IndexBatch(context.Background(), b, s, []*ai.Document{doc}, opts); err == nil {
+		t.Fatal("got no error for a document without key")
+	}
+	if len(b.submitted) != 0 || stateExists(t, opts.StateFile) {
+		t.Error("a job has been submitted")
+	}
+}
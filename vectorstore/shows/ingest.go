@@ -0,0 +1,182 @@
+package shows
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/chunking"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+// Episode is the script of an episode read by ReadEpisodes.
+type Episode struct {
+	ShowID  string
+	Season  int
+	Episode int
+	Script  string
+}
+
+func (ep Episode) String() string {
+	return fmt.Sprintf("%s S%02dE%02d", ep.ShowID, ep.Season, ep.Episode)
+}
+
+// ReadEpisodes reads the episode scripts in dir. Each show is a directory
+// named by its show ID with one text file per episode named
+// s<season>e<episode>.txt, e.g. "La Vie/s01e02.txt".
+func ReadEpisodes(dir string) ([]Episode, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var episodes []Episode
+	for _, p := range paths {
+		ep := Episode{ShowID: filepath.Base(filepath.Dir(p))}
+		if _, err := fmt.Sscanf(strings.ToLower(filepath.Base(p)), "s%de%d.txt", &ep.Season, &ep.Episode); err != nil {
+			return nil, fmt.Errorf("%s: file name must be s<season>e<episode>.txt", p)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		ep.Script = string(b)
+		episodes = append(episodes, ep)
+	}
+	return episodes, nil
+}
+
+// IngestOptions configures Ingest.
+type IngestOptions struct {
+	Chunking chunking.Options
+	// Progress, if not nil, is called after the chunks of an episode have
+	// been replaced.
+	Progress func(ep Episode, chunks int)
+}
+
+// Ingest splits the episode scripts in dir into chunks and replaces the
+// chunks of each episode in s's table, see ReplaceChunks. It returns the
+// documents of all chunks, with the key of their row in their metadata, so
+// that they can be indexed with s.
+func Ingest(ctx context.Context, s *sqlstore.Store, dir string, opts IngestOptions) ([]*ai.Document, error) {
+	splitter, err := chunking.New(opts.Chunking)
+	if err != nil {
+		return nil, err
+	}
+	episodes, err := ReadEpisodes(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(episodes) == 0 {
+		return nil, fmt.Errorf("no episode scripts found in %s", dir)
+	}
+
+	var docs []*ai.Document
+	for _, ep := range episodes {
+		chunks, err := chunking.Split(splitter, ep.Script)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ep, err)
+		}
+		if err := ReplaceChunks(ctx, s, ep, chunks); err != nil {
+			return nil, fmt.Errorf("%s: %w", ep, err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(ep, len(chunks))
+		}
+		for _, c := range chunks {
+			docs = append(docs, ai.DocumentFromText(c.Text, map[string]any{
+				s.Table.Key[0]: ep.ShowID,
+				s.Table.Key[1]: ep.Season,
+				s.Table.Key[2]: ep.Episode,
+				s.Table.Key[3]: c.Index,
+			}))
+		}
+	}
+	return docs, nil
+}
+
+// ReplaceChunks replaces all chunks of an episode in s's table, whose key
+// columns are the show ID, season, episode, and chunk index, and which stores
+// the offsets of chunks in the start_offset and end_offset columns. Chunks
+// keep their embedding until they are indexed again, and chunks after the
+// episode's last chunk are deleted.
+func ReplaceChunks(ctx context.Context, s *sqlstore.Store, ep Episode, chunks []chunking.Chunk) error {
+	t := s.Table
+	if len(t.Key) != 4 {
+		return fmt.Errorf("table %s must have 4 key columns, got %d", t.Name, len(t.Key))
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, args := deleteChunks(s.Dialect, t, ep, len(chunks))
+	if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		stmt, args := updateChunk(s.Dialect, t, ep, c)
+		res, err := tx.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		// Chunks that don't exist yet are inserted
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		stmt, args = insertChunk(s.Dialect, t, ep, c)
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteChunks returns the statement that deletes the chunks of ep from
+// index n on, and its args.
+func deleteChunks(d sqlstore.Dialect, t sqlstore.Table, ep Episode, n int) (string, []any) {
+	p := sqlstore.Params{Dialect: d}
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE %s = %s AND %s = %s AND %s = %s AND %s >= %s",
+		t.Name, t.Key[0], p.Add(ep.ShowID), t.Key[1], p.Add(ep.Season), t.Key[2], p.Add(ep.Episode), t.Key[3], p.Add(n))
+	return stmt, p.Args
+}
+
+// updateChunk returns the statement that updates the text and offsets of the
+// row of c, and its args.
+func updateChunk(d sqlstore.Dialect, t sqlstore.Table, ep Episode, c chunking.Chunk) (string, []any) {
+	start, end := offsets(c)
+	p := sqlstore.Params{Dialect: d}
+	stmt := fmt.Sprintf("UPDATE %s SET start_offset = %s, end_offset = %s, %s = %s WHERE %s = %s AND %s = %s AND %s = %s AND %s = %s",
+		t.Name, p.Add(start), p.Add(end), t.Content, p.Add(c.Text),
+		t.Key[0], p.Add(ep.ShowID), t.Key[1], p.Add(ep.Season), t.Key[2], p.Add(ep.Episode), t.Key[3], p.Add(c.Index))
+	return stmt, p.Args
+}
+
+// insertChunk returns the statement that inserts the row of c without an
+// embedding, and its args.
+func insertChunk(d sqlstore.Dialect, t sqlstore.Table, ep Episode, c chunking.Chunk) (string, []any) {
+	start, end := offsets(c)
+	p := sqlstore.Params{Dialect: d}
+	stmt := fmt.Sprintf("INSERT INTO %s (%s, start_offset, end_offset, %s) VALUES (%s, %s, %s, %s, %s, %s, %s)",
+		t.Name, strings.Join(t.Key, ", "), t.Content,
+		p.Add(ep.ShowID), p.Add(ep.Season), p.Add(ep.Episode), p.Add(c.Index), p.Add(start), p.Add(end), p.Add(c.Text))
+	return stmt, p.Args
+}
+
+// offsets returns the offsets of c, which are null if c couldn't be located
+// in the script.
+func offsets(c chunking.Chunk) (start, end sql.NullInt64) {
+	if c.Start >= 0 {
+		start = sql.NullInt64{Int64: int64(c.Start), Valid: true}
+		end = sql.NullInt64{Int64: int64(c.End), Valid: true}
+	}
+	return start, end
+}
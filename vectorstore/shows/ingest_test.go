@@ -0,0 +1,325 @@
+package shows
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/chunking"
+	"github.com/joergjo/genkit-go-samples/vectorstore/sqlstore"
+)
+
+var table = sqlstore.Table{
+	Name:      "embeddings",
+	Key:       []string{"show_id", "season_number", "episode_id", "chunk_index"},
+	Content:   "chunk",
+	Embedding: "embedding",
+}
+
+// fakeDB is a database/sql driver for a table of chunks, which executes the
+// statements of ReplaceChunks with the Postgres dialect.
+type fakeDB struct {
+	mu sync.Mutex
+	// chunks are the texts of the table's rows by episode and index
+	chunks map[string]map[int64]string
+	// execs are the executed statements
+	execs     []string
+	commits   int
+	rollbacks int
+	// fail is the prefix of a statement that fails, if not empty
+	fail string
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sqlstore.Store) {
+	f := &fakeDB{chunks: map[string]map[int64]string{}}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+	return f, &sqlstore.Store{DB: db, Dialect: sqlstore.Postgres{}, Table: table}
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+// episode returns the chunks of the episode of a statement's key args.
+func (f *fakeDB) episode(args []driver.NamedValue) map[int64]string {
+	id := fmt.Sprint(args[0].Value, "/", args[1].Value, "/", args[2].Value)
+	if f.chunks[id] == nil {
+		f.chunks[id] = map[int64]string{}
+	}
+	return f.chunks[id]
+}
+
+func (f *fakeDB) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, query)
+	if f.fail != "" && strings.HasPrefix(query, f.fail) {
+		return nil, fmt.Errorf("%s failed", f.fail)
+	}
+	switch {
+	case strings.HasPrefix(query, "DELETE"):
+		// show_id, season_number, episode_id, chunk_index >= n
+		ep, n := f.episode(args), args[3].Value.(int64)
+		var deleted int64
+		for i := range ep {
+			if i >= n {
+				delete(ep, i)
+				deleted++
+			}
+		}
+		return driver.RowsAffected(deleted), nil
+	case strings.HasPrefix(query, "UPDATE"):
+		// start_offset, end_offset, chunk, then the key
+		ep, i := f.episode(args[3:]), args[6].Value.(int64)
+		if _, ok := ep[i]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		ep[i] = args[2].Value.(string)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "INSERT"):
+		// the key, start_offset, end_offset, chunk
+		f.episode(args)[args[3].Value.(int64)] = args[6].Value.(string)
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+type fakeConn struct{ f *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx(c), nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.f.exec(query, args)
+}
+
+type fakeTx struct{ f *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.f.mu.Lock()
+	defer tx.f.mu.Unlock()
+	tx.f.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.f.mu.Lock()
+	defer tx.f.mu.Unlock()
+	tx.f.rollbacks++
+	return nil
+}
+
+// writeScripts writes episode scripts to a directory, keyed by their path in
+// it.
+func writeScripts(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for p, script := range scripts {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadEpisodes(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"La Vie/s01e02.txt":    "Paris.",
+		"La Vie/S02E10.txt":    "Lyon.",
+		"Springfield/s1e1.txt": "Donuts.",
+		"La Vie/notes.md":      "ignored",
+		"toplevel.txt":         "ignored",
+	})
+	got, err := ReadEpisodes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Episode{
+		{ShowID: "La Vie", Season: 2, Episode: 10, Script: "Lyon."},
+		{ShowID: "La Vie", Season: 1, Episode: 2, Script: "Paris."},
+		{ShowID: "Springfield", Season: 1, Episode: 1, Script: "Donuts."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if s := want[1].String(); s != "La Vie S01E02" {
+		t.Errorf("got %q, want La Vie S01E02", s)
+	}
+
+	dir = writeScripts(t, map[string]string{"La Vie/pilot.txt": "Paris."})
+	if _, err := ReadEpisodes(dir); err == nil || !strings.Contains(err.Error(), "file name must be s<season>e<episode>.txt") {
+		t.Errorf("got error %v", err)
+	}
+
+	// The scripts that the samples ingest
+	got, err = ReadEpisodes("scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 6 {
+		t.Errorf("got %d episodes in scripts, want 6", len(got))
+	}
+}
+
+func TestIngest(t *testing.T) {
+	f, store := newFakeDB(t)
+	// S01E01 has more chunks than its new script
+	f.chunks["La Vie/1/1"] = map[int64]string{0: "old 0", 1: "old 1", 2: "old 2"}
+	dir := writeScripts(t, map[string]string{
+		"La Vie/s01e01.txt": "0123456789abcdefghij",
+		"La Vie/s01e02.txt": "klmnopqrst",
+	})
+	var progress []string
+	docs, err := Ingest(context.Background(), store, dir, IngestOptions{
+		Chunking: chunking.Options{Strategy: chunking.StrategyFixed, Size: 10},
+		Progress: func(ep Episode, chunks int) {
+			progress = append(progress, fmt.Sprintf("%s: %d", ep, chunks))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantChunks := map[string]map[int64]string{
+		"La Vie/1/1": {0: "0123456789", 1: "abcdefghij"},
+		"La Vie/1/2": {0: "klmnopqrst"},
+	}
+	if !reflect.DeepEqual(f.chunks, wantChunks) {
+		t.Errorf("got chunks %v, want %v", f.chunks, wantChunks)
+	}
+	wantDocs := []*ai.Document{
+		ai.DocumentFromText("0123456789", map[string]any{"show_id": "La Vie", "season_number": 1, "episode_id": 1, "chunk_index": 0}),
+		ai.DocumentFromText("abcdefghij", map[string]any{"show_id": "La Vie", "season_number": 1, "episode_id": 1, "chunk_index": 1}),
+		ai.DocumentFromText("klmnopqrst", map[string]any{"show_id": "La Vie", "season_number": 1, "episode_id": 2, "chunk_index": 0}),
+	}
+	if !reflect.DeepEqual(docs, wantDocs) {
+		t.Errorf("got docs %v, want %v", docs, wantDocs)
+	}
+	if want := []string{"La Vie S01E01: 2", "La Vie S01E02: 1"}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("got progress %q, want %q", progress, want)
+	}
+	// Each episode is replaced in a transaction; the new chunk of S01E02 is
+	// inserted after its update found no row
+	var verbs []string
+	for _, e := range f.execs {
+		verbs = append(verbs, strings.Fields(e)[0])
+	}
+	if want := []string{"DELETE", "UPDATE", "UPDATE", "DELETE", "UPDATE", "INSERT"}; !reflect.DeepEqual(verbs, want) {
+		t.Errorf("got statements %v, want %v", verbs, want)
+	}
+	if f.commits != 2 {
+		t.Errorf("got %d commits, want 2", f.commits)
+	}
+}
+
+func TestIngestErrors(t *testing.T) {
+	opts := IngestOptions{Chunking: chunking.Options{Strategy: chunking.StrategyFixed, Size: 10}}
+	t.Run("no scripts", func(t *testing.T) {
+		_, store := newFakeDB(t)
+		_, err := Ingest(context.Background(), store, t.TempDir(), opts)
+		if err == nil || !strings.Contains(err.Error(), "no episode scripts found") {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("invalid chunking", func(t *testing.T) {
+		_, store := newFakeDB(t)
+		_, err := Ingest(context.Background(), store, t.TempDir(), IngestOptions{Chunking: chunking.Options{Strategy: "words", Size: 10}})
+		if err == nil || !strings.Contains(err.Error(), `unknown strategy "words"`) {
+			t.Errorf("got error %v", err)
+		}
+	})
+
+	t.Run("failed statement", func(t *testing.T) {
+		f, store := newFakeDB(t)
+		f.fail = "INSERT"
+		dir := writeScripts(t, map[string]string{"La Vie/s01e01.txt": "0123456789"})
+		_, err := Ingest(context.Background(), store, dir, opts)
+		if err == nil || !strings.Contains(err.Error(), "La Vie S01E01: INSERT failed") {
+			t.Errorf("got error %v", err)
+		}
+		if f.commits != 0 || f.rollbacks != 1 {
+			t.Errorf("got %d commits and %d rollbacks, want a rollback", f.commits, f.rollbacks)
+		}
+	})
+
+	t.Run("key columns", func(t *testing.T) {
+		_, store := newFakeDB(t)
+		store.Table.Key = store.Table.Key[:3]
+		err := ReplaceChunks(context.Background(), store, Episode{ShowID: "La Vie", Season: 1, Episode: 1}, nil)
+		if err == nil || !strings.Contains(err.Error(), "must have 4 key columns, got 3") {
+			t.Errorf("got error %v", err)
+		}
+	})
+}
+
+func TestChunkStatements(t *testing.T) {
+	ep := Episode{ShowID: "La Vie", Season: 1, Episode: 2}
+	located := chunking.Chunk{Index: 3, Text: "Paris.", Start: 10, End: 16}
+	offsets := []any{sql.NullInt64{Int64: 10, Valid: true}, sql.NullInt64{Int64: 16, Valid: true}}
+	tests := []struct {
+		name                   string
+		dialect                sqlstore.Dialect
+		delete, update, insert string
+	}{
+		{
+			name:    "Postgres",
+			dialect: sqlstore.Postgres{},
+			delete:  "DELETE FROM embeddings WHERE show_id = $1 AND season_number = $2 AND episode_id = $3 AND chunk_index >= $4",
+			update:  "UPDATE embeddings SET start_offset = $1, end_offset = $2, chunk = $3 WHERE show_id = $4 AND season_number = $5 AND episode_id = $6 AND chunk_index = $7",
+			insert:  "INSERT INTO embeddings (show_id, season_number, episode_id, chunk_index, start_offset, end_offset, chunk) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		},
+		{
+			name:    "SQLServer",
+			dialect: sqlstore.SQLServer{},
+			delete:  "DELETE FROM embeddings WHERE show_id = @p1 AND season_number = @p2 AND episode_id = @p3 AND chunk_index >= @p4",
+			update:  "UPDATE embeddings SET start_offset = @p1, end_offset = @p2, chunk = @p3 WHERE show_id = @p4 AND season_number = @p5 AND episode_id = @p6 AND chunk_index = @p7",
+			insert:  "INSERT INTO embeddings (show_id, season_number, episode_id, chunk_index, start_offset, end_offset, chunk) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7)",
+		},
+		{
+			name:    "SQLite",
+			dialect: sqlstore.SQLite{},
+			delete:  "DELETE FROM embeddings WHERE show_id = ?1 AND season_number = ?2 AND episode_id = ?3 AND chunk_index >= ?4",
+			update:  "UPDATE embeddings SET start_offset = ?1, end_offset = ?2, chunk = ?3 WHERE show_id = ?4 AND season_number = ?5 AND episode_id = ?6 AND chunk_index = ?7",
+			insert:  "INSERT INTO embeddings (show_id, season_number, episode_id, chunk_index, start_offset, end_offset, chunk) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := func(got string, args []any, want string, wantArgs []any) {
+				t.Helper()
+				if got != want {
+					t.Errorf("got\n%s\nwant\n%s", got, want)
+				}
+				if !reflect.DeepEqual(args, wantArgs) {
+					t.Errorf("got args %#v, want %#v", args, wantArgs)
+				}
+			}
+			stmt, args := deleteChunks(tt.dialect, table, ep, 4)
+			check(stmt, args, tt.delete, []any{"La Vie", 1, 2, 4})
+			stmt, args = updateChunk(tt.dialect, table, ep, located)
+			check(stmt, args, tt.update, append(offsets, "Paris.", "La Vie", 1, 2, 3))
+			stmt, args = insertChunk(tt.dialect, table, ep, located)
+			check(stmt, args, tt.insert, append([]any{"La Vie", 1, 2, 3}, append(offsets, "Paris.")...))
+		})
+	}
+
+	// Chunks that can't be located have no offsets
+	_, args := insertChunk(sqlstore.Postgres{}, table, ep, chunking.Chunk{Index: 0, Text: "Paris.", Start: -1, End: -1})
+	if want := []any{"La Vie", 1, 2, 0, sql.NullInt64{}, sql.NullInt64{}, "Paris."}; !reflect.DeepEqual(args, want) {
+		t.Errorf("got args %#v, want %#v", args, want)
+	}
+}
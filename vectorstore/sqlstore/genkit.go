@@ -0,0 +1,151 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/joergjo/genkit-go-samples/vectorstore/indexing"
+)
+
+// DefineRetriever defines a retriever that searches s. Its options are a
+// Config.
+func DefineRetriever(g *genkit.Genkit, name string, s *Store, opts *ai.RetrieverOptions) ai.Retriever {
+	return genkit.DefineRetriever(g, name, opts, func(ctx context.Context, req *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
+		cfg, err := DecodeOptions[Config](req.Options)
+		if err != nil {
+			return nil, err
+		}
+		docs, err := s.Search(ctx, req.Query, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &ai.RetrieverResponse{Documents: docs}, nil
+	})
+}
+
+// IndexerRequest is the input of an indexer.
+type IndexerRequest struct {
+	// Documents are the documents to index. Each document has a single text
+	// part and the table's key columns in its metadata. All other metadata
+	// is stored in the row's metadata column.
+	Documents []*ai.Document `json:"documents"`
+}
+
+// IndexerResponse is the output of an indexer.
+type IndexerResponse struct {
+	Indexed int `json:"indexed"`
+	Failed  int `json:"failed"`
+	// Results are the results of the documents, in the order of the request.
+	Results []IndexResult `json:"results"`
+}
+
+// IndexResult is the result of indexing a document.
+type IndexResult struct {
+	// ID is the key of the document's row, e.g. "La Vie/1/2/0". It is empty
+	// if the document's metadata has no valid key.
+	ID string `json:"id,omitempty"`
+	// Status is "indexed" or "failed".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// IndexerOptions configures an indexer.
+type IndexerOptions struct {
+	Label string
+	// Batches configures how documents are embedded and written in batches.
+	Batches indexing.Options
+}
+
+// DefineIndexer defines an indexer that embeds documents and writes them to
+// s. Rows that don't exist are inserted, and existing rows are updated with the
+// document's content, embedding, and metadata.
+func DefineIndexer(g *genkit.Genkit, name string, s *Store, opts *IndexerOptions) *core.Action[*IndexerRequest, *IndexerResponse, struct{}] {
+	if opts == nil {
+		opts = &IndexerOptions{Label: name}
+	}
+	metadata := map[string]any{
+		"type": api.ActionTypeIndexer,
+		"info": map[string]any{
+			"label": opts.Label,
+		},
+	}
+	f := func(ctx context.Context, req *IndexerRequest) (*IndexerResponse, error) {
+		res := &IndexerResponse{Results: make([]IndexResult, len(req.Documents))}
+		// Invalid documents are reported, and the remaining documents are indexed
+		var docs []*ai.Document
+		pos := map[*ai.Document]int{}
+		seen := map[string]bool{}
+		for i, doc := range req.Documents {
+			d, id, err := s.Table.prepare(doc)
+			if err != nil {
+				res.Results[i] = IndexResult{ID: id, Status: "failed", Error: err.Error()}
+				continue
+			}
+			if seen[id] {
+				// A statement can't upsert the same row twice
+				res.Results[i] = IndexResult{ID: id, Status: "failed", Error: "duplicate document"}
+				continue
+			}
+			seen[id] = true
+			res.Results[i] = IndexResult{ID: id, Status: "indexed"}
+			pos[d] = i
+			docs = append(docs, d)
+		}
+
+		stats, err := indexing.Run(ctx, docs, s.Embed, s.Write, opts.Batches)
+		if err != nil {
+			// Batches may or may not have been written before the request was cancelled
+			return nil, err
+		}
+		for _, e := range stats.Errors {
+			for _, d := range e.Docs {
+				res.Results[pos[d]].Status = "failed"
+				res.Results[pos[d]].Error = e.Err.Error()
+			}
+		}
+		for _, r := range res.Results {
+			if r.Status == "indexed" {
+				res.Indexed++
+			} else {
+				res.Failed++
+			}
+		}
+		return res, nil
+	}
+	a := core.NewAction(name, api.ActionTypeIndexer, metadata, nil, f)
+	genkit.RegisterAction(g, a)
+	return a
+}
+
+// prepare checks that doc can be indexed, and returns a copy of doc whose key
+// values are normalized, see normalizeKey, and the ID of its row.
+func (t Table) prepare(doc *ai.Document) (*ai.Document, string, error) {
+	if doc == nil || len(doc.Content) != 1 || doc.Content[0].Text == "" {
+		return nil, "", errors.New("document must have a single text part")
+	}
+	meta := maps.Clone(doc.Metadata)
+	if meta == nil {
+		meta = map[string]any{}
+	}
+	ids := make([]string, len(t.Key))
+	for i, k := range t.Key {
+		v, ok := meta[k]
+		if !ok {
+			return nil, "", fmt.Errorf("missing metadata key %q", k)
+		}
+		v, err := normalizeKey(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("metadata key %q: %w", k, err)
+		}
+		meta[k] = v
+		ids[i] = fmt.Sprint(v)
+	}
+	return &ai.Document{Content: doc.Content, Metadata: meta}, strings.Join(ids, "/"), nil
+}
@@ -0,0 +1,170 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+// Postgres is the dialect of PostgreSQL with the pgvector extension. The
+// metadata column is JSONB, and hybrid search requires a tsvector column.
+type Postgres struct {
+	// TextSearchConfig is the text search configuration that parses the
+	// query of hybrid search. Defaults to "english".
+	TextSearchConfig string
+}
+
+var _ HybridDialect = Postgres{}
+
+// postgresOperators are pgvector's distance operators for each metric.
+var postgresOperators = map[retrieval.Metric]string{
+	retrieval.MetricCosine: "<=>",
+	retrieval.MetricL2:     "<->",
+	retrieval.MetricDot:    "<#>",
+}
+
+func (Postgres) Param(n int, v any) (string, any) {
+	return filter.Postgres.Param(n, v)
+}
+
+func (Postgres) JSONValue(column, key string, numeric bool) string {
+	return filter.Postgres.JSONValue(column, key, numeric)
+}
+
+func (Postgres) JSONKey(key string) string {
+	return filter.Postgres.JSONKey(key)
+}
+
+// Vector returns the text representation of embedding, which Postgres casts
+// to the vector type.
+func (Postgres) Vector(embedding []float32) (any, error) {
+	return VectorText(embedding), nil
+}
+
+func (d Postgres) Nearest(t Table, s Search) (string, []any, error) {
+	op, ok := postgresOperators[s.Metric]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported metric %q", s.Metric)
+	}
+	p := Params{Dialect: d, Args: s.Args}
+	query := fmt.Sprintf(`
+		SELECT %s, %s %s %s AS distance
+		FROM %s
+		WHERE %s IS NOT NULL%s
+		ORDER BY distance
+		LIMIT %s`,
+		t.Columns(""), t.Embedding, op, p.Add(s.Vector), t.Name, t.Embedding, And(s.Filter), p.Add(s.K))
+	return query, p.Args, nil
+}
+
+// Hybrid searches t.TextSearch with websearch_to_tsquery, and ranks matches
+// with ts_rank_cd. The ranks are computed over the candidates only, so that
+// vector search can use an index on the embedding column.
+func (d Postgres) Hybrid(t Table, s Search) (string, []any, error) {
+	op, ok := postgresOperators[s.Metric]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported metric %q", s.Metric)
+	}
+	if t.TextSearch == "" {
+		return "", nil, fmt.Errorf("hybrid search of %s requires a text search column", t.Name)
+	}
+	config := d.TextSearchConfig
+	if config == "" {
+		config = "english"
+	}
+	key := strings.Join(t.Key, ", ")
+	p := Params{Dialect: d, Args: s.Args}
+	candidates := p.Add(s.Hybrid.Candidates)
+	rankConstant := p.Add(s.Hybrid.RankConstant)
+	query := fmt.Sprintf(`
+		WITH vector_search AS (
+			SELECT %[1]s, distance, ROW_NUMBER() OVER (ORDER BY distance) AS rank
+			FROM (
+				SELECT %[1]s, %[3]s %[4]s %[5]s AS distance
+				FROM %[2]s
+				WHERE %[3]s IS NOT NULL%[6]s
+				ORDER BY distance
+				LIMIT %[7]s
+			) AS nearest
+		), text_search AS (
+			SELECT %[1]s, ROW_NUMBER() OVER (ORDER BY text_score DESC) AS rank
+			FROM (
+				SELECT %[1]s, ts_rank_cd(%[8]s, query) AS text_score
+				FROM %[2]s, websearch_to_tsquery(%[9]s::regconfig, %[10]s) AS query
+				WHERE %[8]s @@ query%[6]s
+				ORDER BY text_score DESC
+				LIMIT %[7]s
+			) AS matches
+		)
+		SELECT %[11]s, v.distance, v.rank, t.rank,
+			COALESCE(%[12]s::float8 / (%[14]s::int + v.rank), 0)
+				+ COALESCE(%[13]s::float8 / (%[14]s::int + t.rank), 0) AS score
+		FROM vector_search v
+		FULL OUTER JOIN text_search t USING (%[1]s)
+		JOIN %[2]s e USING (%[1]s)
+		ORDER BY score DESC
+		LIMIT %[15]s`,
+		key, t.Name, t.Embedding, op, p.Add(s.Vector), And(s.Filter), candidates,
+		t.TextSearch, p.Add(config), p.Add(s.Text), t.Columns("e"),
+		p.Add(s.Hybrid.VectorWeight), p.Add(s.Hybrid.TextWeight), rankConstant, p.Add(s.K))
+	return query, p.Args, nil
+}
+
+// Upsert inserts rows with INSERT ... ON CONFLICT, which requires a unique
+// index on t.Key.
+func (d Postgres) Upsert(t Table, rows []Row) (string, []any, error) {
+	return upsertOnConflict(d, t, rows)
+}
+
+// upsertOnConflict returns the INSERT ... ON CONFLICT statement of Upsert,
+// which PostgreSQL and SQLite share.
+func upsertOnConflict(d Dialect, t Table, rows []Row) (string, []any, error) {
+	cols := append(append([]string{}, t.Key...), t.Content, t.Embedding)
+	set := []string{
+		fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", t.Content),
+		fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", t.Embedding),
+	}
+	if t.Metadata != "" {
+		cols = append(cols, t.Metadata)
+		set = append(set, fmt.Sprintf("%[1]s = COALESCE(EXCLUDED.%[1]s, %[2]s.%[1]s)", t.Metadata, t.Name))
+	}
+	values, args, err := rowValues(d, t, rows, func(p string) string { return p })
+	if err != nil {
+		return "", nil, err
+	}
+	stmt := fmt.Sprintf(`
+		INSERT INTO %s (%s)
+		VALUES %s
+		ON CONFLICT (%s) DO UPDATE
+		SET %s`,
+		t.Name, strings.Join(cols, ", "), values, strings.Join(t.Key, ", "), strings.Join(set, ", "))
+	return stmt, args, nil
+}
+
+// rowValues returns the VALUES lists of rows in the column order of Upsert,
+// and their args. vector returns the expression for the placeholder of an
+// embedding.
+func rowValues(d Dialect, t Table, rows []Row, vector func(placeholder string) string) (string, []any, error) {
+	if len(rows) == 0 {
+		return "", nil, fmt.Errorf("no rows to write to %s", t.Name)
+	}
+	p := Params{Dialect: d}
+	lists := make([]string, len(rows))
+	for i, r := range rows {
+		if len(r.Key) != len(t.Key) {
+			return "", nil, fmt.Errorf("row[%d]: got %d key values, want %d", i, len(r.Key), len(t.Key))
+		}
+		vals := make([]string, 0, len(r.Key)+3)
+		for _, k := range r.Key {
+			vals = append(vals, p.Add(k))
+		}
+		vals = append(vals, p.Add(r.Content), vector(p.Add(r.Embedding)))
+		if t.Metadata != "" {
+			vals = append(vals, p.Add(r.Metadata))
+		}
+		lists[i] = "(" + strings.Join(vals, ", ") + ")"
+	}
+	return strings.Join(lists, ", "), p.Args, nil
+}
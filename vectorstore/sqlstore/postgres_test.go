@@ -0,0 +1,107 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+func TestPostgresNearest(t *testing.T) {
+	tests := []struct {
+		metric retrieval.Metric
+		op     string
+	}{
+		{retrieval.MetricCosine, "<=>"},
+		{retrieval.MetricL2, "<->"},
+		{retrieval.MetricDot, "<#>"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.metric), func(t *testing.T) {
+			q, args, err := Postgres{}.Nearest(episodes, search(t, Postgres{}, tt.metric))
+			checkQuery(t, q, args, err,
+				"SELECT show_id, season_number, content, metadata, embedding "+tt.op+" $2 AS distance FROM episodes WHERE embedding IS NOT NULL AND show_id = $1 ORDER BY distance LIMIT $3",
+				[]any{"La Vie", "[0.1,0.2]", 3})
+		})
+	}
+
+	t.Run("without filter", func(t *testing.T) {
+		s := Search{Metric: retrieval.MetricCosine, K: 2, Vector: "[1,0]"}
+		q, args, err := Postgres{}.Nearest(episodes, s)
+		checkQuery(t, q, args, err,
+			"SELECT show_id, season_number, content, metadata, embedding <=> $1 AS distance FROM episodes WHERE embedding IS NOT NULL ORDER BY distance LIMIT $2",
+			[]any{"[1,0]", 2})
+	})
+
+	t.Run("unsupported metric", func(t *testing.T) {
+		_, _, err := Postgres{}.Nearest(episodes, search(t, Postgres{}, "hamming"))
+		checkError(t, err, `unsupported metric "hamming"`)
+	})
+}
+
+func TestPostgresHybrid(t *testing.T) {
+	want := "WITH vector_search AS ( " +
+		"SELECT show_id, season_number, distance, ROW_NUMBER() OVER (ORDER BY distance) AS rank FROM ( " +
+		"SELECT show_id, season_number, embedding <=> $4 AS distance FROM episodes WHERE embedding IS NOT NULL AND show_id = $1 ORDER BY distance LIMIT $2 " +
+		") AS nearest ), text_search AS ( " +
+		"SELECT show_id, season_number, ROW_NUMBER() OVER (ORDER BY text_score DESC) AS rank FROM ( " +
+		"SELECT show_id, season_number, ts_rank_cd(content_tsv, query) AS text_score FROM episodes, websearch_to_tsquery($5::regconfig, $6) AS query WHERE content_tsv @@ query AND show_id = $1 ORDER BY text_score DESC LIMIT $2 " +
+		") AS matches ) " +
+		"SELECT e.show_id, e.season_number, e.content, e.metadata, v.distance, v.rank, t.rank, " +
+		"COALESCE($7::float8 / ($3::int + v.rank), 0) + COALESCE($8::float8 / ($3::int + t.rank), 0) AS score " +
+		"FROM vector_search v FULL OUTER JOIN text_search t USING (show_id, season_number) JOIN episodes e USING (show_id, season_number) " +
+		"ORDER BY score DESC LIMIT $9"
+
+	q, args, err := Postgres{}.Hybrid(episodes, search(t, Postgres{}, retrieval.MetricCosine))
+	checkQuery(t, q, args, err, want, []any{"La Vie", 20, 60, "[0.1,0.2]", "english", "pizza", 1.0, 0.5, 3})
+
+	t.Run("text search config", func(t *testing.T) {
+		_, args, err := Postgres{TextSearchConfig: "french"}.Hybrid(episodes, search(t, Postgres{}, retrieval.MetricCosine))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args[4] != "french" {
+			t.Errorf("got config %v, want french", args[4])
+		}
+	})
+
+	t.Run("without text search column", func(t *testing.T) {
+		table := episodes
+		table.TextSearch = ""
+		_, _, err := Postgres{}.Hybrid(table, search(t, Postgres{}, retrieval.MetricCosine))
+		checkError(t, err, "requires a text search column")
+	})
+}
+
+func TestPostgresUpsert(t *testing.T) {
+	q, args, err := Postgres{}.Upsert(episodes, rows)
+	checkQuery(t, q, args, err,
+		"INSERT INTO episodes (show_id, season_number, content, embedding, metadata) "+
+			"VALUES ($1, $2, $3, $4, $5), ($6, $7, $8, $9, $10) "+
+			"ON CONFLICT (show_id, season_number) DO UPDATE "+
+			"SET content = EXCLUDED.content, embedding = EXCLUDED.embedding, metadata = COALESCE(EXCLUDED.metadata, episodes.metadata)",
+		[]any{
+			"La Vie", 1, "a", "[1,0]", sql.NullString{String: `{"genre":"drama"}`, Valid: true},
+			"La Vie", 2, "b", "[0,1]", sql.NullString{},
+		})
+
+	t.Run("without metadata column", func(t *testing.T) {
+		table := episodes
+		table.Metadata = ""
+		q, args, err := Postgres{}.Upsert(table, rows[:1])
+		checkQuery(t, q, args, err,
+			"INSERT INTO episodes (show_id, season_number, content, embedding) VALUES ($1, $2, $3, $4) "+
+				"ON CONFLICT (show_id, season_number) DO UPDATE SET content = EXCLUDED.content, embedding = EXCLUDED.embedding",
+			[]any{"La Vie", 1, "a", "[1,0]"})
+	})
+
+	t.Run("no rows", func(t *testing.T) {
+		_, _, err := Postgres{}.Upsert(episodes, nil)
+		checkError(t, err, "no rows to write to episodes")
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, _, err := Postgres{}.Upsert(episodes, []Row{rows[0], {Key: []any{"La Vie"}, Content: "c", Embedding: "[1,1]"}})
+		checkError(t, err, "row[1]: got 1 key values, want 2")
+	})
+}
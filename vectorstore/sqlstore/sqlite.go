@@ -0,0 +1,100 @@
+package sqlstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+// SQLite is the dialect of SQLite with the sqlite-vec extension, which must
+// be loaded by every connection. Embeddings are stored as BLOBs of float32
+// values, and the metadata column is JSON stored as TEXT. Searches compare the
+// query with every row of the table rather than using sqlite-vec's vec0
+// virtual tables, so that the table is a regular table like with the other
+// dialects. SQLite doesn't support hybrid search.
+// Placeholders are numbered, e.g. "?1".
+type SQLite struct{}
+
+var _ Dialect = SQLite{}
+
+// sqliteFunctions are sqlite-vec's distance functions for each metric.
+// sqlite-vec has no inner product distance, see dotDistance for MetricDot.
+var sqliteFunctions = map[retrieval.Metric]string{
+	retrieval.MetricCosine: "vec_distance_cosine",
+	retrieval.MetricL2:     "vec_distance_l2",
+}
+
+func (SQLite) Param(n int, v any) (string, any) {
+	return filter.SQLite.Param(n, v)
+}
+
+func (SQLite) JSONValue(column, key string, numeric bool) string {
+	return filter.SQLite.JSONValue(column, key, numeric)
+}
+
+func (SQLite) JSONKey(key string) string {
+	return filter.SQLite.JSONKey(key)
+}
+
+// Vector returns embedding as a BLOB of little-endian float32 values,
+// sqlite-vec's compact vector format.
+func (SQLite) Vector(embedding []float32) (any, error) {
+	b := make([]byte, 0, 4*len(embedding))
+	for _, v := range embedding {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+	}
+	return b, nil
+}
+
+func (d SQLite) Nearest(t Table, s Search) (string, []any, error) {
+	p := Params{Dialect: d, Args: s.Args}
+	var distance string
+	if s.Metric == retrieval.MetricDot {
+		var err error
+		if distance, err = d.dotDistance(t, s.Vector, &p); err != nil {
+			return "", nil, err
+		}
+	} else {
+		fn, ok := sqliteFunctions[s.Metric]
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported metric %q", s.Metric)
+		}
+		distance = fmt.Sprintf("%s(%s, %s)", fn, t.Embedding, p.Add(s.Vector))
+	}
+	query := fmt.Sprintf(`
+		SELECT %s, %s AS distance
+		FROM %s
+		WHERE %s IS NOT NULL%s
+		ORDER BY distance
+		LIMIT %s`,
+		t.Columns(""), distance, t.Name, t.Embedding, And(s.Filter), p.Add(s.K))
+	return query, p.Args, nil
+}
+
+// dotDistance returns the expression of the negative inner product of the
+// embedding and vector, the query's BLOB. It is computed from L2 distances as
+// (|e - q|² - |e|² - |q|²) / 2, where |e| is the distance to a zero vector, and
+// |q|² is passed as an argument.
+func (SQLite) dotDistance(t Table, vector any, p *Params) (string, error) {
+	b, ok := vector.([]byte)
+	if !ok || len(b)%4 != 0 {
+		return "", fmt.Errorf("query vector must be a BLOB of float32 values, got %T", vector)
+	}
+	var norm float64
+	for i := 0; i < len(b); i += 4 {
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i:])))
+		norm += v * v
+	}
+	q, zero := p.Add(b), p.Add(make([]byte, len(b)))
+	return fmt.Sprintf("(vec_distance_l2(%[1]s, %[2]s) * vec_distance_l2(%[1]s, %[2]s) - vec_distance_l2(%[1]s, %[3]s) * vec_distance_l2(%[1]s, %[3]s) - %[4]s) / 2",
+		t.Embedding, q, zero, p.Add(norm)), nil
+}
+
+// Upsert inserts rows with INSERT ... ON CONFLICT, which requires a unique
+// index on t.Key.
+func (d SQLite) Upsert(t Table, rows []Row) (string, []any, error) {
+	return upsertOnConflict(d, t, rows)
+}
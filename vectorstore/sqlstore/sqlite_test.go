@@ -0,0 +1,78 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+func TestSQLiteVector(t *testing.T) {
+	got, err := SQLite{}.Vector([]float32{1, -2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0x80, 0x3f, 0, 0, 0, 0xc0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestSQLiteNearest(t *testing.T) {
+	tests := []struct {
+		metric retrieval.Metric
+		fn     string
+	}{
+		{retrieval.MetricCosine, "vec_distance_cosine"},
+		{retrieval.MetricL2, "vec_distance_l2"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.metric), func(t *testing.T) {
+			q, args, err := SQLite{}.Nearest(episodes, search(t, SQLite{}, tt.metric))
+			checkQuery(t, q, args, err,
+				"SELECT show_id, season_number, content, metadata, "+tt.fn+"(embedding, ?2) AS distance "+
+					"FROM episodes WHERE embedding IS NOT NULL AND show_id = ?1 ORDER BY distance LIMIT ?3",
+				[]any{"La Vie", "[0.1,0.2]", 3})
+		})
+	}
+
+	t.Run("dot", func(t *testing.T) {
+		s := search(t, SQLite{}, retrieval.MetricDot)
+		s.Vector, _ = SQLite{}.Vector([]float32{3, 4})
+		q, args, err := SQLite{}.Nearest(episodes, s)
+		checkQuery(t, q, args, err,
+			"SELECT show_id, season_number, content, metadata, "+
+				"(vec_distance_l2(embedding, ?2) * vec_distance_l2(embedding, ?2) - vec_distance_l2(embedding, ?3) * vec_distance_l2(embedding, ?3) - ?4) / 2 AS distance "+
+				"FROM episodes WHERE embedding IS NOT NULL AND show_id = ?1 ORDER BY distance LIMIT ?5",
+			[]any{"La Vie", s.Vector, make([]byte, 8), 25.0, 3})
+	})
+
+	t.Run("dot with invalid vector", func(t *testing.T) {
+		_, _, err := SQLite{}.Nearest(episodes, search(t, SQLite{}, retrieval.MetricDot))
+		checkError(t, err, "query vector must be a BLOB of float32 values, got string")
+	})
+
+	t.Run("unsupported metric", func(t *testing.T) {
+		_, _, err := SQLite{}.Nearest(episodes, search(t, SQLite{}, "hamming"))
+		checkError(t, err, `unsupported metric "hamming"`)
+	})
+
+	t.Run("hybrid", func(t *testing.T) {
+		if _, ok := Dialect(SQLite{}).(HybridDialect); ok {
+			t.Error("SQLite supports hybrid search")
+		}
+	})
+}
+
+func TestSQLiteUpsert(t *testing.T) {
+	q, args, err := SQLite{}.Upsert(episodes, rows)
+	checkQuery(t, q, args, err,
+		"INSERT INTO episodes (show_id, season_number, content, embedding, metadata) "+
+			"VALUES (?1, ?2, ?3, ?4, ?5), (?6, ?7, ?8, ?9, ?10) "+
+			"ON CONFLICT (show_id, season_number) DO UPDATE "+
+			"SET content = EXCLUDED.content, embedding = EXCLUDED.embedding, metadata = COALESCE(EXCLUDED.metadata, episodes.metadata)",
+		[]any{
+			"La Vie", 1, "a", "[1,0]", sql.NullString{String: `{"genre":"drama"}`, Valid: true},
+			"La Vie", 2, "b", "[0,1]", sql.NullString{},
+		})
+}
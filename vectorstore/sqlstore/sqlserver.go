@@ -0,0 +1,212 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+// SQLServer is the dialect of Azure SQL and SQL Server with the native vector
+// type. The metadata column is JSON stored as NVARCHAR, and hybrid search
+// requires a full-text index on t.TextSearch whose key is t.ID.
+// Placeholders are positional, e.g. "@p1".
+type SQLServer struct {
+	// Dimensions is the number of dimensions of the embedding column.
+	Dimensions int
+	// TextSearch is the full-text search function of hybrid search:
+	// "FREETEXTTABLE" (the default) matches the meaning of the query, and
+	// "CONTAINSTABLE" expects a CONTAINS search condition.
+	TextSearch string
+	// Approximate searches the table's vector index with VECTOR_SEARCH
+	// instead of comparing the query with every row. The index must have
+	// been created for the search's metric. Hybrid search is always exact.
+	Approximate bool
+}
+
+var _ HybridDialect = SQLServer{}
+
+// ApproximateFactor is the number of rows searched in the vector index per
+// requested row. Filters are applied after searching the index, so the index
+// is searched for more rows than requested.
+const ApproximateFactor = 10
+
+// sqlServerMetrics are the VECTOR_DISTANCE metrics for each metric.
+var sqlServerMetrics = map[retrieval.Metric]string{
+	retrieval.MetricCosine: "cosine",
+	retrieval.MetricL2:     "euclidean",
+	retrieval.MetricDot:    "dot",
+}
+
+func (SQLServer) Param(n int, v any) (string, any) {
+	return fmt.Sprintf("@p%d", n), v
+}
+
+func (SQLServer) JSONValue(column, key string, numeric bool) string {
+	return filter.SQLServer.JSONValue(column, key, numeric)
+}
+
+func (SQLServer) JSONKey(key string) string {
+	return filter.SQLServer.JSONKey(key)
+}
+
+// Vector returns the JSON array of embedding, which is cast to the vector
+// type.
+func (SQLServer) Vector(embedding []float32) (any, error) {
+	return VectorText(embedding), nil
+}
+
+// vectorType returns the type of the embedding column.
+func (d SQLServer) vectorType() (string, error) {
+	if d.Dimensions <= 0 {
+		return "", fmt.Errorf("invalid number of dimensions %d", d.Dimensions)
+	}
+	return fmt.Sprintf("VECTOR(%d)", d.Dimensions), nil
+}
+
+func (d SQLServer) Nearest(t Table, s Search) (string, []any, error) {
+	metric, ok := sqlServerMetrics[s.Metric]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported metric %q", s.Metric)
+	}
+	vt, err := d.vectorType()
+	if err != nil {
+		return "", nil, err
+	}
+	p := Params{Dialect: d, Args: s.Args}
+	if d.Approximate {
+		// SIMILAR_TO must be a variable
+		query := fmt.Sprintf(`
+			DECLARE @query %[1]s = CAST(%[2]s AS %[1]s);
+			SELECT TOP(%[3]s) %[4]s, s.distance
+			FROM VECTOR_SEARCH(
+				TABLE = %[5]s AS e,
+				COLUMN = %[6]s,
+				SIMILAR_TO = @query,
+				METRIC = '%[7]s',
+				TOP_N = %[8]s
+			) AS s
+			WHERE 1 = 1%[9]s
+			ORDER BY s.distance`,
+			vt, p.Add(s.Vector), p.Add(s.K), t.Columns("e"), t.Name, t.Embedding, metric,
+			p.Add(s.K*ApproximateFactor), And(s.Filter))
+		return query, p.Args, nil
+	}
+	query := fmt.Sprintf(`
+		SELECT TOP(%s) %s, VECTOR_DISTANCE('%s', %s, CAST(%s AS %s)) AS distance
+		FROM %s
+		WHERE %s IS NOT NULL%s
+		ORDER BY distance`,
+		p.Add(s.K), t.Columns(""), metric, t.Embedding, p.Add(s.Vector), vt, t.Name, t.Embedding, And(s.Filter))
+	return query, p.Args, nil
+}
+
+// Hybrid searches t.TextSearch with d.TextSearch, whose [KEY] is joined with
+// t.ID.
+func (d SQLServer) Hybrid(t Table, s Search) (string, []any, error) {
+	metric, ok := sqlServerMetrics[s.Metric]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported metric %q", s.Metric)
+	}
+	vt, err := d.vectorType()
+	if err != nil {
+		return "", nil, err
+	}
+	if t.TextSearch == "" || t.ID == "" {
+		return "", nil, fmt.Errorf("hybrid search of %s requires a text search column and an ID column", t.Name)
+	}
+	fn := d.TextSearch
+	switch fn {
+	case "":
+		fn = "FREETEXTTABLE"
+	case "FREETEXTTABLE", "CONTAINSTABLE":
+	default:
+		return "", nil, fmt.Errorf("unsupported text search function %q", fn)
+	}
+	on := func(a, b string) string {
+		conds := make([]string, len(t.Key))
+		for i, k := range t.Key {
+			conds[i] = fmt.Sprintf("%s.%s = %s", a, k, fmt.Sprintf(b, k))
+		}
+		return strings.Join(conds, " AND ")
+	}
+	key := strings.Join(t.Key, ", ")
+	p := Params{Dialect: d, Args: s.Args}
+	distance := fmt.Sprintf("VECTOR_DISTANCE('%s', %s, CAST(%s AS %s))", metric, t.Embedding, p.Add(s.Vector), vt)
+	candidates := p.Add(s.Hybrid.Candidates)
+	rankConstant := p.Add(s.Hybrid.RankConstant)
+	query := fmt.Sprintf(`
+		WITH vector_search AS (
+			SELECT TOP(%[3]s) %[1]s, %[4]s AS distance, ROW_NUMBER() OVER (ORDER BY %[4]s) AS rank
+			FROM %[2]s
+			WHERE %[5]s IS NOT NULL%[6]s
+			ORDER BY distance
+		), text_search AS (
+			SELECT TOP(%[3]s) %[7]s, ROW_NUMBER() OVER (ORDER BY ft.[RANK] DESC) AS rank
+			FROM %[8]s(%[2]s, %[9]s, %[10]s, %[3]s) AS ft
+			JOIN %[2]s e ON e.%[11]s = ft.[KEY]
+			WHERE e.%[9]s IS NOT NULL%[6]s
+			ORDER BY ft.[RANK] DESC
+		)
+		SELECT TOP(%[12]s) %[13]s, v.distance, v.rank, t.rank,
+			COALESCE(%[14]s / (%[16]s + v.rank), 0) + COALESCE(%[15]s / (%[16]s + t.rank), 0) AS score
+		FROM vector_search v
+		FULL OUTER JOIN text_search t ON %[17]s
+		JOIN %[2]s e ON %[18]s
+		ORDER BY score DESC`,
+		key, t.Name, candidates, distance, t.Embedding, And(s.Filter), qualified("e", t.Key),
+		fn, t.TextSearch, p.Add(s.Text), t.ID, p.Add(s.K), t.Columns("e"),
+		p.Add(s.Hybrid.VectorWeight), p.Add(s.Hybrid.TextWeight), rankConstant,
+		on("t", "v.%s"), on("e", "COALESCE(v.%[1]s, t.%[1]s)"))
+	return query, p.Args, nil
+}
+
+// Upsert writes rows with a MERGE statement, which requires a unique index on
+// t.Key. SQL Server accepts at most 2100 parameters per statement, so a batch
+// may have at most 2100 / (len(t.Key) + 3) rows.
+func (d SQLServer) Upsert(t Table, rows []Row) (string, []any, error) {
+	vt, err := d.vectorType()
+	if err != nil {
+		return "", nil, err
+	}
+	values, args, err := rowValues(d, t, rows, func(p string) string {
+		return fmt.Sprintf("CAST(%s AS %s)", p, vt)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	cols := append(append([]string{}, t.Key...), t.Content, t.Embedding)
+	set := []string{
+		fmt.Sprintf("%[1]s = u.%[1]s", t.Content),
+		fmt.Sprintf("%[1]s = u.%[1]s", t.Embedding),
+	}
+	if t.Metadata != "" {
+		cols = append(cols, t.Metadata)
+		set = append(set, fmt.Sprintf("%[1]s = COALESCE(u.%[1]s, e.%[1]s)", t.Metadata))
+	}
+	on := make([]string, len(t.Key))
+	for i, k := range t.Key {
+		on[i] = fmt.Sprintf("e.%[1]s = u.%[1]s", k)
+	}
+	stmt := fmt.Sprintf(`
+		MERGE %s AS e
+		USING (VALUES %s) AS u (%s)
+		ON %s
+		WHEN MATCHED THEN
+			UPDATE SET %s
+		WHEN NOT MATCHED THEN
+			INSERT (%s) VALUES (%s);`,
+		t.Name, values, strings.Join(cols, ", "), strings.Join(on, " AND "), strings.Join(set, ", "),
+		strings.Join(cols, ", "), qualified("u", cols))
+	return stmt, args, nil
+}
+
+// qualified returns the columns prefixed with alias.
+func qualified(alias string, columns []string) string {
+	q := make([]string, len(columns))
+	for i, c := range columns {
+		q[i] = alias + "." + c
+	}
+	return strings.Join(q, ", ")
+}
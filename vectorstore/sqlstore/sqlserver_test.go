@@ -0,0 +1,126 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+func TestSQLServerNearest(t *testing.T) {
+	tests := []struct {
+		metric retrieval.Metric
+		name   string
+	}{
+		{retrieval.MetricCosine, "cosine"},
+		{retrieval.MetricL2, "euclidean"},
+		{retrieval.MetricDot, "dot"},
+	}
+	d := SQLServer{Dimensions: 2}
+	for _, tt := range tests {
+		t.Run(string(tt.metric), func(t *testing.T) {
+			q, args, err := d.Nearest(episodes, search(t, d, tt.metric))
+			checkQuery(t, q, args, err,
+				"SELECT TOP(@p2) show_id, season_number, content, metadata, VECTOR_DISTANCE('"+tt.name+"', embedding, CAST(@p3 AS VECTOR(2))) AS distance "+
+					"FROM episodes WHERE embedding IS NOT NULL AND show_id = @p1 ORDER BY distance",
+				[]any{"La Vie", 3, "[0.1,0.2]"})
+		})
+	}
+
+	t.Run("approximate", func(t *testing.T) {
+		d := SQLServer{Dimensions: 2, Approximate: true}
+		q, args, err := d.Nearest(episodes, search(t, d, retrieval.MetricCosine))
+		checkQuery(t, q, args, err,
+			"DECLARE @query VECTOR(2) = CAST(@p2 AS VECTOR(2)); "+
+				"SELECT TOP(@p3) e.show_id, e.season_number, e.content, e.metadata, s.distance "+
+				"FROM VECTOR_SEARCH( TABLE = episodes AS e, COLUMN = embedding, SIMILAR_TO = @query, METRIC = 'cosine', TOP_N = @p4 ) AS s "+
+				"WHERE 1 = 1 AND show_id = @p1 ORDER BY s.distance",
+			[]any{"La Vie", "[0.1,0.2]", 3, 3 * ApproximateFactor})
+	})
+
+	t.Run("without dimensions", func(t *testing.T) {
+		_, _, err := SQLServer{}.Nearest(episodes, search(t, d, retrieval.MetricCosine))
+		checkError(t, err, "invalid number of dimensions 0")
+	})
+
+	t.Run("unsupported metric", func(t *testing.T) {
+		_, _, err := d.Nearest(episodes, search(t, d, "hamming"))
+		checkError(t, err, `unsupported metric "hamming"`)
+	})
+}
+
+func TestSQLServerHybrid(t *testing.T) {
+	want := func(fn string) string {
+		return "WITH vector_search AS ( " +
+			"SELECT TOP(@p3) show_id, season_number, VECTOR_DISTANCE('cosine', embedding, CAST(@p2 AS VECTOR(2))) AS distance, " +
+			"ROW_NUMBER() OVER (ORDER BY VECTOR_DISTANCE('cosine', embedding, CAST(@p2 AS VECTOR(2)))) AS rank " +
+			"FROM episodes WHERE embedding IS NOT NULL AND show_id = @p1 ORDER BY distance " +
+			"), text_search AS ( " +
+			"SELECT TOP(@p3) e.show_id, e.season_number, ROW_NUMBER() OVER (ORDER BY ft.[RANK] DESC) AS rank " +
+			"FROM " + fn + "(episodes, content_tsv, @p5, @p3) AS ft JOIN episodes e ON e.id = ft.[KEY] " +
+			"WHERE e.content_tsv IS NOT NULL AND show_id = @p1 ORDER BY ft.[RANK] DESC ) " +
+			"SELECT TOP(@p6) e.show_id, e.season_number, e.content, e.metadata, v.distance, v.rank, t.rank, " +
+			"COALESCE(@p7 / (@p4 + v.rank), 0) + COALESCE(@p8 / (@p4 + t.rank), 0) AS score " +
+			"FROM vector_search v FULL OUTER JOIN text_search t ON t.show_id = v.show_id AND t.season_number = v.season_number " +
+			"JOIN episodes e ON e.show_id = COALESCE(v.show_id, t.show_id) AND e.season_number = COALESCE(v.season_number, t.season_number) " +
+			"ORDER BY score DESC"
+	}
+	wantArgs := []any{"La Vie", "[0.1,0.2]", 20, 60, "pizza", 3, 1.0, 0.5}
+
+	tests := []struct {
+		textSearch, fn string
+	}{
+		{"", "FREETEXTTABLE"},
+		{"FREETEXTTABLE", "FREETEXTTABLE"},
+		{"CONTAINSTABLE", "CONTAINSTABLE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fn, func(t *testing.T) {
+			d := SQLServer{Dimensions: 2, TextSearch: tt.textSearch}
+			q, args, err := d.Hybrid(episodes, search(t, d, retrieval.MetricCosine))
+			checkQuery(t, q, args, err, want(tt.fn), wantArgs)
+		})
+	}
+
+	t.Run("approximate", func(t *testing.T) {
+		// Hybrid search is always exact
+		d := SQLServer{Dimensions: 2, Approximate: true}
+		q, args, err := d.Hybrid(episodes, search(t, d, retrieval.MetricCosine))
+		checkQuery(t, q, args, err, want("FREETEXTTABLE"), wantArgs)
+	})
+
+	t.Run("unsupported text search", func(t *testing.T) {
+		d := SQLServer{Dimensions: 2, TextSearch: "CONTAINS"}
+		_, _, err := d.Hybrid(episodes, search(t, d, retrieval.MetricCosine))
+		checkError(t, err, `unsupported text search function "CONTAINS"`)
+	})
+
+	t.Run("without ID column", func(t *testing.T) {
+		d := SQLServer{Dimensions: 2}
+		table := episodes
+		table.ID = ""
+		_, _, err := d.Hybrid(table, search(t, d, retrieval.MetricCosine))
+		checkError(t, err, "requires a text search column and an ID column")
+	})
+}
+
+func TestSQLServerUpsert(t *testing.T) {
+	q, args, err := SQLServer{Dimensions: 2}.Upsert(episodes, rows)
+	checkQuery(t, q, args, err,
+		"MERGE episodes AS e "+
+			"USING (VALUES (@p1, @p2, @p3, CAST(@p4 AS VECTOR(2)), @p5), (@p6, @p7, @p8, CAST(@p9 AS VECTOR(2)), @p10)) "+
+			"AS u (show_id, season_number, content, embedding, metadata) "+
+			"ON e.show_id = u.show_id AND e.season_number = u.season_number "+
+			"WHEN MATCHED THEN UPDATE SET content = u.content, embedding = u.embedding, metadata = COALESCE(u.metadata, e.metadata) "+
+			"WHEN NOT MATCHED THEN INSERT (show_id, season_number, content, embedding, metadata) "+
+			"VALUES (u.show_id, u.season_number, u.content, u.embedding, u.metadata);",
+		[]any{
+			"La Vie", 1, "a", "[1,0]", sql.NullString{String: `{"genre":"drama"}`, Valid: true},
+			"La Vie", 2, "b", "[0,1]", sql.NullString{},
+		})
+
+	t.Run("without dimensions", func(t *testing.T) {
+		_, _, err := SQLServer{}.Upsert(episodes, rows)
+		checkError(t, err, "invalid number of dimensions 0")
+	})
+}
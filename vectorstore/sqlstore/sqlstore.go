@@ -0,0 +1,394 @@
+// Package sqlstore is a vector store for SQL databases accessed with
+// database/sql, which the SQL vector store samples use for their Genkit
+// retrievers and indexers.
+//
+// A Store maps documents to the rows of a Table, and generates its queries
+// with a Dialect. Adding a database is a matter of implementing Dialect, see
+// Postgres, SQLServer, and SQLite. Table and column names are inserted into queries as
+// they are, so they must not be taken from untrusted input; values are always
+// passed as query parameters.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/indexing"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+// Table maps a table to documents. Each row is a document, whose metadata
+// holds the row's key columns and the keys of its JSON metadata column.
+type Table struct {
+	Name string
+	// Key are the columns of the row's unique key. They are stored in the
+	// document metadata keys of the same name.
+	Key []string
+	// Content is the text column that is embedded.
+	Content   string
+	Embedding string
+	// Metadata is the JSON column with the document's other metadata, if any.
+	Metadata string
+	// ID is an integer column that identifies rows, if any. SQLServer's
+	// hybrid search needs it as the key of the full-text index.
+	ID string
+	// TextSearch is the column searched by hybrid search's full-text search,
+	// e.g. a tsvector column for Postgres. Hybrid search requires it.
+	TextSearch string
+}
+
+// Columns returns the columns that dialects select for a document, prefixed
+// with alias if it isn't empty: the key columns, the content, and the
+// metadata, or NULL if the table has no metadata column.
+func (t Table) Columns(alias string) string {
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+	cols := make([]string, 0, len(t.Key)+2)
+	for _, k := range t.Key {
+		cols = append(cols, prefix+k)
+	}
+	cols = append(cols, prefix+t.Content)
+	if t.Metadata != "" {
+		cols = append(cols, prefix+t.Metadata)
+	} else {
+		cols = append(cols, "NULL")
+	}
+	return strings.Join(cols, ", ")
+}
+
+// KeyArgs returns the values of the key columns of the row of doc.
+func (t Table) KeyArgs(doc *ai.Document) ([]any, error) {
+	args := make([]any, len(t.Key))
+	for i, k := range t.Key {
+		v, ok := doc.Metadata[k]
+		if !ok {
+			return nil, fmt.Errorf("missing metadata key %q", k)
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// RowMetadata returns the JSON of the metadata of doc that isn't part of its
+// row's key. It is null if there is no such metadata, so that upserts keep the
+// row's metadata.
+func (t Table) RowMetadata(doc *ai.Document) (sql.NullString, error) {
+	meta := maps.Clone(doc.Metadata)
+	maps.DeleteFunc(meta, func(k string, _ any) bool { return slices.Contains(t.Key, k) })
+	if len(meta) == 0 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// Search holds the parts of a search query that a Dialect builds.
+type Search struct {
+	Metric retrieval.Metric
+	K      int
+	// Vector is the argument of the query's embedding, see Dialect.Vector.
+	Vector any
+	// Filter is the filter condition, or empty if there is none. Its
+	// placeholders refer to Args.
+	Filter string
+	Args   []any
+	// Text and Hybrid are the query's text and the settings of hybrid
+	// search.
+	Text   string
+	Hybrid retrieval.Hybrid
+}
+
+// Row is a row written by Dialect.Upsert.
+type Row struct {
+	Key     []any
+	Content string
+	// Embedding is the argument of the embedding, see Dialect.Vector.
+	Embedding any
+	// Metadata is the JSON of the row's metadata. If it is null, an existing
+	// row keeps its metadata.
+	Metadata sql.NullString
+}
+
+// Dialect generates the queries of a database. Placeholders are numbered with
+// Param, which filters use as well.
+type Dialect interface {
+	filter.Dialect
+	// Vector returns the query argument of an embedding.
+	Vector(embedding []float32) (any, error)
+	// Nearest returns the query for the s.K rows of t that are nearest to
+	// s.Vector, ordered by distance, and its args. The query selects
+	// t.Columns and the distance.
+	Nearest(t Table, s Search) (string, []any, error)
+	// Upsert returns the statement that inserts rows into t, or updates
+	// the rows with the same key, and its args.
+	Upsert(t Table, rows []Row) (string, []any, error)
+}
+
+// HybridDialect is a Dialect that supports hybrid search.
+type HybridDialect interface {
+	Dialect
+	// Hybrid returns the query for the s.K rows of t with the highest fused
+	// score of vector search and full-text search on t.TextSearch, see
+	// retrieval.Hybrid, and its args. The query selects t.Columns, the
+	// distance, the ranks of both searches, and the fused score. Distance
+	// and ranks are NULL if a search didn't find the row.
+	Hybrid(t Table, s Search) (string, []any, error)
+}
+
+// Params collects the arguments of a query.
+type Params struct {
+	Dialect filter.Dialect
+	Args    []any
+}
+
+// Add appends v to the arguments and returns its placeholder.
+func (p *Params) Add(v any) string {
+	ph, arg := p.Dialect.Param(len(p.Args)+1, v)
+	p.Args = append(p.Args, arg)
+	return ph
+}
+
+// And returns cond prefixed with " AND ", or an empty string if cond is empty.
+func And(cond string) string {
+	if cond == "" {
+		return ""
+	}
+	return " AND " + cond
+}
+
+// VectorText returns the text representation of an embedding, e.g.
+// "[0.1,0.2]", which pgvector and SQL Server's vector type accept.
+func VectorText(embedding []float32) string {
+	b := make([]byte, 0, len(embedding)*12)
+	b = append(b, '[')
+	for i, v := range embedding {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, float64(v), 'f', -1, 32)
+	}
+	return string(append(b, ']'))
+}
+
+// Config configures a search. Pass it to a Store's retriever with
+// ai.WithConfig.
+type Config struct {
+	// Filter restricts the rows that are searched. Conditions may refer to
+	// the table's key columns and to keys of its metadata column, e.g.
+	// "metadata.genre".
+	Filter filter.Filter `json:"filter,omitempty"`
+	retrieval.Options
+}
+
+// DecodeOptions returns a retriever's request options as T. The options are
+// a T or a *T if the retriever is called from Go, and a map if it is run from
+// the Developer UI.
+func DecodeOptions[T any](opts any) (T, error) {
+	var cfg T
+	switch o := opts.(type) {
+	case *T:
+		if o != nil {
+			cfg = *o
+		}
+	case T:
+		cfg = o
+	default:
+		b, err := json.Marshal(o)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return cfg, fmt.Errorf("invalid retriever config: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Store is a vector store in a table.
+type Store struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Table   Table
+	// Embed embeds queries, and documents written by the indexer.
+	Embed indexing.Embedder
+	// Writer writes documents and their embeddings. It defaults to Upsert,
+	// and can be replaced by a faster bulk writer of a database.
+	Writer indexing.Writer
+}
+
+// Write writes docs and their embeddings with s.Writer.
+func (s *Store) Write(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+	if s.Writer != nil {
+		return s.Writer(ctx, docs, embeddings)
+	}
+	return s.Upsert(ctx, docs, embeddings)
+}
+
+// Upsert inserts the rows of docs and their embeddings, or updates the rows
+// that exist, with a single statement.
+func (s *Store) Upsert(ctx context.Context, docs []*ai.Document, embeddings [][]float32) error {
+	rows := make([]Row, len(docs))
+	for i, doc := range docs {
+		key, err := s.Table.KeyArgs(doc)
+		if err != nil {
+			return fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		vector, err := s.Dialect.Vector(embeddings[i])
+		if err != nil {
+			return fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		metadata, err := s.Table.RowMetadata(doc)
+		if err != nil {
+			return fmt.Errorf("doc[%d]: %w", i, err)
+		}
+		rows[i] = Row{Key: key, Content: doc.Content[0].Text, Embedding: vector, Metadata: metadata}
+	}
+	stmt, args, err := s.Dialect.Upsert(s.Table, rows)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, stmt, args...)
+	return err
+}
+
+// Search returns the documents most similar to query, ordered by score. Each
+// document's metadata holds its row's key columns, the keys of its metadata
+// column, and its "score", see retrieval.Score. With hybrid search, it also
+// holds "vector_rank" and "text_rank" if the respective search found the
+// document.
+func (s *Store) Search(ctx context.Context, query *ai.Document, cfg Config) ([]*ai.Document, error) {
+	var err error
+	if cfg.Options, err = cfg.Options.WithDefaults(); err != nil {
+		return nil, err
+	}
+	embeddings, err := s.Embed(ctx, []*ai.Document{query})
+	if err != nil {
+		return nil, err
+	}
+	vector, err := s.Dialect.Vector(embeddings[0])
+	if err != nil {
+		return nil, err
+	}
+	c := filter.Compiler{Dialect: s.Dialect, Columns: s.Table.Key, JSONColumn: s.Table.Metadata}
+	where, args, err := c.Compile(cfg.Filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	search := Search{Metric: cfg.Metric, K: cfg.K, Vector: vector, Filter: where, Args: args}
+
+	var q string
+	if cfg.Hybrid != nil {
+		hd, ok := s.Dialect.(HybridDialect)
+		if !ok {
+			return nil, errors.New("the store's dialect doesn't support hybrid search")
+		}
+		search.Hybrid = *cfg.Hybrid
+		for _, p := range query.Content {
+			search.Text += p.Text
+		}
+		q, args, err = hd.Hybrid(s.Table, search)
+	} else {
+		q, args, err = s.Dialect.Nearest(s.Table, search)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []*ai.Document
+	for rows.Next() {
+		key := make([]any, len(s.Table.Key))
+		var content, metadata sql.NullString
+		var distance, fused sql.NullFloat64
+		var vectorRank, textRank sql.NullInt64
+		dest := make([]any, 0, len(key)+6)
+		for i := range key {
+			dest = append(dest, &key[i])
+		}
+		dest = append(dest, &content, &metadata, &distance)
+		if cfg.Hybrid != nil {
+			dest = append(dest, &vectorRank, &textRank, &fused)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		score := fused.Float64
+		if cfg.Hybrid == nil {
+			score = retrieval.Score(cfg.Metric, distance.Float64)
+		}
+		if !cfg.Keep(score) {
+			// Rows are ordered by score, so all remaining rows score lower
+			break
+		}
+		// The row's JSON metadata is merged into the document's metadata
+		meta := map[string]any{}
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &meta); err != nil {
+				return nil, fmt.Errorf("invalid metadata of row %v: %w", key, err)
+			}
+		}
+		for i, k := range s.Table.Key {
+			meta[k] = keyValue(key[i])
+		}
+		if vectorRank.Valid {
+			meta["vector_rank"] = vectorRank.Int64
+		}
+		if textRank.Valid {
+			meta["text_rank"] = textRank.Int64
+		}
+		meta["score"] = score
+		docs = append(docs, ai.DocumentFromText(content.String, meta))
+	}
+	return docs, rows.Err()
+}
+
+// keyValue converts a key column's value as scanned by a driver to the type
+// of document metadata: integers are ints, and text is a string.
+func keyValue(v any) any {
+	switch v := v.(type) {
+	case int64:
+		return int(v)
+	case []byte:
+		return string(v)
+	}
+	return v
+}
+
+// normalizeKey converts a key value of a document to the type of keyValue.
+// Numbers in documents decoded from JSON, e.g. by the Developer UI, are
+// float64.
+func normalizeKey(v any) (any, error) {
+	switch v := v.(type) {
+	case string, bool, int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	case float64:
+		if v != math.Trunc(v) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return int(v), nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", v, v)
+}
@@ -0,0 +1,161 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/joergjo/genkit-go-samples/vectorstore/filter"
+	"github.com/joergjo/genkit-go-samples/vectorstore/retrieval"
+)
+
+var episodes = Table{
+	Name:       "episodes",
+	Key:        []string{"show_id", "season_number"},
+	Content:    "content",
+	Embedding:  "embedding",
+	Metadata:   "metadata",
+	ID:         "id",
+	TextSearch: "content_tsv",
+}
+
+// search returns a search of t for "pizza" in the show "La Vie", whose filter
+// is compiled by d.
+func search(t *testing.T, d Dialect, metric retrieval.Metric) Search {
+	t.Helper()
+	c := filter.Compiler{Dialect: d, Columns: episodes.Key, JSONColumn: episodes.Metadata}
+	where, args, err := c.Compile(filter.Filter{filter.Eq("show_id", "La Vie")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Search{
+		Metric: metric,
+		K:      3,
+		Vector: "[0.1,0.2]",
+		Filter: where,
+		Args:   args,
+		Text:   "pizza",
+		Hybrid: retrieval.Hybrid{Candidates: 20, RankConstant: 60, VectorWeight: 1, TextWeight: 0.5},
+	}
+}
+
+var rows = []Row{
+	{Key: []any{"La Vie", 1}, Content: "a", Embedding: "[1,0]", Metadata: sql.NullString{String: `{"genre":"drama"}`, Valid: true}},
+	{Key: []any{"La Vie", 2}, Content: "b", Embedding: "[0,1]"},
+}
+
+// checkQuery compares a generated query with want, ignoring differences in
+// whitespace.
+func checkQuery(t *testing.T, got string, args []any, err error, want string, wantArgs []any) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(got), " "); got != want {
+		t.Errorf("got query\n%s\nwant\n%s", got, want)
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("got args %#v, want %#v", args, wantArgs)
+	}
+}
+
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestTableColumns(t *testing.T) {
+	if got, want := episodes.Columns(""), "show_id, season_number, content, metadata"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := episodes.Columns("e"), "e.show_id, e.season_number, e.content, e.metadata"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	noMetadata := episodes
+	noMetadata.Metadata = ""
+	if got, want := noMetadata.Columns("e"), "e.show_id, e.season_number, e.content, NULL"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTableRowMetadata(t *testing.T) {
+	doc := ai.DocumentFromText("a", map[string]any{"show_id": "La Vie", "season_number": 1, "genre": "drama"})
+	got, err := episodes.RowMetadata(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (sql.NullString{String: `{"genre":"drama"}`, Valid: true}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Only key columns keep the row's metadata
+	doc = ai.DocumentFromText("a", map[string]any{"show_id": "La Vie", "season_number": 1})
+	if got, err = episodes.RowMetadata(doc); err != nil || got.Valid {
+		t.Errorf("got %v, %v, want null", got, err)
+	}
+}
+
+func TestTablePrepare(t *testing.T) {
+	doc, id, err := episodes.prepare(ai.DocumentFromText("a", map[string]any{"show_id": "La Vie", "season_number": 1.0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "La Vie/1" {
+		t.Errorf("got ID %q, want La Vie/1", id)
+	}
+	if v := doc.Metadata["season_number"]; v != 1 {
+		t.Errorf("got season_number %#v, want 1", v)
+	}
+
+	tests := []struct {
+		name string
+		doc  *ai.Document
+		err  string
+	}{
+		{"nil", nil, "single text part"},
+		{"empty", ai.DocumentFromText("", map[string]any{"show_id": "La Vie", "season_number": 1}), "single text part"},
+		{"missing key", ai.DocumentFromText("a", map[string]any{"show_id": "La Vie"}), `missing metadata key "season_number"`},
+		{"fraction", ai.DocumentFromText("a", map[string]any{"show_id": "La Vie", "season_number": 1.5}), "1.5 is not an integer"},
+		{"unsupported", ai.DocumentFromText("a", map[string]any{"show_id": []string{}, "season_number": 1}), "unsupported value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := episodes.prepare(tt.doc)
+			checkError(t, err, tt.err)
+		})
+	}
+}
+
+func TestVectorText(t *testing.T) {
+	if got, want := VectorText([]float32{0.1, -2, 3e-8}), "[0.1,-2,0.00000003]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := VectorText(nil); got != "[]" {
+		t.Errorf("got %q, want []", got)
+	}
+}
+
+func TestDecodeOptions(t *testing.T) {
+	want := Config{Filter: filter.Filter{filter.Eq("show_id", "La Vie")}, Options: retrieval.Options{K: 3}}
+	for _, opts := range []any{want, &want} {
+		got, err := DecodeOptions[Config](opts)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, %v, want %+v", got, err, want)
+		}
+	}
+	// From the Developer UI
+	got, err := DecodeOptions[Config](map[string]any{
+		"filter": []any{map[string]any{"field": "show_id", "op": "eq", "value": "La Vie"}},
+		"k":      3,
+	})
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, %v, want %+v", got, err, want)
+	}
+	if _, err := DecodeOptions[Config](map[string]any{"k": "three"}); err == nil {
+		t.Error("no error for invalid config")
+	}
+}